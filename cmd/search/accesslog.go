@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Supported access log formats.
+const (
+	accessLogCombined = "combined"
+	accessLogJSON     = "json"
+)
+
+// accessLogger writes one line per completed request.
+type accessLogger struct {
+	format string
+	// sample maps path prefixes to the fraction of requests that get logged;
+	// a rate of 0 excludes the prefix, the longest matching prefix wins.
+	sample map[string]float64
+
+	mu  sync.Mutex
+	out io.Writer // destination of combined log lines
+}
+
+func newAccessLogger(format string, sample map[string]float64, out io.Writer) (*accessLogger, error) {
+	switch format {
+	case accessLogCombined, accessLogJSON:
+	default:
+		return nil, fmt.Errorf("unsupported access log format %q", format)
+	}
+	for prefix, rate := range sample {
+		if rate < 0 || rate > 1 {
+			return nil, fmt.Errorf("sample rate for %q must be between 0 and 1", prefix)
+		}
+	}
+	return &accessLogger{format: format, sample: sample, out: out}, nil
+}
+
+// sampled reports whether a request for the given path should be logged.
+func (al *accessLogger) sampled(path string) bool {
+	rate, match := 1.0, -1
+	for prefix, r := range al.sample {
+		if strings.HasPrefix(path, prefix) && len(prefix) > match {
+			rate, match = r, len(prefix)
+		}
+	}
+	switch {
+	case rate >= 1:
+		return true
+	case rate <= 0:
+		return false
+	}
+	return rand.Float64() < rate
+}
+
+// accessEntry collects what is known about a request while it is handled.
+// Inner middleware fills in details the access logger cannot see itself,
+// such as the authenticated user kept in the session.
+type accessEntry struct {
+	userID string
+}
+
+const contextKeyAccessEntry = contextKey("accessEntry")
+
+// setAccessLogUser records the authenticated user for the access log line.
+func setAccessLogUser(ctx context.Context, userID string) {
+	if e, ok := ctx.Value(contextKeyAccessEntry).(*accessEntry); ok {
+		e.userID = userID
+	}
+}
+
+// responseRecorder captures the status code and body size of a response.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (rec *responseRecorder) WriteHeader(code int) {
+	if rec.status == 0 {
+		rec.status = code
+	}
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += int64(n)
+	return n, err
+}
+
+// Flush keeps streaming responses working through the wrapper.
+func (rec *responseRecorder) Flush() {
+	rec.FlushError()
+}
+
+// FlushError flushes like Flush and tells http.ResponseController whether
+// the client is still there.
+func (rec *responseRecorder) FlushError() error {
+	return http.NewResponseController(rec.ResponseWriter).Flush()
+}
+
+// Unwrap gives http.ResponseController access to the underlying writer.
+func (rec *responseRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// logRequest emits an access log line after the response has completed.
+func (app *application) logRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !app.accessLog.sampled(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		entry := &accessEntry{}
+		rec := &responseRecorder{ResponseWriter: w}
+		start := time.Now()
+
+		next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), contextKeyAccessEntry, entry)))
+
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		app.writeAccessLog(r, rec, entry, time.Since(start), start)
+	})
+}
+
+func (app *application) writeAccessLog(r *http.Request, rec *responseRecorder, e *accessEntry, d time.Duration, start time.Time) {
+	al := app.accessLog
+
+	if al.format == accessLogJSON {
+		app.logger(r).InfoContext(r.Context(), "access",
+			"remote_addr", remoteClient(r).IP,
+			"proxy_addr", r.RemoteAddr,
+			"user_id", e.userID,
+			"method", r.Method,
+			"uri", r.URL.RequestURI(),
+			"proto", r.Proto,
+			"status", rec.status,
+			"bytes", rec.bytes,
+			"duration_ms", float64(d.Microseconds())/1000,
+			"referer", r.Referer(),
+			"user_agent", r.UserAgent(),
+		)
+		return
+	}
+
+	// %h %l %u %t "%r" %>s %b "%{Referer}i" "%{User-agent}i"
+	size := "-"
+	if rec.bytes > 0 {
+		size = fmt.Sprint(rec.bytes)
+	}
+	line := fmt.Sprintf("%s - %s [%s] %q %d %s %q %q\n",
+		remoteClient(r).IP,
+		dashIfEmpty(e.userID),
+		start.Format("02/Jan/2006:15:04:05 -0700"),
+		r.Method+" "+r.URL.RequestURI()+" "+r.Proto,
+		rec.status,
+		size,
+		r.Referer(),
+		r.UserAgent(),
+	)
+
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	io.WriteString(al.out, line)
+}
+
+func dashIfEmpty(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
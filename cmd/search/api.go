@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tullo/search/internal/product"
+	"github.com/tullo/search/internal/user"
+)
+
+// apiVersion is the latest version of the JSON representations. Shapes of a
+// released version never change, a field that changes its meaning or goes
+// away requires a new version.
+const apiVersion = 1
+
+// mediaTypeVendor is the media type clients can use to ask for a specific
+// version, e.g. application/vnd.search.v1+json.
+const mediaTypeVendor = "application/vnd.search"
+
+// contextKeyAPIVersion holds the JSON version negotiated for the request.
+const contextKeyAPIVersion = contextKey("apiVersion")
+
+// negotiate decides whether the response is rendered as HTML or as JSON.
+// JSON is chosen by ?format=json or by an Accept header preferring a JSON
+// media type over HTML. An unsupported version is refused with 406.
+func (app *application) negotiate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept")
+
+		version, ok := negotiateVersion(r)
+		if !ok {
+			writeJSONError(w, r, http.StatusNotAcceptable, "")
+			return
+		}
+		if version == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), contextKeyAPIVersion, version)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// negotiateVersion returns the JSON version asked for by the request, or 0
+// if HTML is wanted. It reports false if the version is not supported.
+func negotiateVersion(r *http.Request) (int, bool) {
+	q := r.URL.Query()
+	switch q.Get("format") {
+	case "json":
+		return supportedVersion(q.Get("version"))
+	case "html":
+		return 0, true
+	}
+
+	var htmlQ, wildQ, jsonQ float64
+	var version string
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mt, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if s, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(s, 64); err != nil {
+				continue
+			}
+		}
+
+		switch {
+		case mt == "text/html" || mt == "application/xhtml+xml":
+			htmlQ = max(htmlQ, q)
+		case mt == "*/*" || mt == "text/*":
+			wildQ = max(wildQ, q)
+		case mt == "application/json" || mt == mediaTypeVendor+"+json":
+			if q > jsonQ {
+				jsonQ, version = q, params["version"]
+			}
+		case strings.HasPrefix(mt, mediaTypeVendor+".v") && strings.HasSuffix(mt, "+json"):
+			if q > jsonQ {
+				jsonQ = q
+				version = strings.TrimSuffix(strings.TrimPrefix(mt, mediaTypeVendor+".v"), "+json")
+			}
+		}
+	}
+
+	// an explicit JSON type wins over a wildcard with the same preference
+	if jsonQ == 0 || jsonQ <= htmlQ || jsonQ < wildQ {
+		return 0, true
+	}
+	return supportedVersion(version)
+}
+
+// supportedVersion parses a requested version, an empty one is the latest.
+func supportedVersion(s string) (int, bool) {
+	if s == "" {
+		return apiVersion, true
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < 1 || v > apiVersion {
+		return 0, false
+	}
+	return v, true
+}
+
+// wantsJSON reports whether the response should be rendered as JSON.
+func wantsJSON(r *http.Request) bool {
+	v, _ := r.Context().Value(contextKeyAPIVersion).(int)
+	return v > 0
+}
+
+// envelope wraps every JSON response, clients check the version before
+// looking at the data.
+type envelope struct {
+	Version int       `json:"version"`
+	Data    any       `json:"data,omitempty"`
+	Meta    any       `json:"meta,omitempty"`
+	Error   *apiError `json:"error,omitempty"`
+}
+
+// apiError is the error object of a failed JSON request.
+type apiError struct {
+	Status    int    `json:"status"`
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// writeJSON writes the envelope with the version negotiated for the request.
+func writeJSON(w http.ResponseWriter, r *http.Request, status int, env envelope) {
+	env.Version, _ = r.Context().Value(contextKeyAPIVersion).(int)
+	if env.Version == 0 {
+		env.Version = apiVersion
+	}
+
+	buf, err := json.Marshal(env)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write(append(buf, '\n'))
+}
+
+// writeJSONError writes an error object, the message defaults to the status text.
+func writeJSONError(w http.ResponseWriter, r *http.Request, status int, message string) {
+	text := http.StatusText(status)
+	if message == "" {
+		message = text
+	}
+	writeJSON(w, r, status, envelope{Error: &apiError{
+		Status:    status,
+		Code:      strings.ReplaceAll(strings.ToLower(text), " ", "_"),
+		Message:   message,
+		RequestID: requestIDFromContext(r.Context()),
+	}})
+}
+
+// productV1 is version 1 of the JSON representation of a product. Amounts
+// are in the minor unit of the currency named in the meta data.
+type productV1 struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	Cost        int64     `json:"cost"`
+	Quantity    int       `json:"quantity"`
+	Sold        int       `json:"sold"`
+	Revenue     int64     `json:"revenue"`
+	UserID      string    `json:"user_id"`
+	DateCreated time.Time `json:"date_created"`
+	DateUpdated time.Time `json:"date_updated"`
+}
+
+func newProductV1(p product.Product) productV1 {
+	return productV1{
+		ID:          p.ID,
+		Name:        p.Name,
+		Cost:        int64(p.Cost),
+		Quantity:    p.Quantity,
+		Sold:        p.Sold,
+		Revenue:     int64(p.Revenue),
+		UserID:      p.UserID,
+		DateCreated: p.DateCreated,
+		DateUpdated: p.DateUpdated,
+	}
+}
+
+// userV1 is version 1 of the JSON representation of a user.
+type userV1 struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	Email       string    `json:"email"`
+	DateCreated time.Time `json:"date_created"`
+	DateUpdated time.Time `json:"date_updated"`
+}
+
+func newUserV1(u user.User) userV1 {
+	return userV1{
+		ID:          u.ID,
+		Name:        u.Name,
+		Email:       u.Email,
+		DateCreated: u.DateCreated,
+		DateUpdated: u.DateUpdated,
+	}
+}
+
+// productMeta is the meta data of product responses.
+type productMeta struct {
+	Currency   string      `json:"currency"`
+	Pagination *pagination `json:"pagination,omitempty"`
+	DidYouMean []string    `json:"did_you_mean,omitempty"`
+}
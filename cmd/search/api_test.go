@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNegotiateVersion(t *testing.T) {
+	tests := []struct {
+		name        string
+		target      string
+		accept      string
+		wantVersion int
+		wantOK      bool
+	}{
+		{"Browser", "/", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", 0, true},
+		{"No Accept header", "/", "", 0, true},
+		{"Wildcard only", "/", "*/*", 0, true},
+		{"JSON", "/", "application/json", 1, true},
+		{"JSON before wildcard", "/", "application/json, text/plain, */*", 1, true},
+		{"HTML preferred", "/", "application/json;q=0.5, text/html", 0, true},
+		{"JSON preferred", "/", "text/html;q=0.5, application/json", 1, true},
+		{"Vendor type", "/", "application/vnd.search.v1+json", 1, true},
+		{"Version parameter", "/", "application/json; version=1", 1, true},
+		{"Unsupported version", "/", "application/vnd.search.v2+json", 0, false},
+		{"Query", "/?format=json", "text/html", 1, true},
+		{"Query with version", "/?format=json&version=1", "", 1, true},
+		{"Query with unsupported version", "/?format=json&version=x", "", 0, false},
+		{"Query asks for HTML", "/?format=html", "application/json", 0, true},
+		{"Other formats", "/?format=csv", "", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", tt.target, nil)
+			if tt.accept != "" {
+				r.Header.Set("Accept", tt.accept)
+			}
+
+			version, ok := negotiateVersion(r)
+			if version != tt.wantVersion || ok != tt.wantOK {
+				t.Errorf("want %d, %t; got %d, %t", tt.wantVersion, tt.wantOK, version, ok)
+			}
+		})
+	}
+}
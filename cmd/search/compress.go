@@ -0,0 +1,254 @@
+package main
+
+import (
+	"compress/gzip"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// compressMinSize is the body size below which compressing does not pay off.
+const compressMinSize = 1024
+
+// compressTypes are the media types worth compressing. Images, fonts and
+// archives are compressed already, event streams must reach the browser
+// message by message.
+var compressTypes = map[string]bool{
+	"application/javascript": true,
+	"application/json":       true,
+	"application/xml":        true,
+	"image/svg+xml":          true,
+	"text/css":               true,
+	"text/csv":               true,
+	"text/html":              true,
+	"text/javascript":        true,
+	"text/plain":             true,
+	"text/xml":               true,
+}
+
+var gzipWriters = sync.Pool{
+	New: func() any {
+		zw, _ := gzip.NewWriterLevel(nil, gzip.DefaultCompression)
+		return zw
+	},
+}
+
+// compress gzips the responses of clients accepting it. It goes in front of
+// recoverPanic, so that error pages are compressed as well, and behind the
+// access log, which records the bytes sent.
+func (app *application) compress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressWriter{
+			ResponseWriter: w,
+			accepted:       acceptsEncoding(r.Header.Get("Accept-Encoding"), "gzip"),
+		}
+		next.ServeHTTP(cw, r)
+		cw.close()
+	})
+}
+
+// compressWriter holds back the first compressMinSize bytes of a response
+// to decide whether to compress it. Headers set by the handler until then,
+// the security headers and the CSRF cookie among them, go out unchanged.
+type compressWriter struct {
+	http.ResponseWriter
+	accepted bool // by the client
+
+	status  int
+	buf     []byte
+	decided bool
+	zw      *gzip.Writer // nil unless compressing
+}
+
+func (cw *compressWriter) WriteHeader(code int) {
+	if cw.decided {
+		// net/http reports the superfluous call
+		cw.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if cw.status != 0 {
+		return
+	}
+	if code < http.StatusOK {
+		// informational responses go out right away
+		cw.ResponseWriter.WriteHeader(code)
+		return
+	}
+	cw.status = code
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if !cw.decided {
+		if cw.status == 0 {
+			cw.status = http.StatusOK
+		}
+		cw.buf = append(cw.buf, b...)
+		if len(cw.buf) < compressMinSize && !cw.knownLarge() {
+			return len(b), nil
+		}
+		if err := cw.decide(len(cw.buf)); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+	if cw.zw != nil {
+		return cw.zw.Write(b)
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+// knownLarge reports whether the handler announced a body large enough to
+// be compressed.
+func (cw *compressWriter) knownLarge() bool {
+	n, err := strconv.Atoi(cw.Header().Get("Content-Length"))
+	return err == nil && n >= compressMinSize
+}
+
+// decide writes the header, compressing the body if it is of a compressible
+// type and at least compressMinSize bytes long, and writes the buffered
+// bytes.
+func (cw *compressWriter) decide(size int) error {
+	cw.decided = true
+
+	h := cw.Header()
+	if h.Get("Content-Type") == "" && len(cw.buf) > 0 {
+		// sniffing the gzipped body would not work
+		h.Set("Content-Type", http.DetectContentType(cw.buf))
+	}
+
+	if cw.compressible() {
+		if !varies(h, "Accept-Encoding") {
+			h.Add("Vary", "Accept-Encoding")
+		}
+		if cw.accepted && size >= compressMinSize {
+			h.Del("Content-Length")
+			h.Set("Content-Encoding", "gzip")
+			// the compressed body is not byte for byte the same
+			if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+				h.Set("ETag", "W/"+etag)
+			}
+			cw.zw = gzipWriters.Get().(*gzip.Writer)
+			cw.zw.Reset(cw.ResponseWriter)
+		}
+	}
+
+	cw.ResponseWriter.WriteHeader(cw.status)
+	buf := cw.buf
+	cw.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	var err error
+	if cw.zw != nil {
+		_, err = cw.zw.Write(buf)
+	} else {
+		_, err = cw.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+// compressible reports whether the response may be compressed.
+func (cw *compressWriter) compressible() bool {
+	switch cw.status {
+	case http.StatusNoContent, http.StatusNotModified, http.StatusPartialContent:
+		return false
+	}
+	h := cw.Header()
+	if h.Get("Content-Encoding") != "" || h.Get("Content-Range") != "" {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(h.Get("Content-Type"))
+	return err == nil && compressTypes[mediaType]
+}
+
+// Flush sends what was written so far, streaming responses are decided
+// upon by what the handler wrote before the first flush.
+func (cw *compressWriter) Flush() {
+	cw.FlushError()
+}
+
+// FlushError flushes like Flush and tells http.ResponseController whether
+// the client is still there.
+func (cw *compressWriter) FlushError() error {
+	if !cw.decided {
+		if cw.status == 0 {
+			cw.status = http.StatusOK
+		}
+		if err := cw.decide(compressMinSize); err != nil {
+			return err
+		}
+	}
+	if cw.zw != nil {
+		if err := cw.zw.Flush(); err != nil {
+			return err
+		}
+	}
+	return http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+// Unwrap gives http.ResponseController access to the underlying writer.
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// close writes a response too small to be compressed and ends the gzip
+// stream of a compressed one.
+func (cw *compressWriter) close() {
+	if !cw.decided {
+		if cw.status == 0 && len(cw.buf) == 0 {
+			// nothing written, net/http sends the 200
+			return
+		}
+		if cw.status == 0 {
+			cw.status = http.StatusOK
+		}
+		cw.decide(len(cw.buf))
+	}
+	if cw.zw != nil {
+		cw.zw.Close()
+		cw.zw.Reset(nil)
+		gzipWriters.Put(cw.zw)
+		cw.zw = nil
+	}
+}
+
+// varies reports whether the Vary header of h names the request header.
+func varies(h http.Header, name string) bool {
+	for _, v := range h.Values("Vary") {
+		for _, field := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(field), name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// acceptsEncoding reports whether an Accept-Encoding header allows the
+// content coding, explicitly or through "*".
+func acceptsEncoding(header, coding string) bool {
+	wildcard := false
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		switch {
+		case strings.EqualFold(strings.TrimSpace(name), coding):
+			return q > 0
+		case strings.TrimSpace(name) == "*":
+			wildcard = q > 0
+		}
+	}
+	return wildcard
+}
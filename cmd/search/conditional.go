@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"html/template"
+	"net/http"
+	"strings"
+
+	"github.com/justinas/nosurf"
+)
+
+// revalidate is the Cache-Control of the pages answering conditional
+// requests. They are per user, so only the browser may store them, and it
+// has to ask whether they changed before showing them again.
+const revalidate = "private, no-cache"
+
+// renderConditional renders a page validated by an ETag. A request holding
+// the current version of the page is answered with 304 Not Modified.
+//
+// The ETag hashes the complete rendered page, so that everything personal
+// in it, the user, language, time zone and flash message, is covered. Only
+// the CSP nonce and the masked CSRF token differ in every response, they
+// are left out in favour of the CSRF cookie they derive from. The pages
+// have no Last-Modified time: the version of the data shown covers neither
+// what is personal nor products being deleted.
+func (app *application) renderConditional(w http.ResponseWriter, r *http.Request, name string, data *templateData) {
+	buf, ok := app.execute(w, r, name, data)
+	if !ok {
+		return
+	}
+
+	h := sha256.New()
+	page := buf.Bytes()
+	for _, per := range []string{data.CSPNonce, data.CSRFToken} {
+		if per != "" {
+			page = bytes.ReplaceAll(page, []byte(attrEscaped(per)), nil)
+		}
+	}
+	h.Write(page)
+	if c, err := r.Cookie(nosurf.CookieName); err == nil {
+		h.Write([]byte(c.Value))
+	}
+	etag := `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+
+	header := w.Header()
+	// replaces the no-store of requireAuthentication, the page is
+	// revalidated on every use instead
+	header.Set("Cache-Control", revalidate)
+	header.Set("ETag", etag)
+
+	if notModified(r, etag) {
+		// The browser updates the stored headers with those of the 304.
+		// The stored page carries the nonce of the stored policy.
+		header.Del("Content-Security-Policy")
+		header.Del("Content-Security-Policy-Report-Only")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	header.Set("Content-Type", "text/html; charset=utf-8")
+	buf.WriteTo(w)
+}
+
+// attrTemplate escapes a value the way the pages do in attributes.
+var attrTemplate = template.Must(template.New("attr").Parse(`<p title='{{.}}'>`))
+
+// attrEscaped returns s as it appears in an attribute of a rendered page,
+// html/template turns the + of base64 into &#43; there.
+func attrEscaped(s string) string {
+	var b strings.Builder
+	attrTemplate.Execute(&b, s)
+	return strings.TrimSuffix(strings.TrimPrefix(b.String(), "<p title='"), "'>")
+}
+
+// notModified reports whether the request holds the current version of a
+// response. If-Modified-Since is ignored.
+func notModified(r *http.Request, etag string) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	inm := r.Header.Get("If-None-Match")
+	return inm != "" && etagMatch(inm, etag)
+}
+
+// etagMatch compares the entity tags of an If-None-Match header weakly with
+// etag. Compressed responses carry the weak form of the ETag.
+func etagMatch(header, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimSpace(t)
+		if t == "*" || strings.TrimPrefix(t, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
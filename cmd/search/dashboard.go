@@ -0,0 +1,81 @@
+package main
+
+import (
+	"unicode/utf8"
+
+	"github.com/tullo/search/internal/product"
+	"github.com/tullo/search/internal/sanitize"
+)
+
+// Layout of the bar charts, in SVG user units.
+const (
+	chartWidth      = 600
+	chartLabelWidth = 220
+	chartValueWidth = 110
+	chartRowHeight  = 24
+	chartLabelRunes = 26
+)
+
+// dashboardView is what the dashboard page renders.
+type dashboardView struct {
+	Summary *product.Summary
+	Revenue chart
+	Sold    chart
+}
+
+// chart is a horizontal bar chart, rendered as SVG on the server so that
+// the dashboard works without JavaScript.
+type chart struct {
+	ID     string
+	Title  string
+	Width  int
+	Height int
+	Bars   []chartBar
+}
+
+type chartBar struct {
+	Label  string // plain text name of the product
+	Value  string // formatted value
+	Y      int
+	X      int // start of the bar
+	Width  int
+	ValueX int
+}
+
+// newChart charts the value of the products, the bar of the greatest value
+// spans the available width.
+func newChart(id, title string, ps []product.Product, value func(*product.Product) int64, format func(int64) string) chart {
+	c := chart{ID: id, Title: title, Width: chartWidth, Height: len(ps) * chartRowHeight}
+
+	var greatest int64
+	for i := range ps {
+		greatest = max(greatest, value(&ps[i]))
+	}
+
+	span := chartWidth - chartLabelWidth - chartValueWidth
+	for i := range ps {
+		v := value(&ps[i])
+		width := 0
+		if greatest > 0 && v > 0 {
+			width = max(1, int(float64(v)/float64(greatest)*float64(span)))
+		}
+		c.Bars = append(c.Bars, chartBar{
+			Label:  truncate(sanitize.Inline.Text(ps[i].Name), chartLabelRunes),
+			Value:  format(v),
+			Y:      i * chartRowHeight,
+			X:      chartLabelWidth,
+			Width:  width,
+			ValueX: chartLabelWidth + width + 6,
+		})
+	}
+
+	return c
+}
+
+// truncate shortens s to n runes, marking the cut with an ellipsis.
+func truncate(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n-1]) + "…"
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go/v4"
+	"github.com/tullo/search/internal/product"
+)
+
+// eventRetry is how long a browser waits before reconnecting a closed
+// event stream.
+const eventRetry = 2 * time.Second
+
+var (
+	errTooManyStreams = errors.New("too many event streams")
+	errSlowStream     = errors.New("event stream fell behind")
+	errTokenRejected  = errors.New("token rejected by the sales-api")
+)
+
+// eventHub hands the changes of the product set to the event streams of
+// the authenticated sessions. The sales-api shows every token scope its
+// own products, so a stream only gets the changes of its scope. A stream
+// holds at most buffer changes, one falling further behind is closed and
+// told to reload the listing instead of holding up the others.
+type eventHub struct {
+	buffer    int
+	perUser   int
+	heartbeat time.Duration
+
+	mu    sync.Mutex
+	subs  map[*subscriber]struct{}
+	users map[string]int // streams per user
+	seq   uint64
+}
+
+// subscriber is an event stream. Its channel is closed when the hub drops
+// it, err tells why.
+type subscriber struct {
+	userID string
+	token  string
+	scope  string
+	seq    uint64 // orders the streams by age
+	ch     chan []product.Change
+	err    error
+}
+
+func newEventHub(buffer, perUser int, heartbeat time.Duration) *eventHub {
+	return &eventHub{
+		buffer:    buffer,
+		perUser:   perUser,
+		heartbeat: heartbeat,
+		subs:      make(map[*subscriber]struct{}),
+		users:     make(map[string]int),
+	}
+}
+
+// subscribe opens a stream of the user reading with token.
+func (h *eventHub) subscribe(userID, token string) (*subscriber, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.perUser > 0 && h.users[userID] >= h.perUser {
+		return nil, errTooManyStreams
+	}
+	h.seq++
+	s := &subscriber{
+		userID: userID,
+		token:  token,
+		scope:  tokenScope(token),
+		seq:    h.seq,
+		ch:     make(chan []product.Change, h.buffer),
+	}
+	h.subs[s] = struct{}{}
+	h.users[userID]++
+	return s, nil
+}
+
+// unsubscribe closes the stream unless the hub dropped it already.
+func (h *eventHub) unsubscribe(s *subscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.drop(s, nil)
+}
+
+// drop removes s, h.mu is held.
+func (h *eventHub) drop(s *subscriber, err error) {
+	if _, ok := h.subs[s]; !ok {
+		return
+	}
+	delete(h.subs, s)
+	if h.users[s.userID]--; h.users[s.userID] == 0 {
+		delete(h.users, s.userID)
+	}
+	s.err = err
+	close(s.ch)
+}
+
+// publish hands changes to every stream of scope without waiting for any.
+func (h *eventHub) publish(scope string, changes []product.Change) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for s := range h.subs {
+		if s.scope != scope {
+			continue
+		}
+		select {
+		case s.ch <- changes:
+		default:
+			h.drop(s, errSlowStream)
+		}
+	}
+}
+
+// tokens returns the token of the newest stream of every scope listened
+// to, the newest being the likeliest to still be valid.
+func (h *eventHub) tokens() map[string]string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	tokens := make(map[string]string)
+	newest := make(map[string]uint64)
+	for s := range h.subs {
+		if s.seq > newest[s.scope] {
+			newest[s.scope] = s.seq
+			tokens[s.scope] = s.token
+		}
+	}
+	return tokens
+}
+
+// reject closes the streams reading with a token the sales-api refused.
+func (h *eventHub) reject(token string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for s := range h.subs {
+		if s.token == token {
+			h.drop(s, errTokenRejected)
+		}
+	}
+}
+
+// watchProducts reads the product set every interval while streams are
+// open and publishes what changed. It returns when ctx is done.
+func (app *application) watchProducts(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var last map[string][]product.Product
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			last = app.pollProducts(ctx, last)
+		}
+	}
+}
+
+// pollProducts reads the product set of every scope listened to and
+// publishes its changes since last to the streams of that scope. It
+// returns the new snapshots by scope, without the scopes nobody listens
+// to, so that the next stream of one does not get the changes of the time
+// nobody listened.
+func (app *application) pollProducts(ctx context.Context, last map[string][]product.Product) map[string][]product.Product {
+	tokens := app.events.tokens()
+	if len(tokens) == 0 {
+		return nil
+	}
+
+	next := make(map[string][]product.Product, len(tokens))
+	for scope, token := range tokens {
+		products, err := app.readCatalogue(ctx, token)
+		if err != nil {
+			if upstreamStatus(err) == http.StatusUnauthorized {
+				app.events.reject(token)
+			} else {
+				app.log.Warn("polling products", "scope", scope, "error", err)
+			}
+			if prev, ok := last[scope]; ok {
+				next[scope] = prev
+			}
+			continue
+		}
+
+		if prev, ok := last[scope]; ok {
+			if changes := product.Diff(prev, products); len(changes) > 0 {
+				app.events.publish(scope, changes)
+				// the suggestions follow the sales
+				app.updateSuggestions(scope, products)
+			}
+		}
+		next[scope] = products
+	}
+	return next
+}
+
+// productChange is a change of the product set as the listing shows it.
+type productChange struct {
+	Op       product.ChangeOp `json:"op"`
+	ID       string           `json:"id"`
+	Name     string           `json:"name"` // sanitized HTML
+	Cost     string           `json:"cost"`
+	Quantity int              `json:"quantity"`
+	Sold     int              `json:"sold"`
+	Revenue  string           `json:"revenue"`
+}
+
+// productEvents streams the changes of the product set to the listing of
+// an authenticated session. The stream ends when the sales-api token of the
+// session expires, the browser reconnects with a fresh one if it has any.
+func (app *application) productEvents(w http.ResponseWriter, r *http.Request) {
+	token := app.token(r)
+	expires := tokenExpiry(token)
+	if !expires.IsZero() && !expires.After(time.Now()) {
+		app.clientError(w, r, http.StatusUnauthorized)
+		return
+	}
+
+	s, err := app.events.subscribe(app.session.GetString(r, "authenticatedUserID"), token)
+	if err != nil {
+		app.clientError(w, r, http.StatusTooManyRequests)
+		return
+	}
+	defer app.events.unsubscribe(s)
+
+	// the stream stays open longer than the write timeout of the server
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		app.serverError(w, r, fmt.Errorf("streaming events: %w", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", eventRetry.Milliseconds())
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(app.events.heartbeat)
+	defer heartbeat.Stop()
+	var expired <-chan time.Time
+	if !expires.IsZero() {
+		t := time.NewTimer(time.Until(expires))
+		defer t.Stop()
+		expired = t.C
+	}
+
+	money := app.moneyFormat(app.localizer(r).Lang())
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-expired:
+			return
+		case <-heartbeat.C:
+			// keeps proxies from closing the idle connection
+			fmt.Fprint(w, ": heartbeat\n\n")
+		case changes, ok := <-s.ch:
+			if !ok {
+				if errors.Is(s.err, errSlowStream) {
+					fmt.Fprint(w, "event: reset\ndata: {}\n\n")
+					rc.Flush()
+				}
+				return
+			}
+			data := make([]productChange, len(changes))
+			for i, c := range changes {
+				data[i] = productChange{
+					Op:       c.Op,
+					ID:       c.Product.ID,
+					Name:     string(c.Product.NameHTML()),
+					Cost:     money.Format(c.Product.Cost),
+					Quantity: c.Product.Quantity,
+					Sold:     c.Product.Sold,
+					Revenue:  money.Format(c.Product.Revenue),
+				}
+			}
+			js, err := json.Marshal(data)
+			if err != nil {
+				app.logger(r).Error("encoding product changes", "error", err)
+				return
+			}
+			fmt.Fprintf(w, "event: products\ndata: %s\n\n", js)
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// tokenExpiry returns when a sales-api token expires, the zero time if it
+// does not tell.
+func tokenExpiry(token string) time.Time {
+	var claims salesClaims
+	parser := jwt.NewParser(jwt.WithValidMethods([]string{"RS256"}))
+	if _, _, err := parser.ParseUnverified(token, &claims); err != nil || claims.ExpiresAt == nil {
+		return time.Time{}
+	}
+	return claims.ExpiresAt.Time
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tullo/search/internal/product"
+)
+
+func TestEventHub(t *testing.T) {
+	h := newEventHub(1, 2, time.Minute)
+	tokenA, tokenB := testToken("user-1"), testToken("user-2")
+
+	a, err := h.subscribe("user-1", tokenA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := h.subscribe("user-1", tokenA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.subscribe("user-1", tokenA); !errors.Is(err, errTooManyStreams) {
+		t.Errorf("want %v; got %v", errTooManyStreams, err)
+	}
+	c, err := h.subscribe("user-2", tokenB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"user:user-1": tokenA, "user:user-2": tokenB}
+	if tokens := h.tokens(); !maps.Equal(tokens, want) {
+		t.Errorf("want a token per scope %v; got %v", want, tokens)
+	}
+
+	// a stream that does not keep up is dropped, the others keep going
+	changes := []product.Change{{Op: product.Updated, Product: product.Product{ID: "1"}}}
+	h.publish("user:user-1", changes)
+	<-a.ch
+	h.publish("user:user-1", changes)
+	<-a.ch
+	if _, ok := <-b.ch; !ok {
+		t.Fatal("want the first changes")
+	}
+	if _, ok := <-b.ch; ok || !errors.Is(b.err, errSlowStream) {
+		t.Errorf("want the slow stream dropped; got %v", b.err)
+	}
+	if _, err := h.subscribe("user-1", tokenA); err != nil {
+		t.Errorf("want the dropped stream not to count; got %v", err)
+	}
+
+	// the changes of a scope stay in it
+	if len(c.ch) != 0 {
+		t.Error("want no changes of another scope")
+	}
+	h.publish("user:user-2", changes)
+	<-c.ch
+
+	// a rejected token closes its streams
+	h.reject(tokenB)
+	for range c.ch {
+	}
+	if !errors.Is(c.err, errTokenRejected) {
+		t.Errorf("want %v; got %v", errTokenRejected, c.err)
+	}
+	if _, ok := h.tokens()["user:user-2"]; ok {
+		t.Error("want no token of the rejected scope")
+	}
+
+	h.unsubscribe(a)
+	h.unsubscribe(c) // dropped already
+	if _, ok := <-a.ch; ok {
+		t.Error("want the stream closed")
+	}
+}
+
+func TestProductEvents(t *testing.T) {
+	products := testProducts
+	testProducts = slices.Clone(testProducts)
+	t.Cleanup(func() { testProducts = products })
+
+	api := newSalesAPI(t)
+	app := newTestApplication(t)
+	app.salesURL = api.URL
+	app.responses = nil
+	app.events.heartbeat = 20 * time.Millisecond
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// only authenticated sessions listen
+	if code, _, _ := ts.get(t, "/events"); code != http.StatusSeeOther {
+		t.Errorf("want %d; got %d", http.StatusSeeOther, code)
+	}
+	ctx := context.Background()
+	if last := app.pollProducts(ctx, nil); last != nil {
+		t.Error("want no polling without listeners")
+	}
+
+	ts.login(t)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+"/events", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rs.Body.Close()
+	if ct := rs.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("want an event stream; got %q", ct)
+	}
+
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		sc := bufio.NewScanner(rs.Body)
+		for sc.Scan() {
+			lines <- sc.Text()
+		}
+	}()
+	next := func(prefix string) string {
+		t.Helper()
+		timeout := time.After(5 * time.Second)
+		for {
+			select {
+			case l, ok := <-lines:
+				if !ok {
+					t.Fatalf("stream ended waiting for %q", prefix)
+				}
+				if strings.HasPrefix(l, prefix) {
+					return l
+				}
+			case <-timeout:
+				t.Fatalf("timed out waiting for %q", prefix)
+			}
+		}
+	}
+	next("retry: ")
+	next(": heartbeat")
+
+	last := app.pollProducts(ctx, nil)
+	if n := len(last["role:ADMIN"]); len(last) != 1 || n != len(testProducts) {
+		t.Fatalf("want %d products of the admins; got %v", len(testProducts), last)
+	}
+
+	testProducts[0].Sold++
+	testProducts[0].Revenue += testProducts[0].Cost
+	testProducts = testProducts[:1]
+	app.pollProducts(ctx, last)
+
+	next("event: products")
+	data := next("data: ")
+	for _, want := range []string{
+		`"op":"updated","id":"` + testProducts[0].ID + `"`,
+		`"sold":4`,
+		`"revenue":"$300.00"`,
+		`"op":"deleted","id":"` + products[1].ID + `"`,
+	} {
+		if !strings.Contains(data, want) {
+			t.Errorf("want %s in %s", want, data)
+		}
+	}
+}
+
+func TestProductEventsWriteTimeout(t *testing.T) {
+	api := newSalesAPI(t)
+	app := newTestApplication(t)
+	app.salesURL = api.URL
+	app.events.heartbeat = 20 * time.Millisecond
+
+	const timeout = 200 * time.Millisecond
+	ts := newTestServer(t, app.routes(), func(s *http.Server) { s.WriteTimeout = timeout })
+	defer ts.Close()
+	ts.login(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+"/events", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rs.Body.Close()
+
+	// heartbeats keep arriving on the same stream well past the timeout
+	start := time.Now()
+	sc := bufio.NewScanner(rs.Body)
+	for time.Since(start) < 3*timeout {
+		if !sc.Scan() {
+			t.Fatalf("stream ended after %v: %v", time.Since(start).Round(time.Millisecond), sc.Err())
+		}
+	}
+}
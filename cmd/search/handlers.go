@@ -4,12 +4,19 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"math"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/dgrijalva/jwt-go/v4"
+	"github.com/tullo/search/internal/export"
 	"github.com/tullo/search/internal/forms"
 	"github.com/tullo/search/internal/product"
+	"github.com/tullo/search/internal/ratelimit"
+	"github.com/tullo/search/internal/sanitize"
 	"github.com/tullo/search/internal/user"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
@@ -17,6 +24,10 @@ import (
 
 const name = "search"
 
+// exportWriteTimeout is the time granted for reading or writing a page of
+// exported rows.
+const exportWriteTimeout = 30 * time.Second
+
 func (app *application) ping(w http.ResponseWriter, r *http.Request) {
 
 	ctx, cancel := context.WithTimeout(r.Context(), time.Second)
@@ -55,55 +66,84 @@ func (app *application) home(w http.ResponseWriter, r *http.Request) {
 	ctx, span := otel.Tracer(name).Start(r.Context(), "home")
 	defer span.End()
 
-	// Create a context with a timeout of 1 second.
-	ctx, cancel := context.WithTimeout(ctx, time.Second)
-	defer cancel()
-
-	var (
-		page        = 1
-		rowsPerPage = 20
-	)
-	url := fmt.Sprintf("%s/products/%d/%d", app.salesURL, page, rowsPerPage)
-	req, err := app.newGetRequest(ctx, r, url)
+	page, rowsPerPage, err := pageParams(r.URL.Query())
 	if err != nil {
-		app.serverError(w, err)
+		app.clientError(w, r, http.StatusBadRequest)
 		return
 	}
-
-	span.AddEvent("Lookup Products")
-	span.SetAttributes(attribute.String("url", url))
-
-	// Client.Do will handle the context level timeout.
-	client := newClient()
-	resp, err := client.Do(req)
+	query, err := app.listingQuery(r.URL.Query())
 	if err != nil {
-		app.serverError(w, err)
+		app.clientError(w, r, http.StatusBadRequest)
 		return
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode == http.StatusUnauthorized {
+	span.AddEvent("Lookup Products")
+	span.SetAttributes(attribute.Int("page", page), attribute.String("sort", query.Order))
+
+	var products, listing []product.Product
+	total := -1
+	if query.IsZero() {
+		products, err = app.fetchProducts(ctx, app.token(r), page, rowsPerPage)
+	} else {
+		// The sales-api can neither filter nor sort, so the whole listing
+		// is read and the page is cut out here.
+		var matched []product.Product
+		matched, listing, err = app.queryProducts(ctx, app.token(r), query)
+		from := min((page-1)*rowsPerPage, len(matched))
+		products = matched[from:min(from+rowsPerPage, len(matched))]
+		total = len(matched)
+	}
+	switch {
+	case upstreamStatus(err) == http.StatusUnauthorized && !wantsJSON(r):
 		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
 		return
+	case upstreamStatus(err) == http.StatusUnauthorized:
+		app.clientError(w, r, http.StatusUnauthorized)
+		return
+	case err != nil:
+		app.serverError(w, r, err)
+		return
 	}
 
-	// Decode json response into products.
-	var products []product.Product
-	if err := json.NewDecoder(resp.Body).Decode(&products); err != nil {
-		app.serverError(w, err)
-		return
+	pages := newPagination(r.URL, page, rowsPerPage, len(products))
+	if total >= 0 {
+		pages.setTotal(total)
 	}
 
-	span.AddEvent("Render Home Page")
+	var didYouMean []string
+	if total == 0 {
+		span.AddEvent("Find Alternatives")
+		didYouMean = app.didYouMean(query, listing)
+	}
 
-	if err != nil {
-		app.serverError(w, err)
+	if wantsJSON(r) {
+		data := make([]productV1, len(products))
+		for i, p := range products {
+			data[i] = newProductV1(p)
+		}
+		writeJSON(w, r, http.StatusOK, envelope{
+			Data: data,
+			Meta: productMeta{Currency: app.money.Currency().Code, Pagination: pages, DidYouMean: didYouMean},
+		})
 		return
 	}
 
-	app.render(w, r, "home.page.tmpl", &templateData{
-		Path:     "/product",
-		Products: products,
+	var creators map[string]*user.User
+	if app.creatorColumn {
+		span.AddEvent("Resolve Creators")
+		creators = app.resolveCreators(ctx, r, products)
+	}
+
+	span.AddEvent("Render Home Page")
+
+	app.renderConditional(w, r, "home.page.tmpl", &templateData{
+		CreatorColumn: app.creatorColumn,
+		Creators:      creators,
+		DidYouMean:    didYouMean,
+		Pagination:    pages,
+		Path:          "/product",
+		Products:      products,
+		Query:         query,
 	})
 }
 
@@ -116,52 +156,236 @@ func (app *application) showProduct(w http.ResponseWriter, r *http.Request) {
 	ctx, span := otel.Tracer(name).Start(r.Context(), "showProduct")
 	defer span.End()
 
-	// Create a context with a timeout of 1 second.
-	ctx, cancel := context.WithTimeout(ctx, time.Second)
-	defer cancel()
-
-	id := r.URL.Query().Get(":id")
-	url := fmt.Sprintf("%s/products/%s", app.salesURL, id)
-	req, err := app.newGetRequest(ctx, r, url)
+	product, err := app.fetchProduct(ctx, app.token(r), r.URL.Query().Get(":id"))
+	if s := upstreamStatus(err); s != 0 {
+		app.clientError(w, r, s)
+		return
+	}
 	if err != nil {
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 	}
 
-	// Client.Do will handle the context level timeout.
-	client := newClient()
-	resp, err := client.Do(req)
-	if err != nil {
-		app.serverError(w, err)
+	if wantsJSON(r) {
+		writeJSON(w, r, http.StatusOK, envelope{
+			Data: newProductV1(product),
+			Meta: productMeta{Currency: app.money.Currency().Code},
+		})
 		return
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		app.clientError(w, resp.StatusCode)
+	creators := app.resolveUsers(ctx, r, product.UserID)
+
+	app.renderConditional(w, r, "show.page.tmpl", &templateData{
+		Creator: creators[product.UserID],
+		Product: &product,
+	})
+}
+
+// exportProducts streams the whole catalogue as CSV, TSV or XLSX, with the
+// search and sort order of the listing applied. Without a sort order rows
+// are written as the pages arrive from the sales-api. A sort order needs
+// the products matching the search in memory first, the document is still
+// streamed. The route is served past the buffering of session.Enable, see
+// streaming.
+func (app *application) exportProducts(w http.ResponseWriter, r *http.Request) {
+
+	ctx, span := otel.Tracer(name).Start(r.Context(), "exportProducts")
+	defer span.End()
+
+	format, ok := export.Lookup(r.URL.Query().Get("format"))
+	if !ok {
+		app.clientError(w, r, http.StatusBadRequest)
 		return
 	}
-	// Decode json response into a product.
-	var product product.Product
-	if err := json.NewDecoder(resp.Body).Decode(&product); err != nil {
-		app.serverError(w, err)
+	query, err := app.listingQuery(r.URL.Query())
+	if err != nil {
+		app.clientError(w, r, http.StatusBadRequest)
 		return
 	}
+	span.SetAttributes(attribute.String("format", format.Name), attribute.String("sort", query.Order))
 
-	if err != nil {
-		app.serverError(w, err)
+	l := app.localizer(r)
+	opts := export.Options{
+		Money:    app.moneyFormat(l.Lang()),
+		Location: l.Location(),
+		Sheet:    l.T("export.sheet"),
+	}
+
+	// The response starts with the first row, a failing first request to
+	// the sales-api can still be answered with an error status.
+	var ew export.Writer
+	start := func() error {
+		filename := fmt.Sprintf("products-%s%s", time.Now().In(l.Location()).Format("2006-01-02"), format.Extension)
+		w.Header().Set("Content-Type", format.ContentType)
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+		ew = format.New(w, opts)
+		return ew.WriteHeader([]string{
+			l.T("product.id"), l.T("product.name"), l.T("product.cost"), l.T("product.quantity"),
+			l.T("product.sold"), l.T("product.revenue"), l.T("export.created"), l.T("export.updated"),
+		})
+	}
+	// Large catalogues take longer than the write timeout of the server,
+	// the deadline is pushed out for every page of rows read or written.
+	rc := http.NewResponseController(w)
+	rows := 0
+	extend := func() error {
+		if rows%maxPerPage == 0 {
+			if err := rc.SetWriteDeadline(time.Now().Add(exportWriteTimeout)); err != nil {
+				return fmt.Errorf("extending the export deadline: %w", err)
+			}
+		}
+		rows++
+		return nil
+	}
+	write := func(p product.Product) error {
+		if ew == nil {
+			if err := start(); err != nil {
+				return err
+			}
+		}
+		return ew.Write([]export.Cell{
+			export.Text(p.ID),
+			export.Text(sanitize.Inline.Text(p.Name)),
+			export.Money(p.Cost),
+			export.Int(p.Quantity),
+			export.Int(p.Sold),
+			export.Money(p.Revenue),
+			export.Time(p.DateCreated),
+			export.Time(p.DateUpdated),
+		})
+	}
+
+	if query.Order == "" {
+		err = app.eachProduct(ctx, app.token(r), uncachedPages, func(p product.Product) error {
+			if err := extend(); err != nil {
+				return err
+			}
+			if !query.Match(&p) {
+				return nil
+			}
+			return write(p)
+		})
+	} else {
+		var products []product.Product
+		err = app.eachProduct(ctx, app.token(r), uncachedPages, func(p product.Product) error {
+			if query.Match(&p) {
+				products = append(products, p)
+			}
+			return extend()
+		})
+		query.Sort(products)
+		for _, p := range products {
+			if err != nil {
+				break
+			}
+			if err = extend(); err == nil {
+				err = write(p)
+			}
+		}
+	}
+
+	switch {
+	case err != nil && ew != nil:
+		// Too late for an error status, the client gets a truncated
+		// document (and an unreadable one in case of XLSX).
+		app.logger(r).ErrorContext(ctx, "export aborted", "format", format.Name, "error", err)
+		return
+	case upstreamStatus(err) == http.StatusUnauthorized:
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	case err != nil:
+		app.serverError(w, r, err)
 		return
 	}
 
-	app.render(w, r, "show.page.tmpl", &templateData{
-		Product: &product,
+	// an empty catalogue still gets the header row
+	if ew == nil {
+		if err := start(); err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+	}
+	if err := ew.Close(); err != nil {
+		app.logger(r).ErrorContext(ctx, "export aborted", "format", format.Name, "error", err)
+	}
+}
+
+// dashboard aggregates all products into totals, top sellers and the
+// products running low on stock.
+func (app *application) dashboard(w http.ResponseWriter, r *http.Request) {
+
+	ctx, span := otel.Tracer(name).Start(r.Context(), "dashboard")
+	defer span.End()
+
+	summary := product.NewSummary(app.lowStock, app.topN)
+	err := app.eachProduct(ctx, app.token(r), cachedPages, summary.Add)
+	switch {
+	case upstreamStatus(err) == http.StatusUnauthorized:
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	case err != nil:
+		app.serverError(w, r, err)
+		return
+	}
+	span.SetAttributes(attribute.Int("products", summary.Products))
+
+	l := app.localizer(r)
+	mf := app.moneyFormat(l.Lang())
+	revenue := func(p *product.Product) int64 { return int64(p.Revenue) }
+	sold := func(p *product.Product) int64 { return int64(p.Sold) }
+
+	app.render(w, r, "dashboard.page.tmpl", &templateData{
+		Dashboard: &dashboardView{
+			Summary: summary,
+			Revenue: newChart("revenue", l.T("dashboard.top_revenue"), summary.ByRevenue, revenue, func(v int64) string { return mf.Format(product.Money(v)) }),
+			Sold:    newChart("sold", l.T("dashboard.top_sold"), summary.BySold, sold, func(v int64) string { return strconv.FormatInt(v, 10) }),
+		},
 	})
 }
 
+// qualityReport checks all products against the data-quality rules and
+// lists the products breaking them.
+func (app *application) qualityReport(w http.ResponseWriter, r *http.Request) {
+
+	ctx, span := otel.Tracer(name).Start(r.Context(), "qualityReport")
+	defer span.End()
+
+	c, err := app.checkQuality(ctx, app.token(r))
+	switch {
+	case upstreamStatus(err) == http.StatusUnauthorized:
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	case err != nil:
+		app.serverError(w, r, err)
+		return
+	}
+	for _, u := range c.Unchecked {
+		app.log.Warn("checking quality", "rule", u.Rule, "product", u.Product.ID, "error", u.Err)
+	}
+	span.SetAttributes(attribute.Int("products", c.Checked), attribute.Int("violations", len(c.Violations)), attribute.Int("unchecked", len(c.Unchecked)))
+
+	app.render(w, r, "quality.page.tmpl", &templateData{Quality: newQualityView(c)})
+}
+
+// salesStats reports the hit and miss counts of the sales-api response
+// cache and the requests saved by coalescing identical ones.
+func (app *application) salesStats(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, http.StatusOK, envelope{Data: salesStats{
+		Cache:   app.responses.stats(),
+		Flights: app.flights.Stats(),
+	}})
+}
+
 func (app *application) loginUserForm(w http.ResponseWriter, r *http.Request) {
-	app.render(w, r, "login.page.tmpl", &templateData{
+	td := &templateData{
 		Form: forms.New(nil),
-	})
+	}
+	if app.login.needsChallenge(app.login.status(loginKeys(r, ""))) {
+		td.Challenge = app.issueChallenge(r)
+	}
+	app.render(w, r, "login.page.tmpl", td)
 }
 
 // loginUser checks the provided credentials and redirects the client
@@ -173,13 +397,29 @@ func (app *application) loginUser(w http.ResponseWriter, r *http.Request) {
 
 	err := r.ParseForm()
 	if err != nil {
-		app.clientError(w, http.StatusBadRequest)
+		app.clientError(w, r, http.StatusBadRequest)
 		return
 	}
 
 	// Initialize a form struct using form data.
 	form := forms.New(r.PostForm)
 
+	// Turn away locked out clients before the credentials reach the sales-api.
+	keys := loginKeys(r, form.Get("email"))
+	status := app.login.status(keys)
+	if status.Locked() {
+		app.renderLoginFailure(w, r, form, status, http.StatusTooManyRequests)
+		return
+	}
+	if app.login.needsChallenge(status) {
+		id := app.session.PopString(r, "loginChallenge")
+		if !app.login.challenges.verify(id, form.Get("challenge")) {
+			form.Errors.Add("challenge", "login.error.challenge")
+			app.renderLoginFailure(w, r, form, app.login.fail(keys), http.StatusOK)
+			return
+		}
+	}
+
 	// Create a context with a timeout of 1 second.
 	ctx, cancel := context.WithTimeout(ctx, time.Second)
 	defer cancel()
@@ -187,7 +427,7 @@ func (app *application) loginUser(w http.ResponseWriter, r *http.Request) {
 	url := fmt.Sprintf("%s/users/token/%s", app.salesURL, app.keyID)
 	req, err := app.newGetRequest(ctx, r, url)
 	if err != nil {
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 	}
 	req.SetBasicAuth(form.Get("email"), form.Get("password"))
@@ -197,7 +437,7 @@ func (app *application) loginUser(w http.ResponseWriter, r *http.Request) {
 	client := newClient()
 	resp, err := client.Do(req)
 	if err != nil {
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 	}
 
@@ -207,42 +447,46 @@ func (app *application) loginUser(w http.ResponseWriter, r *http.Request) {
 	// If the credentials are not valid, add a generic error message to the
 	// form failures map and re-display the login page.
 	if resp.StatusCode != http.StatusOK {
-		form.Errors.Add("generic", "Email or Password is incorrect")
-		app.render(w, r, "login.page.tmpl", &templateData{Form: form})
+		form.Errors.Add("generic", "login.error.credentials")
+		app.renderLoginFailure(w, r, form, app.login.fail(keys), http.StatusOK)
 		return
 	}
+	app.login.succeed(keys)
 
 	// Extract user ID from the json web token
 	var tkn struct {
 		Token string `json:"token"`
 	}
 	if err := json.NewDecoder(resp.Body).Decode(&tkn); err != nil {
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 	}
 
 	var po []jwt.ParserOption
 	po = append(po, jwt.WithValidMethods([]string{"RS256"}))
 	parser := jwt.NewParser(po...)
-	var claims jwt.StandardClaims
+	var claims salesClaims
 	_, _, err = parser.ParseUnverified(tkn.Token, &claims)
 	if err != nil {
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 	}
 
 	// Add the ID of the current user to the session data (user loged in)
 	app.session.Put(r, "authenticatedUserID", claims.Subject)
 	app.session.Put(r, "jsonWebToken", tkn.Token)
+	app.session.Put(r, "roles", strings.Join(claims.Roles, ","))
 
 	if err != nil {
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 	}
 
-	// Pop the captured path from the session data.
-	path := app.session.PopString(r, "redirectPathAfterLogin")
-	if path != "" {
+	// Pop the captured URL from the session data and only follow it when it
+	// points back to the origin the client is using right now.
+	origin := remoteClient(r).Origin()
+	target := app.session.PopString(r, "redirectPathAfterLogin")
+	if path, ok := strings.CutPrefix(target, origin); ok && strings.HasPrefix(path, "/") && !strings.HasPrefix(path, "//") {
 		http.Redirect(w, r, path, http.StatusSeeOther)
 		return
 	}
@@ -251,59 +495,110 @@ func (app *application) loginUser(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
+// renderLoginFailure re-displays the login page after a rejected attempt,
+// explaining a lockout and asking a challenge if the throttle wants one.
+func (app *application) renderLoginFailure(w http.ResponseWriter, r *http.Request, form *forms.Form, status ratelimit.Status, code int) {
+	td := &templateData{Form: form}
+	if status.Locked() {
+		form.Errors.Add("lockout", "login.error.lockout", status.RetryAfter.Round(time.Second))
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(status.RetryAfter.Seconds()))))
+		code = http.StatusTooManyRequests
+	} else if app.login.needsChallenge(status) {
+		td.Challenge = app.issueChallenge(r)
+	}
+
+	// rendered first, so that a failure can still be answered with a 500
+	buf, ok := app.execute(w, r, "login.page.tmpl", td)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(code)
+	buf.WriteTo(w)
+}
+
 func (app *application) logoutUser(w http.ResponseWriter, r *http.Request) {
 	// remove authenticatedUserID from the session data (user logged out)
 	app.session.Remove(r, "authenticatedUserID")
+	app.session.Remove(r, "roles")
 	// add flash message to the user session
-	app.session.Put(r, "flash", "You've been logged out successfully!")
+	app.session.Put(r, "flash", "flash.logged_out")
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
-func (app *application) userProfile(w http.ResponseWriter, r *http.Request) {
-
-	ctx, span := otel.Tracer(name).Start(r.Context(), "userprofile")
-	defer span.End()
-
-	// Create a context with a timeout of 1 second.
-	ctx, cancel := context.WithTimeout(ctx, time.Second)
-	defer cancel()
+// setTimezone stores the time zone of the user in the session. The browser
+// reports the detected zone on the first visit (auto), users can pick a
+// different one on their profile page.
+func (app *application) setTimezone(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
 
-	// get user ID from session data
-	userID := app.session.GetString(r, "authenticatedUserID")
-	url := fmt.Sprintf("%s/users/%s", app.salesURL, userID)
-	req, err := app.newGetRequest(ctx, r, url)
-	if err != nil {
-		app.serverError(w, err)
+	tz := r.PostForm.Get("tz")
+	if _, err := loadLocation(tz); err != nil || tz == "" {
+		app.clientError(w, r, http.StatusBadRequest)
 		return
 	}
 
-	// Client.Do will handle the context level timeout.
-	client := newClient()
-	resp, err := client.Do(req)
-	if err != nil {
-		app.serverError(w, err)
+	if r.PostForm.Get("auto") != "" {
+		// never override a zone that has been set before
+		if !app.session.Exists(r, "timezone") {
+			app.session.Put(r, "timezone", tz)
+		}
+		w.WriteHeader(http.StatusNoContent)
 		return
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		app.clientError(w, resp.StatusCode)
+	app.session.Put(r, "timezone", tz)
+	http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
+}
+
+// setLocale stores the language chosen by the user in the session and sends
+// the user back to the page the choice was made on.
+func (app *application) setLocale(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		app.clientError(w, r, http.StatusBadRequest)
 		return
 	}
 
-	// Decode json response into a user.
-	var u user.User
-	if err := json.NewDecoder(resp.Body).Decode(&u); err != nil {
-		app.serverError(w, err)
+	lang := r.PostForm.Get("lang")
+	if !app.catalog.Supports(lang) {
+		app.clientError(w, r, http.StatusBadRequest)
 		return
 	}
+	app.session.Put(r, "locale", lang)
+
+	path := "/"
+	if ref, err := url.Parse(r.Referer()); err == nil && ref.Host == remoteClient(r).Host && strings.HasPrefix(ref.Path, "/") {
+		path = ref.RequestURI()
+	}
+	http.Redirect(w, r, path, http.StatusSeeOther)
+}
+
+func (app *application) userProfile(w http.ResponseWriter, r *http.Request) {
+
+	ctx, span := otel.Tracer(name).Start(r.Context(), "userprofile")
+	defer span.End()
 
+	// get user ID from session data
+	u, err := app.fetchUser(ctx, app.token(r), app.session.GetString(r, "authenticatedUserID"))
+	if s := upstreamStatus(err); s != 0 {
+		app.clientError(w, r, s)
+		return
+	}
 	if err != nil {
-		app.serverError(w, err)
+		app.serverError(w, r, err)
+		return
+	}
+
+	if wantsJSON(r) {
+		writeJSON(w, r, http.StatusOK, envelope{Data: newUserV1(u)})
 		return
 	}
 
 	app.render(w, r, "profile.page.tmpl", &templateData{
-		User: &u,
+		User:      &u,
+		Timezones: commonTimezones,
 	})
 }
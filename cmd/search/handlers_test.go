@@ -2,12 +2,28 @@ package main
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
 	"net/url"
+	"os"
 	"regexp"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/tullo/search/internal/product"
 )
 
 func TestPing(t *testing.T) {
@@ -307,3 +323,981 @@ func TestShowProduct(t *testing.T) {
 		})
 	}
 }
+
+func TestLoginLockout(t *testing.T) {
+	app := newTestApplication(t)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	_, _, body := ts.get(t, "/user/login")
+	csrfToken := extractCSRFToken(t, body)
+
+	// Lock out the email address without reaching the sales-api.
+	keys := []string{"email:locked@example.com"}
+	for i := 0; i < 10; i++ {
+		app.login.fail(keys)
+	}
+
+	form := url.Values{}
+	form.Add("email", "Locked@Example.com")
+	form.Add("password", "gophers")
+	form.Add("csrf_token", csrfToken)
+
+	code, header, body := ts.postForm(t, "/user/login", form)
+	if code != http.StatusTooManyRequests {
+		t.Errorf("want %d; got %d", http.StatusTooManyRequests, code)
+	}
+	if header.Get("Retry-After") != "60" {
+		t.Errorf("want Retry-After %q; got %q", "60", header.Get("Retry-After"))
+	}
+	if !bytes.Contains(body, []byte("Too many failed login attempts")) {
+		t.Errorf("want body to contain the lockout message")
+	}
+}
+
+func TestLoginKeepsIPFailures(t *testing.T) {
+	app := newTestApplication(t)
+	app.salesURL = newSalesAPI(t).URL
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// failures against other addresses from the same client
+	ip := []string{"ip:127.0.0.1", "email:victim@example.com"}
+	for range 3 {
+		app.login.fail(ip)
+	}
+	app.login.fail([]string{"email:" + testUser.Email})
+
+	ts.login(t)
+
+	if s := app.login.status([]string{"ip:127.0.0.1"}); s.Failures != 3 {
+		t.Errorf("want the 3 failures of the client IP kept; got %d", s.Failures)
+	}
+	if s := app.login.status([]string{"email:victim@example.com"}); s.Failures != 3 {
+		t.Errorf("want the failures of the other address kept; got %d", s.Failures)
+	}
+	if s := app.login.status([]string{"email:" + testUser.Email}); s.Failures != 0 {
+		t.Errorf("want no failures of the address logged in; got %d", s.Failures)
+	}
+}
+
+func TestLocaleNegotiation(t *testing.T) {
+	app := newTestApplication(t)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/user/login", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept-Language", "de-CH, en;q=0.5")
+
+	code, header, body := ts.clientDo(t, req)
+	if code != http.StatusOK {
+		t.Errorf("want %d; got %d", http.StatusOK, code)
+	}
+	if header.Get("Content-Language") != "de" {
+		t.Errorf("want Content-Language %q; got %q", "de", header.Get("Content-Language"))
+	}
+	if !bytes.Contains(body, []byte("<input type='submit' value='Anmelden'>")) {
+		t.Error("want german login form")
+	}
+
+	// The choice stored in the session wins over the browser preferences.
+	form := url.Values{}
+	form.Add("lang", "en")
+	form.Add("csrf_token", extractCSRFToken(t, body))
+	code, _, _ = ts.postForm(t, "/user/locale", form)
+	if code != http.StatusSeeOther {
+		t.Errorf("want %d; got %d", http.StatusSeeOther, code)
+	}
+
+	_, _, body = ts.clientDo(t, req.Clone(req.Context()))
+	if !bytes.Contains(body, []byte("<input type='submit' value='Login'>")) {
+		t.Error("want english login form")
+	}
+
+	// Behind a trusted proxy the page the choice was made on is found by
+	// the host the browser asked for.
+	proxies, err := newProxyResolver([]string{"127.0.0.1", "::1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.proxies = proxies
+	post, err := http.NewRequest(http.MethodPost, ts.URL+"/user/locale", strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	post.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	post.Header.Set("Sec-Fetch-Site", "same-origin")
+	post.Header.Set("X-Forwarded-For", "198.51.100.9")
+	post.Header.Set("X-Forwarded-Proto", "https")
+	post.Header.Set("X-Forwarded-Host", "search.example.org")
+	post.Header.Set("Referer", "https://search.example.org/about?x=1")
+	code, header, _ = ts.clientDo(t, post)
+	if code != http.StatusSeeOther || header.Get("Location") != "/about?x=1" {
+		t.Errorf("want %d back to /about?x=1; got %d to %q", http.StatusSeeOther, code, header.Get("Location"))
+	}
+}
+
+func TestJSONResponses(t *testing.T) {
+	app := newTestApplication(t)
+	app.salesURL = newSalesAPI(t).URL
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	getJSON := func(t *testing.T, urlPath, accept string) (int, http.Header, envelope, json.RawMessage) {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+urlPath, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Accept", accept)
+
+		code, header, body := ts.clientDo(t, req)
+		var env struct {
+			envelope
+			Data json.RawMessage `json:"data"`
+		}
+		if err := json.Unmarshal(body, &env); err != nil {
+			t.Fatalf("decoding %s: %v", body, err)
+		}
+		return code, header, env.envelope, env.Data
+	}
+
+	// Unauthenticated clients get an error instead of the login page.
+	code, header, env, _ := getJSON(t, "/", "application/json")
+	if code != http.StatusUnauthorized {
+		t.Errorf("want %d; got %d", http.StatusUnauthorized, code)
+	}
+	if env.Error == nil || env.Error.Code != "unauthorized" || env.Error.RequestID != header.Get(requestIDHeader) {
+		t.Errorf("want unauthorized error object; got %+v", env.Error)
+	}
+
+	ts.login(t)
+
+	t.Run("Listing", func(t *testing.T) {
+		code, header, env, data := getJSON(t, "/?format=json&per_page=1", "")
+		if code != http.StatusOK {
+			t.Fatalf("want %d; got %d", http.StatusOK, code)
+		}
+		if ct := header.Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+			t.Errorf("want JSON content type; got %q", ct)
+		}
+		if env.Version != apiVersion {
+			t.Errorf("want version %d; got %d", apiVersion, env.Version)
+		}
+
+		var products []productV1
+		if err := json.Unmarshal(data, &products); err != nil {
+			t.Fatal(err)
+		}
+		if len(products) != 1 || products[0].Name != "McDonalds Toys" || products[0].Cost != 7500 {
+			t.Errorf("want the first product; got %+v", products)
+		}
+
+		meta := env.Meta.(map[string]interface{})
+		pages := meta["pagination"].(map[string]interface{})
+		if meta["currency"] != "USD" || pages["next"] != "/?format=json&page=2&per_page=1" {
+			t.Errorf("want currency and link to the next page; got %v", meta)
+		}
+	})
+
+	t.Run("Did you mean", func(t *testing.T) {
+		code, _, env, _ := getJSON(t, "/?format=json&q=xomix", "")
+		if code != http.StatusOK {
+			t.Fatalf("want %d; got %d", http.StatusOK, code)
+		}
+		meta := env.Meta.(map[string]interface{})
+		if got := fmt.Sprint(meta["did_you_mean"]); got != "[Comic Books]" {
+			t.Errorf("want the closest name offered; got %v", meta)
+		}
+	})
+
+	t.Run("Product", func(t *testing.T) {
+		code, _, _, data := getJSON(t, "/product/72f8b983-3eb4-48db-9ed0-e45cc6bd716b", "application/vnd.search.v1+json")
+		if code != http.StatusOK {
+			t.Fatalf("want %d; got %d", http.StatusOK, code)
+		}
+		var p productV1
+		if err := json.Unmarshal(data, &p); err != nil {
+			t.Fatal(err)
+		}
+		if p.ID != "72f8b983-3eb4-48db-9ed0-e45cc6bd716b" || p.Revenue != 22500 {
+			t.Errorf("unexpected product %+v", p)
+		}
+	})
+
+	t.Run("Unknown product", func(t *testing.T) {
+		code, _, env, _ := getJSON(t, "/product/99f8b983-3eb4-48db-9ed0-e45cc6bd716b", "application/json")
+		if code != http.StatusNotFound || env.Error == nil || env.Error.Code != "not_found" {
+			t.Errorf("want not found error; got %d %+v", code, env.Error)
+		}
+	})
+
+	t.Run("Profile", func(t *testing.T) {
+		code, _, _, data := getJSON(t, "/user/profile", "application/json")
+		if code != http.StatusOK {
+			t.Fatalf("want %d; got %d", http.StatusOK, code)
+		}
+		var u userV1
+		if err := json.Unmarshal(data, &u); err != nil {
+			t.Fatal(err)
+		}
+		if u.Email != testUser.Email {
+			t.Errorf("want %q; got %q", testUser.Email, u.Email)
+		}
+	})
+
+	t.Run("Unsupported version", func(t *testing.T) {
+		code, _, env, _ := getJSON(t, "/", "application/vnd.search.v9+json")
+		if code != http.StatusNotAcceptable || env.Error == nil {
+			t.Errorf("want %d with error object; got %d", http.StatusNotAcceptable, code)
+		}
+	})
+
+	t.Run("HTML stays the default", func(t *testing.T) {
+		code, header, body := ts.get(t, "/")
+		if code != http.StatusOK || !strings.HasPrefix(header.Get("Content-Type"), "text/html") {
+			t.Errorf("want HTML page; got %d %q", code, header.Get("Content-Type"))
+		}
+		if !bytes.Contains(body, []byte(`<a href="/product/72f8b983-3eb4-48db-9ed0-e45cc6bd716b">McDonalds Toys</a>`)) {
+			t.Error("want the product table")
+		}
+	})
+}
+
+func TestExportProducts(t *testing.T) {
+	app := newTestApplication(t)
+	app.salesURL = newSalesAPI(t).URL
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	code, _, _ := ts.get(t, "/products/export?format=csv")
+	if code != http.StatusSeeOther {
+		t.Errorf("want %d; got %d", http.StatusSeeOther, code)
+	}
+
+	ts.login(t)
+	cached := app.responses.stats().Entries
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantCode int
+		wantType string
+		wantBody string
+	}{
+		{
+			name:     "CSV",
+			urlPath:  "/products/export?format=csv",
+			wantCode: http.StatusOK,
+			wantType: "text/csv; charset=utf-8",
+			wantBody: "ID,Name,Cost,Quantity,Sold,Revenue,Created,Updated\n" +
+				"72f8b983-3eb4-48db-9ed0-e45cc6bd716b,McDonalds Toys,$75.00,120,3,$225.00,2019-01-01 00:00:02,2019-01-01 00:00:02\n" +
+				"a2b0639f-2cc6-44b8-b97b-15d69dbb511e,Comic Books,$50.00,42,7,$350.00,2019-01-01 00:00:01,2019-01-01 00:00:01\n",
+		},
+		{
+			name:     "TSV sorted",
+			urlPath:  "/products/export?format=tsv&sort=-sold",
+			wantCode: http.StatusOK,
+			wantType: "text/tab-separated-values; charset=utf-8",
+			wantBody: "ID\tName\tCost\tQuantity\tSold\tRevenue\tCreated\tUpdated\n" +
+				"a2b0639f-2cc6-44b8-b97b-15d69dbb511e\tComic Books\t$50.00\t42\t7\t$350.00\t2019-01-01 00:00:01\t2019-01-01 00:00:01\n" +
+				"72f8b983-3eb4-48db-9ed0-e45cc6bd716b\tMcDonalds Toys\t$75.00\t120\t3\t$225.00\t2019-01-01 00:00:02\t2019-01-01 00:00:02\n",
+		},
+		{
+			name:     "Filtered to nothing",
+			urlPath:  "/products/export?format=csv&q=nothing",
+			wantCode: http.StatusOK,
+			wantType: "text/csv; charset=utf-8",
+			wantBody: "ID,Name,Cost,Quantity,Sold,Revenue,Created,Updated\n",
+		},
+		{
+			name:     "XLSX",
+			urlPath:  "/products/export?format=xlsx&q=toys",
+			wantCode: http.StatusOK,
+			wantType: "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+			wantBody: "PK",
+		},
+		{"Unknown format", "/products/export?format=pdf", http.StatusBadRequest, "", ""},
+		{"Unknown sort", "/products/export?format=csv&sort=user_id", http.StatusBadRequest, "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, header, body := ts.get(t, tt.urlPath)
+			if code != tt.wantCode {
+				t.Fatalf("want %d; got %d", tt.wantCode, code)
+			}
+			if tt.wantType == "" {
+				return
+			}
+			if ct := header.Get("Content-Type"); ct != tt.wantType {
+				t.Errorf("want content type %q; got %q", tt.wantType, ct)
+			}
+			if !strings.HasPrefix(header.Get("Content-Disposition"), "attachment; filename=\"products-") {
+				t.Errorf("want attachment; got %q", header.Get("Content-Disposition"))
+			}
+			if tt.wantType == "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet" {
+				if !bytes.HasPrefix(body, []byte(tt.wantBody)) {
+					t.Error("want a zip archive")
+				}
+				return
+			}
+			if string(body) != tt.wantBody {
+				t.Errorf("want body %q; got %q", tt.wantBody, body)
+			}
+		})
+	}
+
+	// the pages are read past the response cache
+	if n := app.responses.stats().Entries; n != cached {
+		t.Errorf("want %d cached responses; got %d", cached, n)
+	}
+}
+
+func TestExportWriteTimeout(t *testing.T) {
+	products := testProducts
+	testProducts = nil
+	for i := range 2*maxPerPage + 50 {
+		p := products[i%len(products)]
+		p.ID = fmt.Sprintf("%08d-0000-0000-0000-000000000000", i)
+		testProducts = append(testProducts, p)
+	}
+	t.Cleanup(func() { testProducts = products })
+
+	// every page of the catalogue takes a while, together longer than
+	// the write timeout of the server
+	const timeout = 200 * time.Millisecond
+	api := newSalesAPI(t)
+	target, err := url.Parse(api.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/products/") {
+			time.Sleep(timeout / 2)
+		}
+		proxy.ServeHTTP(w, r)
+	}))
+	defer slow.Close()
+
+	app := newTestApplication(t)
+	app.salesURL = slow.URL
+	app.responses = nil
+
+	ts := newTestServer(t, app.routes(), func(s *http.Server) { s.WriteTimeout = timeout })
+	defer ts.Close()
+	ts.login(t)
+
+	for _, path := range []string{"/products/export?format=csv", "/products/export?format=csv&sort=name"} {
+		code, _, body := ts.get(t, path)
+		if code != http.StatusOK {
+			t.Fatalf("%s: want %d; got %d", path, http.StatusOK, code)
+		}
+		if rows := bytes.Count(body, []byte("\n")); rows != len(testProducts)+1 {
+			t.Errorf("%s: want %d rows; got %d", path, len(testProducts)+1, rows)
+		}
+	}
+}
+
+func TestHomeSearchAndSort(t *testing.T) {
+	app := newTestApplication(t)
+	app.salesURL = newSalesAPI(t).URL
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.login(t)
+
+	code, _, body := ts.get(t, "/?sort=-sold")
+	if code != http.StatusOK {
+		t.Fatalf("want %d; got %d", http.StatusOK, code)
+	}
+	comic := bytes.Index(body, []byte("Comic Books"))
+	toys := bytes.Index(body, []byte("McDonalds Toys"))
+	if comic < 0 || toys < 0 || comic > toys {
+		t.Error("want products ordered by sold units, descending")
+	}
+	if !bytes.Contains(body, []byte(`<th scope="col" aria-sort="descending"><a href="/?sort=sold">Sold</a></th>`)) {
+		t.Error("want sold column to toggle the order")
+	}
+
+	_, _, body = ts.get(t, "/?q=toys&sort=-sold")
+	if bytes.Contains(body, []byte("Comic Books")) || !bytes.Contains(body, []byte("McDonalds <mark>Toys</mark>")) {
+		t.Error("want products filtered by name, the match marked")
+	}
+	if !bytes.Contains(body, []byte(`href="/products/export?format=csv&amp;q=toys&amp;sort=-sold"`)) {
+		t.Error("want export links with the current search and sort order")
+	}
+
+	_, _, body = ts.get(t, "/?q=McDonals+Toyz")
+	if bytes.Contains(body, []byte("Comic Books")) || !bytes.Contains(body, []byte("<mark>McDonalds</mark> <mark>Toys</mark>")) {
+		t.Error("want misspelled search to find the product")
+	}
+
+	_, _, body = ts.get(t, "/?q=nothing")
+	if !bytes.Contains(body, []byte("No products match &#34;nothing&#34;.")) {
+		t.Error("want no match message")
+	}
+	if bytes.Contains(body, []byte("Did you mean")) {
+		t.Error("want no alternatives for a search far off every name")
+	}
+
+	_, _, body = ts.get(t, "/?q=xomix&sort=-sold")
+	if !bytes.Contains(body, []byte(`Did you mean
+            <a href="/?q=Comic&#43;Books&amp;sort=-sold">Comic Books</a>?`)) {
+		t.Errorf("want the closest name offered; got %s", body)
+	}
+}
+
+func TestDashboard(t *testing.T) {
+	app := newTestApplication(t)
+	app.salesURL = newSalesAPI(t).URL
+	app.lowStock = 50
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.login(t)
+
+	code, _, body := ts.get(t, "/dashboard")
+	if code != http.StatusOK {
+		t.Fatalf("want %d; got %d", http.StatusOK, code)
+	}
+
+	for _, want := range []string{
+		"<dt>Total revenue</dt><dd>$575.00</dd>",
+		"<dt>Units sold</dt><dd>10</dd>",
+		"<dt>Units in stock</dt><dd>152</dd>",
+		"<title id='chart-revenue'>Top sellers by revenue</title>",
+		"<text x='0' y='17'>Comic Books</text>",
+		"<rect x='220' y='4' width='270' height='16'></rect>",
+		"<text x='496' y='17'>$350.00</text>",
+		"Low stock (50 units or less)",
+		"<td><a href='/product/a2b0639f-2cc6-44b8-b97b-15d69dbb511e'>Comic Books</a></td>",
+	} {
+		if !bytes.Contains(body, []byte(want)) {
+			t.Errorf("want body to contain %q", want)
+		}
+	}
+}
+
+func TestQualityReport(t *testing.T) {
+	broken := product.Product{
+		ID:          "98b6d4b8-f04b-4c79-8c2e-a0aef46854b7",
+		Name:        "Broken Record",
+		Cost:        1000,
+		Quantity:    2,
+		Sold:        3,
+		Revenue:     2000,
+		UserID:      "00000000-0000-0000-0000-000000000000",
+		DateCreated: time.Date(2019, 1, 2, 0, 0, 0, 0, time.UTC),
+		DateUpdated: time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	products := testProducts
+	testProducts = append(slices.Clip(testProducts), broken)
+	t.Cleanup(func() { testProducts = products })
+
+	app := newTestApplication(t)
+	app.salesURL = newSalesAPI(t).URL
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.login(t)
+
+	code, _, body := ts.get(t, "/admin/quality")
+	if code != http.StatusOK {
+		t.Fatalf("want %d; got %d", http.StatusOK, code)
+	}
+	for _, want := range []string{
+		"<a href='/admin/quality'>Data Quality</a>",
+		"3 products checked, 4 violations.",
+		"<td>3 sold but only 2 available</td>",
+		"<td>revenue is $20.00, cost times sold is $30.00</td>",
+		"<td>last updated before it was created</td>",
+		"<td>creator 00000000-0000-0000-0000-000000000000 does not exist</td>",
+	} {
+		if !bytes.Contains(body, []byte(want)) {
+			t.Errorf("want body to contain %q", want)
+		}
+	}
+	if n := bytes.Count(body, []byte("<a href='/product/"+broken.ID+"'>")); n != 4 {
+		t.Errorf("want 4 violations of %s; got %d", broken.ID, n)
+	}
+
+	t.Run("Command", func(t *testing.T) {
+		ctx := context.Background()
+		token, err := app.salesToken(ctx, testUser.Email, "gophers")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var out bytes.Buffer
+		if err := app.runQuality(ctx, token, &out, app.catalog.Localizer("")); !errors.Is(err, errViolations) {
+			t.Errorf("want %v; got %v", errViolations, err)
+		}
+		for _, want := range []string{
+			"sold-within-quantity   " + broken.ID + "  Broken Record  3 sold but only 2 available\n",
+			"user-exists            1\n",
+			"3 products checked, 4 violations\n",
+		} {
+			if !strings.Contains(out.String(), want) {
+				t.Errorf("want output to contain %q; got\n%s", want, out.String())
+			}
+		}
+	})
+}
+
+func TestQualityReportUnchecked(t *testing.T) {
+	unknown := testProducts[0]
+	unknown.ID = "5b9c0f1e-7d0c-4a55-8d43-3f0f6b1c2a9e"
+	unknown.Name = "Unknown Creator"
+	unknown.UserID = "11111111-1111-1111-1111-111111111111"
+	products := testProducts
+	testProducts = append(slices.Clip(testProducts), unknown)
+	t.Cleanup(func() { testProducts = products })
+
+	api := newSalesAPI(t)
+	next := api.Config.Handler
+	api.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/users/"+unknown.UserID {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+
+	app := newTestApplication(t)
+	app.salesURL = api.URL
+	shutdown := make(chan os.Signal, 1)
+	app.shutdown = shutdown
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.login(t)
+
+	code, _, body := ts.get(t, "/admin/quality")
+	if code != http.StatusOK {
+		t.Fatalf("want %d; got %d", http.StatusOK, code)
+	}
+	for _, want := range []string{
+		"3 products checked, 0 violations.",
+		"1 checks could not be run.",
+		"<a href='/product/" + unknown.ID + "'>Unknown Creator</a>",
+	} {
+		if !bytes.Contains(body, []byte(want)) {
+			t.Errorf("want body to contain %q", want)
+		}
+	}
+	select {
+	case <-shutdown:
+		t.Error("want the server to keep running")
+	default:
+	}
+
+	t.Run("Command", func(t *testing.T) {
+		ctx := context.Background()
+		token, err := app.salesToken(ctx, testUser.Email, "gophers")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var out bytes.Buffer
+		if err := app.runQuality(ctx, token, &out, app.catalog.Localizer("")); !errors.Is(err, errUnchecked) {
+			t.Errorf("want %v; got %v", errUnchecked, err)
+		}
+		if want := "3 products checked, 0 violations, 1 unchecked\n"; !strings.Contains(out.String(), want) {
+			t.Errorf("want output to contain %q; got\n%s", want, out.String())
+		}
+	})
+}
+
+func TestQualityReportForbidden(t *testing.T) {
+	roles := testRoles
+	testRoles = []string{"USER"}
+	t.Cleanup(func() { testRoles = roles })
+
+	app := newTestApplication(t)
+	app.salesURL = newSalesAPI(t).URL
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.login(t)
+
+	code, _, _ := ts.get(t, "/admin/quality")
+	if code != http.StatusForbidden {
+		t.Errorf("want %d; got %d", http.StatusForbidden, code)
+	}
+
+	_, _, body := ts.get(t, "/")
+	if bytes.Contains(body, []byte("/admin/quality")) {
+		t.Error("want no link to the quality report")
+	}
+}
+
+func TestProductCreators(t *testing.T) {
+	orphan := testProducts[0]
+	orphan.ID = "0c5d4f8e-8a4c-4fd4-9b41-0f5a5a3d6d1e"
+	orphan.Name = "Orphan"
+	orphan.UserID = "00000000-0000-0000-0000-000000000000"
+	products := testProducts
+	testProducts = append(slices.Clip(testProducts), orphan)
+	t.Cleanup(func() { testProducts = products })
+
+	api := newSalesAPI(t)
+	var mu sync.Mutex
+	lookups := map[string]int{}
+	next := api.Config.Handler
+	api.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id, ok := strings.CutPrefix(r.URL.Path, "/users/"); ok && !strings.HasPrefix(id, "token/") {
+			mu.Lock()
+			lookups[id]++
+			mu.Unlock()
+		}
+		next.ServeHTTP(w, r)
+	})
+
+	app := newTestApplication(t)
+	app.salesURL = api.URL
+	app.creatorColumn = true
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.login(t)
+
+	for range 2 {
+		code, _, body := ts.get(t, "/")
+		if code != http.StatusOK {
+			t.Fatalf("want %d; got %d", http.StatusOK, code)
+		}
+		if n := bytes.Count(body, []byte("<td>User Gopher</td>")); n != 2 {
+			t.Errorf("want 2 products by User Gopher; got %d", n)
+		}
+		if !bytes.Contains(body, []byte("<th scope=\"col\">Created by:</th>")) {
+			t.Error("want a creator column")
+		}
+	}
+
+	code, _, body := ts.get(t, "/product/"+testProducts[1].ID)
+	if code != http.StatusOK {
+		t.Fatalf("want %d; got %d", http.StatusOK, code)
+	}
+	want := "Created by: User Gopher &lt;<a href='mailto:user@example.com'>user@example.com</a>&gt;"
+	if !bytes.Contains(body, []byte(want)) {
+		t.Errorf("want body to contain %q", want)
+	}
+
+	// every creator is looked up once, unknown ones included
+	if lookups[testUser.ID] != 1 || lookups[orphan.UserID] != 1 {
+		t.Errorf("want one lookup per creator; got %v", lookups)
+	}
+}
+
+func TestStaticAssets(t *testing.T) {
+	embedded := newTestApplication(t)
+
+	// the development setting serves the same files from disk
+	disk := newTestApplication(t)
+	_, _, static, err := uiDirs(os.DirFS("../../ui"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := disk.loadStatic(static); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, app := range map[string]*application{"embedded": embedded, "disk": disk} {
+		t.Run(name, func(t *testing.T) {
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+
+			code, header, body := ts.get(t, "/static/css/main.css")
+			if code != http.StatusOK {
+				t.Fatalf("want %d; got %d", http.StatusOK, code)
+			}
+			if ct := header.Get("Content-Type"); !strings.HasPrefix(ct, "text/css") {
+				t.Errorf("want text/css; got %q", ct)
+			}
+			if !bytes.Contains(body, []byte("table {")) {
+				t.Error("want the style sheet")
+			}
+
+			// neither missing files nor directories are found
+			for _, path := range []string{"/static/missing.css", "/static/", "/static/css/", "/static/css"} {
+				if code, _, _ := ts.get(t, path); code != http.StatusNotFound {
+					t.Errorf("%s: want %d; got %d", path, http.StatusNotFound, code)
+				}
+			}
+		})
+	}
+}
+
+func TestFingerprintedAssets(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	_, _, static := testUI(t)
+	css, err := fs.ReadFile(static, "css/main.css")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(css)
+	asset := "/static/css/main." + hex.EncodeToString(sum[:])[:fingerprintLen] + ".css"
+
+	_, _, page := ts.get(t, "/about")
+	if !bytes.Contains(page, []byte(asset)) {
+		t.Fatalf("want the page to link %s", asset)
+	}
+
+	get := func(path string, header http.Header) (int, http.Header, []byte) {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header = header
+		return ts.clientDo(t, req)
+	}
+
+	tests := []struct {
+		name         string
+		path         string
+		header       http.Header
+		wantCode     int
+		wantCache    string
+		wantEncoding string
+	}{
+		{"Fingerprinted", asset, http.Header{"Accept-Encoding": {"identity"}}, http.StatusOK, immutable, ""},
+		{"Gzip", asset, http.Header{"Accept-Encoding": {"br, gzip"}}, http.StatusOK, immutable, "gzip"},
+		{"Gzip refused", asset, http.Header{"Accept-Encoding": {"gzip;q=0, *"}}, http.StatusOK, immutable, ""},
+		{"Plain", "/static/css/main.css", http.Header{"Accept-Encoding": {"identity"}}, http.StatusOK, "no-cache", ""},
+		{"Outdated fingerprint", "/static/css/main.00000000.css", http.Header{"Accept-Encoding": {"identity"}}, http.StatusOK, "no-cache", ""},
+		{"Uncompressed type", app.staticURL("img/logo.png"), http.Header{"Accept-Encoding": {"gzip"}}, http.StatusOK, immutable, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, header, _ := get(tt.path, tt.header)
+			if code != tt.wantCode {
+				t.Fatalf("want %d; got %d", tt.wantCode, code)
+			}
+			if cc := header.Get("Cache-Control"); cc != tt.wantCache {
+				t.Errorf("want Cache-Control %q; got %q", tt.wantCache, cc)
+			}
+			if ce := header.Get("Content-Encoding"); ce != tt.wantEncoding {
+				t.Errorf("want Content-Encoding %q; got %q", tt.wantEncoding, ce)
+			}
+
+			// the ETag revalidates the variant served
+			etag := header.Get("ETag")
+			if etag == "" {
+				t.Fatal("want an ETag")
+			}
+			revalidate := tt.header.Clone()
+			revalidate.Set("If-None-Match", etag)
+			if code, _, _ := get(tt.path, revalidate); code != http.StatusNotModified {
+				t.Errorf("want %d; got %d", http.StatusNotModified, code)
+			}
+		})
+	}
+
+	// the gzip variant decompresses to the asset
+	_, header, body := get(asset, http.Header{"Accept-Encoding": {"gzip"}})
+	if vary := header.Get("Vary"); vary != "Accept-Encoding" {
+		t.Errorf("want Vary: Accept-Encoding; got %q", vary)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, css) {
+		t.Error("want the gzip variant to decompress to the style sheet")
+	}
+}
+
+func TestConditionalPages(t *testing.T) {
+	api := newSalesAPI(t)
+	app := newTestApplication(t)
+	app.salesURL = api.URL
+	app.responses = nil
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+	ts.login(t)
+
+	get := func(ts *testServer, path string, header http.Header) (int, http.Header, []byte) {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header = header
+		return ts.clientDo(t, req)
+	}
+
+	tests := []struct {
+		name string
+		path string
+	}{
+		{"Listing", "/"},
+		{"Product", "/product/" + testProducts[0].ID},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, header, _ := get(ts, tt.path, http.Header{"Accept-Encoding": {"identity"}})
+			if code != http.StatusOK {
+				t.Fatalf("want %d; got %d", http.StatusOK, code)
+			}
+			etag := header.Get("ETag")
+			if etag == "" {
+				t.Fatal("want an ETag")
+			}
+			if lm := header.Get("Last-Modified"); lm != "" {
+				t.Errorf("want no Last-Modified; got %q", lm)
+			}
+			if cc := header.Get("Cache-Control"); cc != revalidate {
+				t.Errorf("want Cache-Control %q; got %q", revalidate, cc)
+			}
+
+			// the nonce and the CSRF token change, the page does not
+			code, header, body := get(ts, tt.path, http.Header{"If-None-Match": {etag}})
+			if code != http.StatusNotModified {
+				t.Fatalf("If-None-Match: want %d; got %d", http.StatusNotModified, code)
+			}
+			if len(body) != 0 {
+				t.Error("want no body")
+			}
+			if csp := header.Get("Content-Security-Policy"); csp != "" {
+				t.Errorf("want the stored policy kept; got %q", csp)
+			}
+
+			// the compressed page has the weak ETag
+			code, header, _ = get(ts, tt.path, http.Header{"Accept-Encoding": {"gzip"}})
+			if code != http.StatusOK || header.Get("ETag") != "W/"+etag {
+				t.Errorf("gzip: want %d and ETag W/%s; got %d and %s", http.StatusOK, etag, code, header.Get("ETag"))
+			}
+			if code, _, _ := get(ts, tt.path, http.Header{"If-None-Match": {"W/" + etag}}); code != http.StatusNotModified {
+				t.Errorf("weak If-None-Match: want %d; got %d", http.StatusNotModified, code)
+			}
+
+			// the time of the data shown does not tell whether the page
+			// changed, the whole page is sent
+			since := time.Now().Add(time.Hour).Format(http.TimeFormat)
+			code, _, body = get(ts, tt.path, http.Header{"If-Modified-Since": {since}})
+			if code != http.StatusOK || len(body) == 0 {
+				t.Errorf("If-Modified-Since: want %d and the page; got %d", http.StatusOK, code)
+			}
+			header = http.Header{"If-None-Match": {`"stale"`}, "If-Modified-Since": {since}}
+			if code, _, _ := get(ts, tt.path, header); code != http.StatusOK {
+				t.Errorf("stale If-None-Match: want %d; got %d", http.StatusOK, code)
+			}
+
+			// another session never gets the page of this one confirmed
+			other := newTestServer(t, app.routes())
+			defer other.Close()
+			other.login(t)
+			if code, _, _ := get(other, tt.path, http.Header{"If-None-Match": {etag}}); code != http.StatusOK {
+				t.Errorf("other session: want %d; got %d", http.StatusOK, code)
+			}
+		})
+	}
+
+	// an update of the product changes the page
+	_, header, _ := get(ts, "/product/"+testProducts[0].ID, http.Header{})
+	products := testProducts
+	testProducts = slices.Clone(testProducts)
+	testProducts[0].Name = "McDonalds Toys (updated)"
+	testProducts[0].DateUpdated = testProducts[0].DateUpdated.Add(time.Hour)
+	t.Cleanup(func() { testProducts = products })
+	code, _, _ := get(ts, "/product/"+testProducts[0].ID, http.Header{"If-None-Match": {header.Get("ETag")}})
+	if code != http.StatusOK {
+		t.Errorf("updated product: want %d; got %d", http.StatusOK, code)
+	}
+
+	// so does deleting a product from the listing
+	_, header, _ = get(ts, "/", http.Header{})
+	testProducts = testProducts[:1]
+	if code, _, _ := get(ts, "/", http.Header{"If-None-Match": {header.Get("ETag")}}); code != http.StatusOK {
+		t.Errorf("deleted product: want %d; got %d", http.StatusOK, code)
+	}
+}
+
+func TestFragments(t *testing.T) {
+	api := newSalesAPI(t)
+	app := newTestApplication(t)
+	app.salesURL = api.URL
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+	ts.login(t)
+
+	tests := []struct {
+		name     string
+		path     string
+		header   http.Header
+		wantCode int
+		want     []string
+		wantNot  []string
+	}{
+		{"Page", "/?per_page=1", nil, http.StatusOK,
+			[]string{"<html", `<tbody id="rows">`, `id="pagination"`}, nil},
+		{"Rows", "/?per_page=1&fragment=rows", nil, http.StatusOK,
+			[]string{"<tr data-id=", "McDonalds Toys"}, []string{"<html", "<table", "pagination"}},
+		{"Pagination", "/?per_page=1&sort=name&fragment=pagination", nil, http.StatusOK,
+			[]string{`id="pagination"`, `href="/?page=2&amp;per_page=1&amp;sort=name"`}, []string{"<html", "<tr", "fragment="}},
+		{"Header", "/?per_page=1", http.Header{"Hx-Request": {"true"}, "Hx-Target": {"listing"}}, http.StatusOK,
+			[]string{"<table", `<tbody id="rows">`, `id="pagination"`}, []string{"<html", `id="listing"`}},
+		{"Unknown target", "/?per_page=1", http.Header{"Hx-Request": {"true"}, "Hx-Target": {"main"}}, http.StatusOK,
+			[]string{"<html", `id="listing"`}, nil},
+		{"Unknown fragment", "/?fragment=main", nil, http.StatusBadRequest, nil, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, ts.URL+tt.path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for k, v := range tt.header {
+				req.Header[k] = v
+			}
+			code, header, body := ts.clientDo(t, req)
+			if code != tt.wantCode {
+				t.Fatalf("want %d; got %d", tt.wantCode, code)
+			}
+			if code != http.StatusOK {
+				return
+			}
+			if !varies(header, "HX-Request") || !varies(header, "HX-Target") {
+				t.Errorf("want Vary to name HX-Request and HX-Target; got %q", header.Values("Vary"))
+			}
+			for _, want := range tt.want {
+				if !bytes.Contains(body, []byte(want)) {
+					t.Errorf("want body to contain %q", want)
+				}
+			}
+			for _, not := range tt.wantNot {
+				if bytes.Contains(body, []byte(not)) {
+					t.Errorf("want body not to contain %q", not)
+				}
+			}
+		})
+	}
+}
@@ -4,55 +4,103 @@ import (
 	"bytes"
 	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"runtime"
 	"runtime/debug"
+	"strings"
 	"time"
 
 	"github.com/justinas/nosurf"
+	"github.com/tullo/search/internal/i18n"
+	"github.com/tullo/search/internal/logger"
+	"github.com/tullo/search/internal/product"
 )
 
 func newClient() *http.Client {
 	var client http.Client
 	t := http.DefaultTransport.(*http.Transport)
-	client.Transport = t.Clone()
+	client.Transport = logTransport{t.Clone()}
 	return &client
 }
 
-func (app *application) newGetRequest(ctx context.Context, r *http.Request, url string) (*http.Request, error) {
-	req, err := http.NewRequest(http.MethodGet, url, nil)
+// logTransport logs every sales-api round trip with the logger of the
+// request context, so client and handler logs can be correlated.
+type logTransport struct {
+	next http.RoundTripper
+}
+
+func (t logTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	log := logger.FromContext(ctx, slog.Default())
+
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
 	if err != nil {
+		log.WarnContext(ctx, "sales-api request", "method", req.Method, "url", req.URL.String(), "duration", time.Since(start), "error", err)
 		return nil, err
 	}
 
-	// Bind the new context into the request.
-	req = req.WithContext(ctx)
+	log.DebugContext(ctx, "sales-api request", "method", req.Method, "url", req.URL.String(), "status", resp.StatusCode, "duration", time.Since(start))
+	return resp, nil
+}
 
-	if tkn := app.session.GetString(r, "jsonWebToken"); tkn != "" {
-		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", tkn))
-	}
+// logger returns the request scoped logger, falling back to the application logger.
+func (app *application) logger(r *http.Request) *slog.Logger {
+	return logger.FromContext(r.Context(), app.log)
+}
 
-	return req, nil
+// requestIDFromContext returns the ID assigned to the request by the requestID middleware.
+func requestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(contextKeyRequestID).(string)
+	return id
 }
 
-func (app *application) serverError(w http.ResponseWriter, err error) {
+func (app *application) newGetRequest(ctx context.Context, r *http.Request, url string) (*http.Request, error) {
+	return newSalesRequest(ctx, http.MethodGet, app.token(r), url, nil)
+}
+
+// token returns the sales-api token of the session.
+func (app *application) token(r *http.Request) string {
+	return app.session.GetString(r, "jsonWebToken")
+}
+
+func (app *application) serverError(w http.ResponseWriter, r *http.Request, err error) {
 	trace := fmt.Sprintf("%s\n%s", err.Error(), debug.Stack())
-	// go one step back in the stack trace to get the file name and line number
-	app.log.Output(2, trace)
+
+	log := app.logger(r)
+	if log.Enabled(r.Context(), slog.LevelError) {
+		// go one step back in the stack trace to get the file name and line number
+		var pcs [1]uintptr
+		runtime.Callers(2, pcs[:])
+		rec := slog.NewRecord(time.Now(), slog.LevelError, err.Error(), pcs[0])
+		rec.Add("stack", string(debug.Stack()))
+		log.Handler().Handle(r.Context(), rec)
+	}
 
 	// when running in debug mode,
 	// write detailed errors and stack traces to the http response
-	if app.debug {
+	switch {
+	case wantsJSON(r) && app.debug:
+		writeJSONError(w, r, http.StatusInternalServerError, err.Error())
+		return
+	case app.debug:
 		http.Error(w, trace, http.StatusInternalServerError)
 		return
+	case wantsJSON(r):
+		writeJSONError(w, r, http.StatusInternalServerError, "")
+	default:
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 	}
 
-	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-
 	app.SignalShutdown()
 }
 
-func (app *application) clientError(w http.ResponseWriter, status int) {
+func (app *application) clientError(w http.ResponseWriter, r *http.Request, status int) {
+	if wantsJSON(r) {
+		writeJSONError(w, r, status, "")
+		return
+	}
 	http.Error(w, http.StatusText(status), status)
 }
 
@@ -63,6 +111,20 @@ func (app *application) addDefaultData(td *templateData, r *http.Request) *templ
 	td.CurrentYear = time.Now().Year()
 	td.Version = build
 
+	// add the user's language and the formats that depend on it
+	td.Locale = app.localizer(r)
+	td.Timezone = app.session.GetString(r, "timezone")
+	td.Languages = app.catalog.Languages()
+	td.Money = app.moneyFormat(td.Locale.Lang())
+
+	// add the nonce of the content security policy to the template data
+	td.CSPNonce = cspNonce(r)
+
+	// let the browser reload when the ui changes in debug mode
+	if app.reload != nil {
+		td.LiveReload = reloadPath
+	}
+
 	// add CSRF token to the template data
 	td.CSRFToken = nosurf.Token(r)
 
@@ -72,10 +134,32 @@ func (app *application) addDefaultData(td *templateData, r *http.Request) *templ
 
 	// add authentication status to the template data
 	td.IsAuthenticated = app.isAuthenticated(r)
+	td.IsAdmin = td.IsAuthenticated && app.hasRole(r, roleAdmin)
 
 	return td
 }
 
+// localizer returns the localizer of the language negotiated for the request.
+func (app *application) localizer(r *http.Request) *i18n.Localizer {
+	if l, ok := r.Context().Value(contextKeyLocalizer).(*i18n.Localizer); ok {
+		return l
+	}
+	return app.catalog.Localizer("")
+}
+
+// moneyFormat returns the configured money format, adapted to the language
+// of the user if it differs from the language of the configured locale.
+func (app *application) moneyFormat(lang string) product.MoneyFormat {
+	base, _, _ := strings.Cut(app.money.Locale(), "-")
+	if strings.EqualFold(base, lang) {
+		return app.money
+	}
+	if f, err := product.NewMoneyFormat(app.money.Currency().Code, lang); err == nil {
+		return f
+	}
+	return app.money
+}
+
 // isAuthenticated checks if the request is from an authenticated user
 func (app *application) isAuthenticated(r *http.Request) bool {
 	isAuthenticated, ok := r.Context().Value(contextKeyIsAuthenticated).(bool)
@@ -86,22 +170,83 @@ func (app *application) isAuthenticated(r *http.Request) bool {
 	return isAuthenticated
 }
 
+// hasRole reports whether the sales-api granted the role to the user.
+func (app *application) hasRole(r *http.Request, role string) bool {
+	for _, rl := range strings.Split(app.session.GetString(r, "roles"), ",") {
+		if rl == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Pages define the parts that can be rendered on their own as templates
+// named with fragmentPrefix, e.g. fragment-rows.
+const (
+	fragmentPrefix = "fragment-"
+	fragmentParam  = "fragment"
+)
+
+// requestedFragment returns the fragment named by the fragment parameter,
+// or by the target of a request made with the HX-Request header. Only the
+// parameter is explicit, a target without a fragment gets the whole page.
+func requestedFragment(r *http.Request) (name string, explicit bool) {
+	if name := r.URL.Query().Get(fragmentParam); name != "" {
+		return name, true
+	}
+	if r.Header.Get("HX-Request") == "true" {
+		return r.Header.Get("HX-Target"), false
+	}
+	return "", false
+}
+
 func (app *application) render(w http.ResponseWriter, r *http.Request, name string, data *templateData) {
-	ts, ok := app.templateCache[name]
+	buf, ok := app.execute(w, r, name, data)
 	if !ok {
-		app.serverError(w, fmt.Errorf("the template %s does not exist", name))
 		return
 	}
 
+	// stage 2: write rendered content
+	buf.WriteTo(w)
+}
+
+// execute renders the page, or the fragment of it requested, into a buffer.
+// Failures are answered right away, ok is false then.
+func (app *application) execute(w http.ResponseWriter, r *http.Request, name string, data *templateData) (buf *bytes.Buffer, ok bool) {
+	set := app.templates.Load()
+	if set.err != nil && app.reload != nil {
+		app.renderTemplateError(w, r, set.err)
+		return nil, false
+	}
+	ts, ok := set.pages[name]
+	if !ok {
+		app.serverError(w, r, fmt.Errorf("the template %s does not exist", name))
+		return nil, false
+	}
+
+	// render just a fragment of the page if asked for one
+	fragments := false
+	for _, t := range ts.Templates() {
+		fragments = fragments || strings.HasPrefix(t.Name(), fragmentPrefix)
+	}
+	if fragments {
+		w.Header().Add("Vary", "HX-Request, HX-Target")
+	}
+	if fragment, explicit := requestedFragment(r); fragment != "" {
+		if t := ts.Lookup(fragmentPrefix + fragment); t != nil {
+			ts = t
+		} else if explicit {
+			app.clientError(w, r, http.StatusBadRequest)
+			return nil, false
+		}
+	}
+
 	// stage 1: write template into buffer
-	buf := new(bytes.Buffer)
+	buf = new(bytes.Buffer)
 	err := ts.Execute(buf, app.addDefaultData(data, r))
 	if err != nil {
-		log.Printf("render err=%v+\n", err)
-		app.serverError(w, err)
-		return
+		app.serverError(w, r, fmt.Errorf("rendering %s: %w", name, err))
+		return nil, false
 	}
-
-	// stage 2: write rendered content
-	buf.WriteTo(w)
+	return buf, true
 }
@@ -5,20 +5,30 @@ import (
 	"crypto/tls"
 	"encoding/base64"
 	"fmt"
-	"html/template"
-	"log"
+	"io/fs"
+	"log/slog"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
+	_ "time/tzdata" // the alpine image does not ship a time zone database
 
 	"github.com/golangcollege/sessions"
 	"github.com/pkg/errors"
 	"github.com/tullo/conf"
+	"github.com/tullo/search/internal/cache"
+	"github.com/tullo/search/internal/flight"
+	"github.com/tullo/search/internal/i18n"
+	"github.com/tullo/search/internal/logger"
+	"github.com/tullo/search/internal/product"
+	"github.com/tullo/search/internal/ratelimit"
+	"github.com/tullo/search/internal/user"
 	"github.com/tullo/search/tracer"
+	"github.com/tullo/search/ui"
 )
 
 // build is the git version of this application. It is set using build flags in the makefile.
@@ -27,18 +37,40 @@ var build = "develop"
 // the key must be unexported type to avoid collisions
 type contextKey string
 
-const contextKeyIsAuthenticated = contextKey("isAuthenticated")
+const (
+	contextKeyIsAuthenticated = contextKey("isAuthenticated")
+	contextKeyRequestID       = contextKey("requestID")
+	contextKeyLocalizer       = contextKey("localizer")
+)
 
 // define the interfaces inline to keep the code simple
 type application struct {
+	accessLog     *accessLogger
+	alternatives  int // names offered for a search finding nothing
+	catalog       *i18n.Catalog
+	creatorColumn bool
 	debug         bool
 	debugURL      string
+	events        *eventHub
+	flights       flight.Group[[]byte] // coalesced sales-api GETs
+	fuzziness     float64              // edits per letter, see product.Query
 	keyID         string
-	log           *log.Logger
+	log           *slog.Logger
+	login         *loginThrottle
+	lowStock      int
+	money         product.MoneyFormat
+	proxies       *proxyResolver
+	responses     *responseCache
+	security      *securityPolicy
 	salesURL      string
 	session       *sessions.Session
 	shutdown      chan os.Signal
-	templateCache map[string]*template.Template
+	static        atomic.Pointer[staticAssets]
+	suggest       *suggestIndex
+	templates     atomic.Pointer[templateSet]
+	topN          int
+	reload        *liveReload // nil unless the ui is watched
+	users         *cache.LRU[string, *user.User]
 	useTLS        bool
 }
 
@@ -49,15 +81,23 @@ func (a *application) SignalShutdown() {
 }
 
 func main() {
-	log := log.New(os.Stdout, "SEARCH : ", log.LstdFlags|log.Lmicroseconds|log.Lshortfile)
+	// the configured logger replaces this one as soon as the config is parsed
+	log, err := logger.New(os.Stdout, logger.FormatJSON, "info")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 
 	if err := run(log); err != nil {
-		log.Printf("error: %s", err)
+		if errors.Is(err, errViolations) || errors.Is(err, errUnchecked) {
+			os.Exit(2)
+		}
+		log.Error("startup", "error", err)
 		os.Exit(1)
 	}
 }
 
-func run(log *log.Logger) error {
+func run(log *slog.Logger) error {
 
 	// =========================================================================
 	// Configuration
@@ -70,9 +110,28 @@ func run(log *log.Logger) error {
 		Debug struct {
 			BaseURL string `conf:"default:http://0.0.0.0:4000/debug"`
 		}
+		Log struct {
+			Format string `conf:"default:json"`
+			Level  string `conf:"default:info"`
+		}
+		// Format is either combined or json, Sample maps path prefixes
+		// to the fraction of requests logged (0 excludes the prefix).
+		AccessLog struct {
+			Format string             `conf:"default:combined"`
+			Sample map[string]float64 `conf:"default:/static/:0.1;/ping:0"`
+		}
 		IdentityProvider struct {
 			KeyID string `conf:"default:54bb2165-71e1-41a6-af3e-7da4a0e1e2c1"`
 		}
+		// UI serves the templates, catalogs and static assets from Dir
+		// instead of the copies embedded into the binary, e.g. ./ui to
+		// see changes without rebuilding. In debug mode the templates and
+		// static assets are checked for changes every PollInterval, and
+		// open pages reload.
+		UI struct {
+			Dir          string
+			PollInterval time.Duration `conf:"default:500ms"`
+		}
 		Web struct {
 			Host            string        `conf:"default::4200"`
 			DebugMode       bool          `conf:"default:false"`
@@ -82,8 +141,98 @@ func run(log *log.Logger) error {
 			ReadTimeout     time.Duration `conf:"default:5s"`
 			WriteTimeout    time.Duration `conf:"default:5s"`
 			ShutdownTimeout time.Duration `conf:"default:5s"`
+			// TrustedProxies lists the CIDRs of the load balancers and ingress
+			// controllers whose forwarding headers are believed.
+			TrustedProxies []string
+		}
+		// Login throttles failed logins per client IP and email address.
+		// A challenge is asked after ChallengeAfter failures (0 disables it).
+		Login struct {
+			Window         time.Duration `conf:"default:15m"`
+			MaxFailures    int           `conf:"default:5"`
+			Lockout        time.Duration `conf:"default:1m"`
+			MaxLockout     time.Duration `conf:"default:1h"`
+			ChallengeAfter int           `conf:"default:3"`
+		}
+		// Security configures the security headers. The CSP nonce for scripts
+		// and the report-uri are added to the configured directives.
+		Security struct {
+			CSP                     []string      `conf:"default:default-src 'self';script-src 'self';style-src 'self';img-src 'self';font-src 'self';object-src 'none';base-uri 'self';form-action 'self';frame-ancestors 'none'"`
+			CSPReportOnly           bool          `conf:"default:false"`
+			HSTSMaxAge              time.Duration `conf:"default:8760h"`
+			ReferrerPolicy          string        `conf:"default:strict-origin-when-cross-origin"`
+			PermissionsPolicy       []string      `conf:"default:camera=();microphone=();geolocation=();payment=();usb=()"`
+			CrossOriginOpenerPolicy string        `conf:"default:same-origin"`
+		}
+		// I18n configures the language used when none of the languages
+		// preferred by the user is supported.
+		I18n struct {
+			Fallback string `conf:"default:en"`
+		}
+		// Dashboard configures the units left at which a product is listed as
+		// low on stock and the length of the top seller lists.
+		Dashboard struct {
+			LowStock int `conf:"default:5"`
+			Top      int `conf:"default:5"`
+		}
+		// ResponseCache configures the cache of sales-api reads. Expired
+		// responses are served for StaleWhileRevalidate while they are
+		// refreshed, and for StaleIfError if the sales-api fails. A Size of
+		// 0 disables the cache.
+		ResponseCache struct {
+			Size                 int           `conf:"default:1000"`
+			TTL                  time.Duration `conf:"default:10s"`
+			StaleWhileRevalidate time.Duration `conf:"default:1m"`
+			StaleIfError         time.Duration `conf:"default:5m"`
+		}
+		// Users configures the cache of the product creators resolved through
+		// the sales-api. CreatorColumn adds their names to the product listing.
+		Users struct {
+			CacheSize     int           `conf:"default:1000"`
+			CacheTTL      time.Duration `conf:"default:5m"`
+			CreatorColumn bool          `conf:"default:false"`
+		}
+		// Events configures the live updates of the product listing. The
+		// products are read every Interval while listings are open, idle
+		// streams get a heartbeat every Heartbeat. A stream falling more
+		// than Buffer changes behind reloads the listing instead, a user
+		// may have StreamsPerUser streams open.
+		Events struct {
+			Interval       time.Duration `conf:"default:10s"`
+			Heartbeat      time.Duration `conf:"default:15s"`
+			Buffer         int           `conf:"default:16"`
+			StreamsPerUser int           `conf:"default:8"`
 		}
+		// Search configures how forgiving the product search is. A search
+		// term may be Fuzziness edits per letter off a word of a name, 0
+		// only finds names the terms are part of. Alternatives names are
+		// offered for a search finding nothing.
+		Search struct {
+			Fuzziness    float64 `conf:"default:0.25"`
+			Alternatives int     `conf:"default:3"`
+		}
+		// Suggest configures the completions of the search box. Limit
+		// completions are returned unless asked for fewer, the names are
+		// read again once older than MaxAge. The names of up to Scopes
+		// token scopes are kept. A session may ask Requests times per
+		// Window, 0 Requests lifts the limit.
+		Suggest struct {
+			Limit    int           `conf:"default:8"`
+			MaxAge   time.Duration `conf:"default:1m"`
+			Scopes   int           `conf:"default:100"`
+			Requests int           `conf:"default:30"`
+			Window   time.Duration `conf:"default:10s"`
+		}
+		// Money configures the currency of all amounts and how they are written.
+		Money struct {
+			Currency string `conf:"default:USD"`
+			Locale   string `conf:"default:en-US"`
+		}
+		// User and Password are the credentials the quality command
+		// reads the products with.
 		Sales struct {
+			User            string
+			Password        string        `conf:"noprint"`
 			BaseURL         string        `conf:"default:http://0.0.0.0:3000/v1"`
 			IdleTimeout     time.Duration `conf:"default:1m"`
 			ReadTimeout     time.Duration `conf:"default:5s"`
@@ -112,23 +261,87 @@ func run(log *log.Logger) error {
 		return errors.Wrap(err, "error: parsing config")
 	}
 
+	log, err := logger.New(os.Stdout, cfg.Log.Format, cfg.Log.Level)
+	if err != nil {
+		return errors.Wrap(err, "initializing logger")
+	}
+	log = log.With("service", cfg.Zipkin.ServiceName)
+	// background work such as the product poller has no request logger,
+	// the sales-api client and the CSRF failure handler fall back to this
+	slog.SetDefault(log)
+
 	// =========================================================================
 	// Start Web Application
 
-	log.Printf("Initializing Application: version %q\n", build)
+	log.Info("initializing application", "version", build)
 
 	out, err := conf.String(&cfg)
 	if err != nil {
 		return errors.Wrap(err, "generating config for output")
 	}
-	log.Printf("Config :\n%v\n", out)
+	log.Info("config", "values", out)
 
-	// initialize template cache
-	templateCache, err := newTemplateCache("./ui/html/")
+	accessLog, err := newAccessLogger(cfg.AccessLog.Format, cfg.AccessLog.Sample, os.Stdout)
+	if err != nil {
+		return errors.Wrap(err, "initializing access log")
+	}
+
+	proxies, err := newProxyResolver(cfg.Web.TrustedProxies)
+	if err != nil {
+		return errors.Wrap(err, "parsing trusted proxies")
+	}
+
+	login := newLoginThrottle(ratelimit.NewMemory(), ratelimit.Policy{
+		Window:      cfg.Login.Window,
+		MaxFailures: cfg.Login.MaxFailures,
+		Lockout:     cfg.Login.Lockout,
+		MaxLockout:  cfg.Login.MaxLockout,
+	}, cfg.Login.ChallengeAfter)
+
+	security := &securityPolicy{
+		csp:               cfg.Security.CSP,
+		cspReportOnly:     cfg.Security.CSPReportOnly,
+		hstsMaxAge:        cfg.Security.HSTSMaxAge,
+		referrerPolicy:    cfg.Security.ReferrerPolicy,
+		permissionsPolicy: cfg.Security.PermissionsPolicy,
+		openerPolicy:      cfg.Security.CrossOriginOpenerPolicy,
+	}
+
+	money, err := product.NewMoneyFormat(cfg.Money.Currency, cfg.Money.Locale)
+	if err != nil {
+		return errors.Wrap(err, "configuring money format")
+	}
+
+	var assets fs.FS = ui.Files
+	if cfg.UI.Dir != "" {
+		assets = os.DirFS(cfg.UI.Dir)
+	}
+	locales, html, static, err := uiDirs(assets)
+	if err != nil {
+		return errors.Wrap(err, "opening ui")
+	}
+
+	catalog, err := i18n.Load(locales, cfg.I18n.Fallback)
 	if err != nil {
-		log.Fatal(err)
+		return errors.Wrap(err, "loading message catalogs")
 	}
 
+	// the quality command checks the products and exits instead of serving
+	if cfg.Args.Num(0) == "quality" {
+		app := &application{keyID: cfg.IdentityProvider.KeyID, log: log, money: money, salesURL: cfg.Sales.BaseURL}
+		ctx := context.Background()
+		token, err := app.salesToken(ctx, cfg.Sales.User, cfg.Sales.Password)
+		if err != nil {
+			return errors.Wrap(err, "requesting sales-api token")
+		}
+		return app.runQuality(ctx, token, os.Stdout, catalog.Localizer(""))
+	}
+
+	responses := newResponseCache(cfg.ResponseCache.Size, cfg.ResponseCache.TTL,
+		cfg.ResponseCache.StaleWhileRevalidate, cfg.ResponseCache.StaleIfError)
+	suggest := newSuggestIndex(cfg.Suggest.Scopes, cfg.Suggest.MaxAge, min(cfg.Suggest.Limit, maxSuggestions),
+		ratelimit.NewCounter(ratelimit.NewMemory(), cfg.Suggest.Requests, cfg.Suggest.Window))
+
 	decoded, err := base64.StdEncoding.DecodeString(cfg.Web.SessionSecret)
 	if err != nil {
 		return errors.Wrap(err, "decoding session secret")
@@ -151,17 +364,58 @@ func run(log *log.Logger) error {
 	signal.Notify(shutdown, os.Interrupt, syscall.SIGTERM)
 
 	app := &application{
+		accessLog:     accessLog,
+		alternatives:  cfg.Search.Alternatives,
+		catalog:       catalog,
+		creatorColumn: cfg.Users.CreatorColumn,
 		debug:         cfg.Web.DebugMode,
 		debugURL:      cfg.Debug.BaseURL,
+		events:        newEventHub(cfg.Events.Buffer, cfg.Events.StreamsPerUser, cfg.Events.Heartbeat),
+		fuzziness:     cfg.Search.Fuzziness,
 		keyID:         cfg.IdentityProvider.KeyID,
 		log:           log,
+		login:         login,
+		lowStock:      cfg.Dashboard.LowStock,
+		money:         money,
+		proxies:       proxies,
+		responses:     responses,
+		security:      security,
 		salesURL:      cfg.Sales.BaseURL,
 		session:       session,
 		shutdown:      shutdown,
-		templateCache: templateCache,
+		suggest:       suggest,
+		topN:          cfg.Dashboard.Top,
+		users:         cache.New[string, *user.User](cfg.Users.CacheSize, cfg.Users.CacheTTL),
 		useTLS:        cfg.Web.EnableTLS,
 	}
 
+	// in debug mode changes of the ui on disk are picked up without a
+	// restart, and template errors are shown in the browser
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	watching := app.debug && cfg.UI.Dir != ""
+	if watching {
+		app.reload = newLiveReload()
+		go func() {
+			if err := app.watchUI(ctx, assets, html, static, cfg.UI.PollInterval); err != nil {
+				log.Error("watching ui", "error", err)
+			}
+		}()
+	}
+
+	// the open listings are updated with the changes of the products
+	go app.watchProducts(ctx, cfg.Events.Interval)
+
+	// the templates link the static assets by their fingerprints
+	if err := app.loadStatic(static); err != nil {
+		return errors.Wrap(err, "loading static assets")
+	}
+
+	// initialize template cache
+	if err := app.loadTemplates(html); err != nil && !watching {
+		return errors.Wrap(err, "parsing templates")
+	}
+
 	// use Go’s favored cipher suites (support for forward secrecy)
 	// and elliptic curves that are performant under heavy loads
 	tlsConfig := &tls.Config{
@@ -181,7 +435,7 @@ func run(log *log.Logger) error {
 	// =========================================================================
 	// Start Tracing Support
 
-	log.Println("Initializing zipkin tracing support")
+	log.Info("initializing zipkin tracing support")
 
 	shutdownTP, err := tracer.Init(cfg.Zipkin.ServiceName, cfg.Zipkin.ReporterURI, cfg.Zipkin.Probability, log)
 	if err != nil {
@@ -190,7 +444,7 @@ func run(log *log.Logger) error {
 
 	defer func() {
 		if err := shutdownTP(context.Background()); err != nil {
-			log.Fatal("failed to shutdown TracerProvider: %w", err)
+			log.Error("shutting down tracer provider", "error", err)
 		}
 	}()
 
@@ -202,18 +456,22 @@ func run(log *log.Logger) error {
 	go func() {
 		var b strings.Builder
 		if cfg.Web.Host[:1] == ":" {
-			fmt.Fprintf(&b, "%s%s/", getOutboundIP(), cfg.Web.Host)
+			ip, err := getOutboundIP()
+			if err != nil {
+				log.Warn("resolving outbound ip", "error", err)
+			}
+			fmt.Fprintf(&b, "%s%s/", ip, cfg.Web.Host)
 		} else {
 			fmt.Fprintf(&b, "%s/", cfg.Web.Host)
 		}
 
 		if app.useTLS {
-			log.Printf("Starting server @ https://%s", b.String())
+			log.Info("starting server", "url", "https://"+b.String())
 			serverErrors <- srv.ListenAndServeTLS("./tls/localhost/cert.pem", "./tls/localhost/key.pem")
 			return
 		}
 
-		log.Printf("Starting server @ http://%s", b.String())
+		log.Info("starting server", "url", "http://"+b.String())
 		serverErrors <- srv.ListenAndServe()
 	}()
 
@@ -226,7 +484,7 @@ func run(log *log.Logger) error {
 		return errors.Wrap(err, "server error")
 
 	case sig := <-shutdown:
-		log.Printf("main : %v : Start shutdown", sig)
+		log.Info("start shutdown", "signal", sig)
 
 		// Give outstanding requests a deadline for completion.
 		ctx, cancel := context.WithTimeout(context.Background(), cfg.Web.ShutdownTimeout)
@@ -235,7 +493,7 @@ func run(log *log.Logger) error {
 		// Trigger graceful shutdown of the server, listeners.
 		err := srv.Shutdown(ctx)
 		if err != nil {
-			log.Printf("Graceful shutdown did not complete in %v : %v", cfg.Web.ShutdownTimeout, err)
+			log.Warn("graceful shutdown did not complete", "timeout", cfg.Web.ShutdownTimeout, "error", err)
 			err = srv.Close()
 		}
 
@@ -251,15 +509,30 @@ func run(log *log.Logger) error {
 	return nil
 }
 
+// uiDirs returns the message catalogs, templates and static assets of the
+// ui file system.
+func uiDirs(fsys fs.FS) (locales, html, static fs.FS, err error) {
+	if locales, err = fs.Sub(fsys, "locales"); err != nil {
+		return nil, nil, nil, err
+	}
+	if html, err = fs.Sub(fsys, "html"); err != nil {
+		return nil, nil, nil, err
+	}
+	if static, err = fs.Sub(fsys, "static"); err != nil {
+		return nil, nil, nil, err
+	}
+	return locales, html, static, nil
+}
+
 // Get the preferred outbound IP address of this machine.
-func getOutboundIP() net.IP {
+func getOutboundIP() (net.IP, error) {
 	conn, err := net.Dial("udp", "1.1.1.1:80")
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 	defer conn.Close()
 
 	localAddr := conn.LocalAddr().(*net.UDPAddr)
 
-	return localAddr.IP
+	return localAddr.IP, nil
 }
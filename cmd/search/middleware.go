@@ -2,21 +2,22 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 
+	"github.com/justinas/alice"
 	"github.com/justinas/nosurf"
+	"github.com/tullo/search/internal/logger"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-func secureHeaders(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("X-XSS-Protection", "1; mode=block")
-		w.Header().Set("X-Frame-Options", "deny")
-
-		next.ServeHTTP(w, r)
-	})
-}
+// requestIDHeader carries the request ID from and back to the client.
+const requestIDHeader = "X-Request-ID"
 
 // noSurf uses a customized CSRF cookie with the Secure, Path and HttpOnly flags set
 func noSurf(next http.Handler) http.Handler {
@@ -31,19 +32,54 @@ func noSurf(next http.Handler) http.Handler {
 		SameSite: http.SameSiteStrictMode,
 		Secure:   true, // for transport over https
 	})
+	// the scheme seen by the client decides how the request origin is checked
+	csrfHandler.SetIsTLSFunc(func(r *http.Request) bool {
+		return remoteClient(r).Scheme == "https"
+	})
 	csrfHandler.SetFailureHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger.FromContext(r.Context(), slog.Default()).WarnContext(r.Context(), "csrf failure", "reason", nosurf.Reason(r))
+		if wantsJSON(r) {
+			writeJSONError(w, r, http.StatusBadRequest, "CSRF check failed")
+			return
+		}
 		w.WriteHeader(http.StatusBadRequest)
-		log.Println("CSRF failure:", nosurf.Reason(r))
 	}))
 
 	return csrfHandler
 }
 
-func (app *application) logRequest(next http.Handler) http.Handler {
+// requestID assigns every request an ID, echoes it back in a response header
+// and stores a logger annotated with it in the request context. A well formed
+// ID provided by the client (or a proxy in front of us) is kept.
+func (app *application) requestID(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		app.log.Printf("%s - %s %s %s", r.RemoteAddr, r.Proto, r.Method, r.URL.RequestURI())
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
 
-		next.ServeHTTP(w, r)
+		ctx := context.WithValue(r.Context(), contextKeyRequestID, id)
+		ctx = logger.NewContext(ctx, app.log.With("request_id", id))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// traceRequest starts the server span every other span of the request is a
+// child of, so that all log lines of a request share the same trace ID.
+func (app *application) traceRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := otel.Tracer(name).Start(r.Context(), "http.request", trace.WithSpanKind(trace.SpanKindServer))
+		defer span.End()
+
+		span.SetAttributes(
+			attribute.String("http.method", r.Method),
+			attribute.String("http.target", r.URL.Path),
+			attribute.String("client.address", remoteClient(r).IP),
+			attribute.String("request_id", requestIDFromContext(ctx)),
+		)
+
+		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
@@ -58,7 +94,7 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 				// after a response has been sent.
 				w.Header().Set("Connection", "close")
 				// format error with default textual representation
-				app.serverError(w, fmt.Errorf("%s", err))
+				app.serverError(w, r, fmt.Errorf("%s", err))
 			}
 		}()
 
@@ -66,12 +102,17 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 	})
 }
 
-// requireAuthentication redirects the unauthenticated user to the login page
+// requireAuthentication redirects the unauthenticated user to the login page,
+// JSON clients get a 401 instead
 func (app *application) requireAuthentication(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !app.isAuthenticated(r) && wantsJSON(r) {
+			app.clientError(w, r, http.StatusUnauthorized)
+			return
+		}
 		if !app.isAuthenticated(r) {
-			// add the path the user is trying to access to session data
-			app.session.Put(r, "redirectPathAfterLogin", r.URL.Path)
+			// add the URL the user is trying to access to session data
+			app.session.Put(r, "redirectPathAfterLogin", remoteClient(r).Origin()+r.URL.RequestURI())
 			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
 			return
 		}
@@ -83,6 +124,50 @@ func (app *application) requireAuthentication(next http.Handler) http.Handler {
 	})
 }
 
+// streaming runs h behind the middleware of chain, but hands it the writer
+// the chain got instead of the one session.Enable buffers the response in.
+// The middleware may still answer in its place, a redirect to the login
+// page saves the session as usual. Changes h makes to the session are not
+// saved, its cookie would have to go out before the streamed body.
+func streaming(chain alice.Chain, h http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		chain.ThenFunc(func(_ http.ResponseWriter, r *http.Request) {
+			h(w, r)
+		}).ServeHTTP(w, r)
+	})
+}
+
+// requireAdmin turns away authenticated users without the admin role. It
+// goes after requireAuthentication.
+func (app *application) requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !app.hasRole(r, roleAdmin) {
+			app.clientError(w, r, http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// localize negotiates the language of the request, preferring the choice
+// stored in the session over the Accept-Language header, and applies the
+// time zone of the user.
+func (app *application) localize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lang := app.catalog.Negotiate(app.session.GetString(r, "locale"), r.Header.Get("Accept-Language"))
+		w.Header().Set("Content-Language", lang)
+		w.Header().Add("Vary", "Accept-Language")
+
+		l := app.catalog.Localizer(lang)
+		if loc, err := loadLocation(app.session.GetString(r, "timezone")); err == nil {
+			l = l.In(loc)
+		}
+
+		ctx := context.WithValue(r.Context(), contextKeyLocalizer, l)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
 // authenticate checks the database for user status (active)
 func (app *application) authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -92,6 +177,7 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			next.ServeHTTP(w, r)
 			return
 		}
+		setAccessLogUser(r.Context(), app.session.GetString(r, "authenticatedUserID"))
 
 		// request is coming from an authenticated & 'active' user,
 		// add key/value pair to the request context - to be used further down the chain
@@ -99,3 +185,25 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
+
+// newRequestID returns a random 128 bit ID in hex encoding.
+func newRequestID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// validRequestID reports whether a client provided ID is safe to log and echo.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_', c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -1,41 +1,60 @@
 package main
 
 import (
+	"bytes"
+	"compress/gzip"
+	"crypto/tls"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 )
 
 func TestSecureHeaders(t *testing.T) {
+	app := newTestApplication(t)
 	rr := httptest.NewRecorder()
 
-	r, err := http.NewRequest(http.MethodGet, "/", nil)
+	r, err := http.NewRequest(http.MethodGet, "https://example.com/", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
+	r.TLS = &tls.ConnectionState{}
 
 	// mock handler fn that returns 200 status code and "OK" response body
+	var nonce string
 	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nonce = cspNonce(r)
 		w.Write([]byte("OK"))
 	})
 
 	// execute the middleware fn using the mock handler
-	secureHeaders(next).ServeHTTP(rr, r)
+	app.secureHeaders(next).ServeHTTP(rr, r)
 
 	rs := rr.Result()
 	defer rs.Body.Close()
 
-	// check the X-Frame-Options header
-	frameOptions := rs.Header.Get("X-Frame-Options")
-	if frameOptions != "deny" {
-		t.Errorf("want %q; got %q", "deny", frameOptions)
+	if nonce == "" {
+		t.Fatal("want a CSP nonce in the request context")
 	}
 
-	// check the X-XSS-Protection header
-	xssProtection := rs.Header.Get("X-XSS-Protection")
-	if xssProtection != "1; mode=block" {
-		t.Errorf("want %q; got %q", "1; mode=block", xssProtection)
+	headers := []struct {
+		name string
+		want string
+	}{
+		{"Content-Security-Policy", "default-src 'self'; script-src 'self' 'nonce-" + nonce + "'; object-src 'none'; report-uri /csp-report"},
+		{"Strict-Transport-Security", "max-age=31536000; includeSubDomains"},
+		{"Referrer-Policy", "strict-origin-when-cross-origin"},
+		{"Permissions-Policy", "camera=(), geolocation=()"},
+		{"Cross-Origin-Opener-Policy", "same-origin"},
+		{"X-Content-Type-Options", "nosniff"},
+		{"X-Frame-Options", "deny"},
+		{"X-XSS-Protection", "0"},
+	}
+	for _, h := range headers {
+		if got := rs.Header.Get(h.name); got != h.want {
+			t.Errorf("%s: want %q; got %q", h.name, h.want, got)
+		}
 	}
 
 	// check that the middleware has called the next handler in line
@@ -53,3 +72,255 @@ func TestSecureHeaders(t *testing.T) {
 		t.Errorf("want body to equal %q", "OK")
 	}
 }
+
+func TestRequestID(t *testing.T) {
+	app := newTestApplication(t)
+
+	tests := []struct {
+		name      string
+		requestID string
+		keep      bool
+	}{
+		{"Generated", "", false},
+		{"Provided", "f3b3c0e4-req.1", true},
+		{"Malformed", "bad id\r\nX-Injected: 1", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			r, err := http.NewRequest(http.MethodGet, "/", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.requestID != "" {
+				r.Header.Set(requestIDHeader, tt.requestID)
+			}
+
+			var seen string
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				seen = requestIDFromContext(r.Context())
+			})
+			app.requestID(next).ServeHTTP(rr, r)
+
+			got := rr.Result().Header.Get(requestIDHeader)
+			if got == "" {
+				t.Fatal("want request id response header")
+			}
+			if got != seen {
+				t.Errorf("want context id %q; got %q", got, seen)
+			}
+			if tt.keep && got != tt.requestID {
+				t.Errorf("want %q; got %q", tt.requestID, got)
+			}
+			if !tt.keep && got == tt.requestID {
+				t.Errorf("want generated id; got %q", got)
+			}
+		})
+	}
+}
+
+func TestLogRequest(t *testing.T) {
+	var buf bytes.Buffer
+	al, err := newAccessLogger(accessLogCombined, map[string]float64{"/ping": 0}, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := newTestApplication(t)
+	app.accessLog = al
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		setAccessLogUser(r.Context(), "5cf37266")
+		w.WriteHeader(http.StatusTeapot)
+		w.Write([]byte("short and stout"))
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "/product/1?x=y", nil)
+	r.Header.Set("User-Agent", "tester")
+	app.logRequest(next).ServeHTTP(httptest.NewRecorder(), r)
+
+	line := buf.String()
+	for _, want := range []string{`192.0.2.1 - 5cf37266 [`, `"GET /product/1?x=y HTTP/1.1" 418 15 "" "tester"`} {
+		if !strings.Contains(line, want) {
+			t.Errorf("want %q in %q", want, line)
+		}
+	}
+
+	buf.Reset()
+	app.logRequest(next).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/ping", nil))
+	if buf.Len() != 0 {
+		t.Errorf("want excluded path not to be logged; got %q", buf.String())
+	}
+}
+
+func TestSecureHeadersReportOnly(t *testing.T) {
+	app := newTestApplication(t)
+	app.security.cspReportOnly = true
+
+	rr := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+	app.secureHeaders(http.NotFoundHandler()).ServeHTTP(rr, r)
+
+	rs := rr.Result()
+	if rs.Header.Get("Content-Security-Policy") != "" {
+		t.Error("want no enforced policy in report-only mode")
+	}
+	if rs.Header.Get("Content-Security-Policy-Report-Only") == "" {
+		t.Error("want a report-only policy")
+	}
+	if rs.Header.Get("Strict-Transport-Security") != "" {
+		t.Error("want no HSTS header on plain http")
+	}
+}
+
+func TestCSPReport(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	report := `{"csp-report":{"document-uri":"https://example.com/","violated-directive":"script-src"}}`
+	rs, err := ts.Client().Post(ts.URL+cspReportPath, "application/csp-report", strings.NewReader(report))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs.Body.Close()
+	if rs.StatusCode != http.StatusNoContent {
+		t.Errorf("want %d; got %d", http.StatusNoContent, rs.StatusCode)
+	}
+
+	rs, err = ts.Client().Post(ts.URL+cspReportPath, "application/csp-report", strings.NewReader("not json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs.Body.Close()
+	if rs.StatusCode != http.StatusBadRequest {
+		t.Errorf("want %d; got %d", http.StatusBadRequest, rs.StatusCode)
+	}
+}
+
+func TestCompress(t *testing.T) {
+	app := newTestApplication(t)
+	page := strings.Repeat("<p>search</p>", 200)
+
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		handler        http.HandlerFunc
+		wantEncoding   string
+		wantVary       bool
+	}{
+		{"HTML", "gzip, deflate", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			io.WriteString(w, page)
+		}, "gzip", true},
+		{"Sniffed", "gzip", func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, page)
+		}, "gzip", true},
+		{"Not accepted", "identity", func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, page)
+		}, "", true},
+		{"Refused", "gzip;q=0", func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, page)
+		}, "", true},
+		{"Small", "gzip", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, `{"ok":true}`)
+		}, "", true},
+		{"Compressed type", "gzip", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "image/png")
+			io.WriteString(w, page)
+		}, "", false},
+		{"Event stream", "gzip", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.WriteHeader(http.StatusOK)
+			http.NewResponseController(w).Flush()
+			io.WriteString(w, page)
+		}, "", false},
+		{"Not modified", "gzip", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			w.WriteHeader(http.StatusNotModified)
+		}, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			rr := httptest.NewRecorder()
+
+			app.compress(tt.handler).ServeHTTP(rr, r)
+
+			rs := rr.Result()
+			if ce := rs.Header.Get("Content-Encoding"); ce != tt.wantEncoding {
+				t.Errorf("want Content-Encoding %q; got %q", tt.wantEncoding, ce)
+			}
+			if vary := rs.Header.Get("Vary") == "Accept-Encoding"; vary != tt.wantVary {
+				t.Errorf("want Vary: Accept-Encoding %t; got %q", tt.wantVary, rs.Header.Get("Vary"))
+			}
+			if rs.StatusCode == http.StatusNotModified {
+				return
+			}
+
+			body := rs.Body
+			if tt.wantEncoding == "gzip" {
+				zr, err := gzip.NewReader(rs.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				body = zr
+			}
+			b, err := io.ReadAll(body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(b) < len(`{"ok":true}`) || !strings.Contains(page+`{"ok":true}`, string(b)) {
+				t.Errorf("want the body unchanged; got %d bytes", len(b))
+			}
+		})
+	}
+}
+
+func TestCompressPages(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/user/login", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept-Encoding", "gzip")
+	code, header, body := ts.clientDo(t, req)
+	if code != http.StatusOK {
+		t.Fatalf("want %d; got %d", http.StatusOK, code)
+	}
+	if ce := header.Get("Content-Encoding"); ce != "gzip" {
+		t.Fatalf("want Content-Encoding gzip; got %q", ce)
+	}
+
+	// the headers of the security and CSRF middleware are kept
+	if header.Get("Content-Security-Policy") == "" {
+		t.Error("want a Content-Security-Policy")
+	}
+	if !strings.Contains(header.Get("Set-Cookie"), "csrf_token") {
+		t.Error("want the CSRF cookie")
+	}
+	vary := strings.Join(header.Values("Vary"), ", ")
+	for _, want := range []string{"Accept", "Cookie", "Accept-Language", "Accept-Encoding"} {
+		if !varies(header, want) {
+			t.Errorf("want Vary to name %s; got %q", want, vary)
+		}
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(page, []byte(`name='csrf_token'`)) {
+		t.Error("want the login form with the CSRF token")
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultPerPage = 20
+	maxPerPage     = 100
+)
+
+var errInvalidPage = errors.New("invalid page parameters")
+
+// pagination describes a page of a listing and links to its neighbours. The
+// sales-api does not report the total number of rows, a full page is taken
+// as a sign that there is a next one.
+type pagination struct {
+	Page    int    `json:"page"`
+	PerPage int    `json:"per_page"`
+	Count   int    `json:"count"`
+	Total   int    `json:"total,omitempty"` // known if the listing was filtered or sorted here
+	Prev    string `json:"prev,omitempty"`
+	Next    string `json:"next,omitempty"`
+}
+
+// pageParams reads the page and per_page query parameters.
+func pageParams(q url.Values) (page, perPage int, err error) {
+	page, perPage = 1, defaultPerPage
+	if s := q.Get("page"); s != "" {
+		if page, err = strconv.Atoi(s); err != nil || page < 1 {
+			return 0, 0, errInvalidPage
+		}
+	}
+	if s := q.Get("per_page"); s != "" {
+		if perPage, err = strconv.Atoi(s); err != nil || perPage < 1 || perPage > maxPerPage {
+			return 0, 0, errInvalidPage
+		}
+	}
+	return page, perPage, nil
+}
+
+// newPagination describes the page of u holding count rows. The links keep
+// the other query parameters of u, e.g. the requested format.
+func newPagination(u *url.URL, page, perPage, count int) *pagination {
+	p := pagination{Page: page, PerPage: perPage, Count: count}
+
+	link := func(page int) string {
+		q := u.Query()
+		for k := range q {
+			// drop the route parameters added by the router and the
+			// fragment asked for, the links lead to whole pages
+			if strings.HasPrefix(k, ":") || k == fragmentParam {
+				q.Del(k)
+			}
+		}
+		q.Set("page", strconv.Itoa(page))
+		return u.Path + "?" + q.Encode()
+	}
+	if page > 1 {
+		p.Prev = link(page - 1)
+	}
+	if count >= perPage {
+		p.Next = link(page + 1)
+	}
+
+	return &p
+}
+
+// setTotal drops the link to the next page if there are no more rows.
+func (p *pagination) setTotal(total int) {
+	p.Total = total
+	if p.Page*p.PerPage >= total {
+		p.Next = ""
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// clientInfo describes the client as seen by the first trusted hop.
+type clientInfo struct {
+	IP     string
+	Scheme string
+	Host   string
+}
+
+// Origin returns the scheme and host the client used to reach us.
+func (c clientInfo) Origin() string {
+	return c.Scheme + "://" + c.Host
+}
+
+const contextKeyClientInfo = contextKey("clientInfo")
+
+// proxyResolver resolves the real client behind a chain of reverse proxies.
+// Forwarding headers are only believed when they were set by a trusted hop,
+// anyone else could send them to spoof their address.
+type proxyResolver struct {
+	trusted []netip.Prefix
+}
+
+// newProxyResolver parses the CIDRs (or single addresses) of trusted proxies.
+func newProxyResolver(cidrs []string) (*proxyResolver, error) {
+	var pr proxyResolver
+	for _, c := range cidrs {
+		c = strings.TrimSpace(c)
+		if c == "" {
+			continue
+		}
+		if !strings.Contains(c, "/") {
+			addr, err := netip.ParseAddr(c)
+			if err != nil {
+				return nil, fmt.Errorf("parsing trusted proxy %q: %w", c, err)
+			}
+			pr.trusted = append(pr.trusted, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		p, err := netip.ParsePrefix(c)
+		if err != nil {
+			return nil, fmt.Errorf("parsing trusted proxy %q: %w", c, err)
+		}
+		pr.trusted = append(pr.trusted, p.Masked())
+	}
+	return &pr, nil
+}
+
+func (pr *proxyResolver) isTrusted(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, p := range pr.trusted {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedHop is one entry of the forwarding chain, the client end first.
+type forwardedHop struct {
+	ip     string
+	scheme string
+	host   string
+}
+
+// resolve walks the forwarding chain from the nearest hop backwards and
+// stops at the first address that is not a trusted proxy.
+func (pr *proxyResolver) resolve(r *http.Request) clientInfo {
+	ci := clientInfo{
+		IP:     hostOnly(r.RemoteAddr),
+		Scheme: "http",
+		Host:   r.Host,
+	}
+	if r.TLS != nil {
+		ci.Scheme = "https"
+	}
+	if !pr.isTrusted(ci.IP) {
+		return ci
+	}
+
+	hops := parseForwarded(r.Header.Values("Forwarded"))
+	if len(hops) == 0 {
+		hops = parseXForwarded(r.Header)
+	}
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := hops[i]
+		if hop.ip == "" {
+			// obfuscated or unknown identifiers end the chain we can follow
+			break
+		}
+		ci.IP = hop.ip
+		if hop.scheme != "" {
+			ci.Scheme = hop.scheme
+		}
+		if hop.host != "" {
+			ci.Host = hop.host
+		}
+		if !pr.isTrusted(hop.ip) {
+			break
+		}
+	}
+	return ci
+}
+
+// parseForwarded parses RFC 7239 Forwarded header values.
+func parseForwarded(values []string) []forwardedHop {
+	var hops []forwardedHop
+	for _, v := range values {
+		for _, elem := range strings.Split(v, ",") {
+			var hop forwardedHop
+			for _, pair := range strings.Split(elem, ";") {
+				k, val, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if !ok {
+					continue
+				}
+				val = strings.Trim(val, `"`)
+				switch strings.ToLower(k) {
+				case "for":
+					hop.ip = forwardedIP(val)
+				case "proto":
+					hop.scheme = strings.ToLower(val)
+				case "host":
+					hop.host = val
+				}
+			}
+			hops = append(hops, hop)
+		}
+	}
+	return hops
+}
+
+// parseXForwarded parses the de-facto X-Forwarded-* headers. The scheme and
+// host are attributed to the client hop, since proxies typically set them once.
+func parseXForwarded(h http.Header) []forwardedHop {
+	var hops []forwardedHop
+	for _, v := range h.Values("X-Forwarded-For") {
+		for _, ip := range strings.Split(v, ",") {
+			hops = append(hops, forwardedHop{ip: forwardedIP(strings.TrimSpace(ip))})
+		}
+	}
+	if len(hops) == 0 {
+		return nil
+	}
+	hops[0].scheme = strings.ToLower(firstValue(h.Get("X-Forwarded-Proto")))
+	hops[0].host = firstValue(h.Get("X-Forwarded-Host"))
+	for i := 1; i < len(hops); i++ {
+		hops[i].scheme, hops[i].host = hops[0].scheme, hops[0].host
+	}
+	return hops
+}
+
+// forwardedIP normalizes a node identifier ("192.0.2.1", "[2001:db8::1]:443")
+// to a bare IP address, or returns "" if it is not one.
+func forwardedIP(node string) string {
+	node = strings.Trim(node, `"`)
+	if strings.HasPrefix(node, "[") {
+		if i := strings.IndexByte(node, ']'); i > 0 {
+			node = node[1:i]
+		}
+	} else if strings.Count(node, ":") == 1 {
+		node = hostOnly(node)
+	}
+	addr, err := netip.ParseAddr(node)
+	if err != nil {
+		return ""
+	}
+	return addr.Unmap().String()
+}
+
+func firstValue(v string) string {
+	first, _, _ := strings.Cut(v, ",")
+	return strings.TrimSpace(first)
+}
+
+func hostOnly(hostport string) string {
+	host, _, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return hostport
+	}
+	return host
+}
+
+// resolveClient stores the resolved client information in the request context.
+func (app *application) resolveClient(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), contextKeyClientInfo, app.proxies.resolve(r))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// remoteClient returns the resolved client information of the request.
+func remoteClient(r *http.Request) clientInfo {
+	if ci, ok := r.Context().Value(contextKeyClientInfo).(clientInfo); ok {
+		return ci
+	}
+	ci := clientInfo{IP: hostOnly(r.RemoteAddr), Scheme: "http", Host: r.Host}
+	if r.TLS != nil {
+		ci.Scheme = "https"
+	}
+	return ci
+}
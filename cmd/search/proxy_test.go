@@ -0,0 +1,119 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestProxyResolver(t *testing.T) {
+	pr, err := newProxyResolver([]string{"10.0.0.0/8", "35.191.0.0/16", "2001:db8::1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		remote  string
+		tls     bool
+		headers map[string]string
+		want    clientInfo
+	}{
+		{
+			name:   "Direct",
+			remote: "203.0.113.7:51234",
+			want:   clientInfo{IP: "203.0.113.7", Scheme: "http", Host: "example.com"},
+		},
+		{
+			name:    "Untrusted hop spoofing headers",
+			remote:  "203.0.113.7:51234",
+			tls:     true,
+			headers: map[string]string{"X-Forwarded-For": "1.2.3.4", "X-Forwarded-Proto": "http"},
+			want:    clientInfo{IP: "203.0.113.7", Scheme: "https", Host: "example.com"},
+		},
+		{
+			name:   "X-Forwarded-For through two trusted hops",
+			remote: "10.1.2.3:8080",
+			headers: map[string]string{
+				"X-Forwarded-For":   "1.2.3.4, 198.51.100.9, 35.191.3.4",
+				"X-Forwarded-Proto": "https",
+				"X-Forwarded-Host":  "search.example.org",
+			},
+			want: clientInfo{IP: "198.51.100.9", Scheme: "https", Host: "search.example.org"},
+		},
+		{
+			name:    "All hops trusted",
+			remote:  "10.1.2.3:8080",
+			headers: map[string]string{"X-Forwarded-For": "10.9.9.9, 10.8.8.8"},
+			want:    clientInfo{IP: "10.9.9.9", Scheme: "http", Host: "example.com"},
+		},
+		{
+			name:    "Forwarded",
+			remote:  "[2001:db8::1]:443",
+			headers: map[string]string{"Forwarded": `for=198.51.100.9;proto=https;host=shop.example, for="[2001:db8::2]:4711";proto=http`},
+			want:    clientInfo{IP: "2001:db8::2", Scheme: "http", Host: "example.com"},
+		},
+		{
+			name:    "Forwarded through trusted hop",
+			remote:  "10.0.0.1:443",
+			headers: map[string]string{"Forwarded": `for=198.51.100.9;proto=https;host=shop.example, for=10.0.0.2`},
+			want:    clientInfo{IP: "198.51.100.9", Scheme: "https", Host: "shop.example"},
+		},
+		{
+			name:    "Obfuscated identifier",
+			remote:  "10.0.0.1:443",
+			headers: map[string]string{"Forwarded": `for=_hidden, for=10.0.0.2`},
+			want:    clientInfo{IP: "10.0.0.2", Scheme: "http", Host: "example.com"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "http://example.com/", nil)
+			r.RemoteAddr = tt.remote
+			if tt.tls {
+				r.TLS = &tls.ConnectionState{}
+			} else {
+				r.TLS = nil
+			}
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+
+			got := pr.resolve(r)
+			if got != tt.want {
+				t.Errorf("want %+v; got %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestProxyResolverGoogleLoadBalancer(t *testing.T) {
+	// the external load balancer forwards from a Google front end and adds
+	// the client and its own address, 203.0.113.10 here
+	r := httptest.NewRequest("GET", "http://example.com/", nil)
+	r.RemoteAddr = "35.191.12.34:40112"
+	r.Header.Set("X-Forwarded-For", "198.51.100.9, 203.0.113.10")
+	r.Header.Set("X-Forwarded-Proto", "https")
+
+	tests := []struct {
+		name    string
+		trusted []string
+		want    string
+	}{
+		{"Front ends only", []string{"130.211.0.0/22", "35.191.0.0/16"}, "203.0.113.10"},
+		{"Front ends and load balancer", []string{"130.211.0.0/22", "35.191.0.0/16", "203.0.113.10"}, "198.51.100.9"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pr, err := newProxyResolver(tt.trusted)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := pr.resolve(r); got.IP != tt.want || got.Scheme != "https" {
+				t.Errorf("want %s over https; got %+v", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"text/tabwriter"
+
+	"github.com/tullo/search/internal/i18n"
+	"github.com/tullo/search/internal/product"
+	"github.com/tullo/search/internal/quality"
+)
+
+// roleAdmin is the sales-api role allowed to see the data-quality report.
+const roleAdmin = "ADMIN"
+
+var (
+	// errViolations is returned by the quality command if a product breaks
+	// a rule.
+	errViolations = errors.New("data-quality violations found")
+	// errUnchecked is returned by the quality command if a rule could not
+	// be checked for a product.
+	errUnchecked = errors.New("data-quality rules not checked")
+)
+
+// qualityView is the data-quality report: the number of products checked,
+// the violations per rule, the violations themselves and the rules that
+// could not be checked.
+type qualityView struct {
+	Checked    int
+	Rules      []qualityRule
+	Violations []quality.Violation
+	Unchecked  []quality.Unchecked
+}
+
+type qualityRule struct {
+	Name      string
+	Count     int
+	Unchecked int
+}
+
+func newQualityView(c *quality.Checker) *qualityView {
+	counts, unchecked := c.Counts(), c.UncheckedCounts()
+	v := qualityView{Checked: c.Checked, Violations: c.Violations, Unchecked: c.Unchecked}
+	for _, name := range c.Rules() {
+		v.Rules = append(v.Rules, qualityRule{Name: name, Count: counts[name], Unchecked: unchecked[name]})
+	}
+	return &v
+}
+
+// checkQuality runs the default rules against all products of the sales-api.
+// A failed lookup of a creator leaves the rule unchecked for the product.
+func (app *application) checkQuality(ctx context.Context, token string) (*quality.Checker, error) {
+	userExists := func(ctx context.Context, id string) (bool, error) {
+		_, err := app.fetchUser(ctx, token, id)
+		if upstreamStatus(err) == http.StatusNotFound {
+			return false, nil
+		}
+		return err == nil, err
+	}
+
+	c := quality.NewChecker(quality.DefaultRules(userExists)...)
+	err := app.eachProduct(ctx, token, uncachedPages, func(p product.Product) error {
+		return c.Check(ctx, p)
+	})
+	return c, err
+}
+
+// runQuality is the quality command: it checks all products with the token
+// and writes the report to w. It returns errViolations if a product breaks
+// a rule and errUnchecked if a rule could not be checked, so that the
+// command can be used in scripts and pipelines.
+func (app *application) runQuality(ctx context.Context, token string, w io.Writer, l *i18n.Localizer) error {
+	c, err := app.checkQuality(ctx, token)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	if len(c.Violations) > 0 || len(c.Unchecked) > 0 {
+		fmt.Fprintln(tw, "RULE\tPRODUCT\tNAME\tPROBLEM")
+		for _, v := range c.Violations {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", v.Rule, v.Product.ID, v.Product.Name, v.Text(l, app.money))
+		}
+		for _, u := range c.Unchecked {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s: %v\n", u.Rule, u.Product.ID, u.Product.Name, l.T("quality.unchecked"), u.Err)
+		}
+		fmt.Fprintln(tw)
+	}
+	counts := c.Counts()
+	for _, name := range c.Rules() {
+		fmt.Fprintf(tw, "%s\t%d\n", name, counts[name])
+	}
+	fmt.Fprintf(tw, "%d products checked, %d violations", c.Checked, len(c.Violations))
+	if len(c.Unchecked) > 0 {
+		fmt.Fprintf(tw, ", %d unchecked", len(c.Unchecked))
+	}
+	fmt.Fprintln(tw)
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	switch {
+	case len(c.Violations) > 0:
+		return errViolations
+	case len(c.Unchecked) > 0:
+		return errUnchecked
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tullo/search/internal/watch"
+)
+
+// reloadPath is the event stream telling browsers to reload in debug mode.
+const reloadPath = "/debug/reload"
+
+// templateSet holds the parsed pages. A failed reload keeps the pages of
+// the last successful one and records the error, so that it can be shown
+// in the browser instead of taking the server down.
+type templateSet struct {
+	pages map[string]*template.Template
+	err   error
+}
+
+// loadTemplates parses the pages of fsys and swaps them in as a whole, so
+// that a request never sees a half-parsed set.
+func (app *application) loadTemplates(fsys fs.FS) error {
+	pages, err := newTemplateCache(fsys, app.staticURL)
+	if err != nil {
+		var last map[string]*template.Template
+		if ts := app.templates.Load(); ts != nil {
+			last = ts.pages
+		}
+		app.templates.Store(&templateSet{pages: last, err: err})
+		return err
+	}
+	app.templates.Store(&templateSet{pages: pages})
+	return nil
+}
+
+// liveReload notifies the browsers listening on the reload stream.
+type liveReload struct {
+	mu   sync.Mutex
+	subs map[chan struct{}]struct{}
+}
+
+func newLiveReload() *liveReload {
+	return &liveReload{subs: make(map[chan struct{}]struct{})}
+}
+
+// subscribe returns a channel receiving the reload notifications and the
+// function ending the subscription.
+func (lr *liveReload) subscribe() (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+	lr.mu.Lock()
+	lr.subs[ch] = struct{}{}
+	lr.mu.Unlock()
+
+	return ch, func() {
+		lr.mu.Lock()
+		delete(lr.subs, ch)
+		lr.mu.Unlock()
+	}
+}
+
+// broadcast notifies all subscribers. A subscriber with a notification
+// pending does not need a second one.
+func (lr *liveReload) broadcast() {
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+	for ch := range lr.subs {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// watchUI reparses the templates when the files below html change, hashes
+// the static assets again when they change and tells the browsers to reload
+// after every change. It returns when ctx is done.
+func (app *application) watchUI(ctx context.Context, ui, html, static fs.FS, interval time.Duration) error {
+	return watch.Poll(ctx, ui, interval, []string{"html", "static"}, func(paths []string) {
+		app.log.Info("ui changed", "files", paths)
+		var templates, assets bool
+		for _, p := range paths {
+			templates = templates || strings.HasPrefix(p, "html/")
+			assets = assets || strings.HasPrefix(p, "static/")
+		}
+		if assets {
+			if err := app.loadStatic(static); err != nil {
+				app.log.Error("loading static assets", "error", err)
+			}
+		}
+		// the pages link the fingerprints of the assets
+		if templates || assets {
+			if err := app.loadTemplates(html); err != nil {
+				app.log.Error("parsing templates", "error", err)
+			}
+		}
+		app.reload.broadcast()
+	})
+}
+
+// reloadEvents streams a reload event whenever the ui changed.
+func (app *application) reloadEvents(w http.ResponseWriter, r *http.Request) {
+	// the stream stays open longer than the write timeout of the server
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	rc.Flush()
+
+	ch, unsubscribe := app.reload.subscribe()
+	defer unsubscribe()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ch:
+			fmt.Fprint(w, "event: reload\ndata: {}\n\n")
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// templateErrorPage shows a template that failed to parse. It reloads like
+// every other page once the template is fixed.
+var templateErrorPage = template.Must(template.New("error").Parse(`<!doctype html>
+<html data-livereload='{{.ReloadPath}}'>
+    <head><meta charset='utf-8'><title>Template error - search</title></head>
+    <body>
+        <h1>Template error</h1>
+        <pre>{{.Err}}</pre>
+        <script src="/static/js/main.js" type="text/javascript" nonce="{{.CSPNonce}}"></script>
+    </body>
+</html>
+`))
+
+// renderTemplateError writes the page showing a template parse error.
+func (app *application) renderTemplateError(w http.ResponseWriter, r *http.Request, err error) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusInternalServerError)
+	templateErrorPage.Execute(w, struct {
+		Err        error
+		ReloadPath string
+		CSPNonce   string
+	}{err, reloadPath, cspNonce(r)})
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tullo/search/ui"
+)
+
+func TestLiveReload(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.CopyFS(dir, ui.Files); err != nil {
+		t.Fatal(err)
+	}
+	_, html, static, err := uiDirs(os.DirFS(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := newTestApplication(t)
+	if err := app.loadStatic(static); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.loadTemplates(html); err != nil {
+		t.Fatal(err)
+	}
+	app.reload = newLiveReload()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go app.watchUI(ctx, os.DirFS(dir), html, static, 5*time.Millisecond)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	code, _, body := ts.get(t, "/about")
+	if code != http.StatusOK {
+		t.Fatalf("want %d; got %d", http.StatusOK, code)
+	}
+	if !bytes.Contains(body, []byte("data-livereload='"+reloadPath+"'")) {
+		t.Error("want the page to listen for reloads")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+reloadPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("want an event stream; got %q", ct)
+	}
+	events := bufio.NewScanner(resp.Body)
+	waitReload := func() {
+		t.Helper()
+		got := make(chan bool)
+		go func() {
+			for events.Scan() {
+				if events.Text() == "event: reload" {
+					got <- true
+					return
+				}
+			}
+			got <- false
+		}()
+		select {
+		case ok := <-got:
+			if !ok {
+				t.Fatal("event stream closed")
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("no reload event")
+		}
+	}
+
+	page := filepath.Join(dir, "html", "about.page.tmpl")
+	orig, err := os.ReadFile(page)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a broken template is shown in the browser, the server keeps running
+	if err := os.WriteFile(page, append(orig, "{{end"...), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	waitReload()
+	code, _, body = ts.get(t, "/about")
+	if code != http.StatusInternalServerError || !bytes.Contains(body, []byte("<h1>Template error</h1>")) {
+		t.Errorf("want the template error page; got %d", code)
+	}
+	if !bytes.Contains(body, []byte("about.page.tmpl")) {
+		t.Errorf("want the error to name the template; got %s", body)
+	}
+
+	// fixing it brings the pages back
+	fixed := strings.Replace(string(orig), `{{define "main"}}`, `{{define "main"}}<p>edited</p>`, 1)
+	if err := os.WriteFile(page, []byte(fixed), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	waitReload()
+	code, _, body = ts.get(t, "/about")
+	if code != http.StatusOK || !bytes.Contains(body, []byte("<p>edited</p>")) {
+		t.Errorf("want the edited page; got %d", code)
+	}
+
+	// static assets only reload the browser
+	if err := os.WriteFile(filepath.Join(dir, "static", "css", "main.css"), []byte("body {}"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	waitReload()
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/tullo/search/internal/cache"
+	"github.com/tullo/search/internal/flight"
+)
+
+// responseCache keeps the bodies of sales-api reads. A response is fresh
+// for ttl. After that it is served for staleWhileRevalidate while a single
+// background request refreshes it, and for staleIfError in place of a
+// failed request, so that browsing keeps working during short outages.
+type responseCache struct {
+	ttl                  time.Duration
+	staleWhileRevalidate time.Duration
+	staleIfError         time.Duration
+	entries              *cache.LRU[string, *cachedResponse]
+
+	mu         sync.Mutex
+	refreshing map[string]bool
+
+	hits          atomic.Int64 // served fresh
+	staleHits     atomic.Int64 // served stale while revalidating
+	staleErrors   atomic.Int64 // served stale in place of an error
+	misses        atomic.Int64
+	invalidations atomic.Int64 // entries dropped after writes
+}
+
+type cachedResponse struct {
+	body   []byte
+	stored time.Time
+}
+
+// cacheStats is a snapshot of the responseCache counters.
+type cacheStats struct {
+	Entries       int   `json:"entries"`
+	Hits          int64 `json:"hits"`
+	StaleHits     int64 `json:"stale_hits"`
+	StaleErrors   int64 `json:"stale_errors"`
+	Misses        int64 `json:"misses"`
+	Invalidations int64 `json:"invalidations"`
+}
+
+// salesStats are the statistics of the sales-api client.
+type salesStats struct {
+	Cache   cacheStats   `json:"cache"`
+	Flights flight.Stats `json:"flights"`
+}
+
+// newResponseCache returns a cache of at most size responses, a size of 0
+// disables caching.
+func newResponseCache(size int, ttl, staleWhileRevalidate, staleIfError time.Duration) *responseCache {
+	return &responseCache{
+		ttl:                  ttl,
+		staleWhileRevalidate: staleWhileRevalidate,
+		staleIfError:         staleIfError,
+		entries:              cache.New[string, *cachedResponse](size, ttl+max(staleWhileRevalidate, staleIfError)),
+		refreshing:           make(map[string]bool),
+	}
+}
+
+// responseKey keys a response by the scope of the token reading it, so that
+// responses are only shared between users allowed to see the same data.
+func responseKey(scope, url string) string {
+	return scope + " " + url
+}
+
+// get returns the cached body of key or calls fetch for it. A nil cache
+// always calls fetch.
+func (c *responseCache) get(ctx context.Context, key string, fetch func(context.Context) ([]byte, error)) ([]byte, error) {
+	if c == nil {
+		return fetch(ctx)
+	}
+
+	now := c.entries.Now()
+	e, cached := c.entries.Get(key)
+	var age time.Duration
+	if cached {
+		age = now.Sub(e.stored)
+		switch {
+		case age < c.ttl:
+			c.hits.Add(1)
+			return e.body, nil
+		case age < c.ttl+c.staleWhileRevalidate:
+			c.staleHits.Add(1)
+			c.revalidate(ctx, key, fetch)
+			return e.body, nil
+		}
+	}
+
+	body, err := fetch(ctx)
+	if err != nil {
+		// responses to the request itself, e.g. a deleted product, are
+		// not papered over, only failures of the sales-api are
+		if s := upstreamStatus(err); cached && age < c.ttl+c.staleIfError && (s == 0 || s >= 500) {
+			c.staleErrors.Add(1)
+			return e.body, nil
+		}
+		c.misses.Add(1)
+		return nil, err
+	}
+	c.misses.Add(1)
+	c.entries.Add(key, &cachedResponse{body: body, stored: now})
+
+	return body, nil
+}
+
+// revalidate refreshes key in the background unless a refresh is running
+// already. The refresh outlives the request that triggered it.
+func (c *responseCache) revalidate(ctx context.Context, key string, fetch func(context.Context) ([]byte, error)) {
+	c.mu.Lock()
+	if c.refreshing[key] {
+		c.mu.Unlock()
+		return
+	}
+	c.refreshing[key] = true
+	c.mu.Unlock()
+
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		defer func() {
+			c.mu.Lock()
+			delete(c.refreshing, key)
+			c.mu.Unlock()
+		}()
+
+		now := c.entries.Now()
+		if body, err := fetch(ctx); err == nil {
+			c.entries.Add(key, &cachedResponse{body: body, stored: now})
+		}
+	}()
+}
+
+// invalidate drops the responses of scopes whose URL starts with prefix.
+func (c *responseCache) invalidate(scopes []string, prefix string) {
+	if c == nil {
+		return
+	}
+	n := c.entries.RemoveFunc(func(key string) bool {
+		scope, url, _ := strings.Cut(key, " ")
+		return slices.Contains(scopes, scope) && strings.HasPrefix(url, prefix)
+	})
+	c.invalidations.Add(int64(n))
+}
+
+func (c *responseCache) stats() cacheStats {
+	if c == nil {
+		return cacheStats{}
+	}
+	return cacheStats{
+		Entries:       c.entries.Len(),
+		Hits:          c.hits.Load(),
+		StaleHits:     c.staleHits.Load(),
+		StaleErrors:   c.staleErrors.Load(),
+		Misses:        c.misses.Load(),
+		Invalidations: c.invalidations.Load(),
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestResponseCache(t *testing.T) {
+	now := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	c := newResponseCache(10, time.Minute, time.Minute, 5*time.Minute)
+	c.entries.Now = func() time.Time { return now }
+
+	var (
+		calls    atomic.Int32
+		body     = "v1"
+		fail     error
+		refreshc = make(chan struct{}, 1)
+	)
+	fetch := func(context.Context) ([]byte, error) {
+		calls.Add(1)
+		defer func() {
+			select {
+			case refreshc <- struct{}{}:
+			default:
+			}
+		}()
+		if fail != nil {
+			return nil, fail
+		}
+		return []byte(body), nil
+	}
+	get := func(want string) {
+		t.Helper()
+		b, err := c.get(context.Background(), "k", fetch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != want {
+			t.Errorf("want %q; got %q", want, b)
+		}
+	}
+
+	get("v1")
+	<-refreshc
+	body = "v2"
+
+	// fresh
+	now = now.Add(30 * time.Second)
+	get("v1")
+
+	// stale while revalidating in the background
+	now = now.Add(time.Minute)
+	get("v1")
+	<-refreshc
+	get("v2")
+	if n := calls.Load(); n != 2 {
+		t.Errorf("want 2 fetches; got %d", n)
+	}
+
+	// stale in place of errors of the sales-api
+	now = now.Add(3 * time.Minute)
+	fail = errors.New("connection refused")
+	get("v2")
+	<-refreshc
+	fail = &statusError{code: http.StatusBadGateway}
+	get("v2")
+	<-refreshc
+
+	// but not in place of answers to the request
+	fail = &statusError{code: http.StatusNotFound}
+	if _, err := c.get(context.Background(), "k", fetch); upstreamStatus(err) != http.StatusNotFound {
+		t.Errorf("want the not found error; got %v", err)
+	}
+	<-refreshc
+
+	// and not beyond the stale-if-error window
+	now = now.Add(5 * time.Minute)
+	fail = errors.New("connection refused")
+	if _, err := c.get(context.Background(), "k", fetch); err == nil {
+		t.Error("want the error once the response is too old")
+	}
+	<-refreshc
+
+	s := c.stats()
+	want := cacheStats{Entries: 0, Hits: 2, StaleHits: 1, StaleErrors: 2, Misses: 3}
+	if s != want {
+		t.Errorf("want %+v; got %+v", want, s)
+	}
+}
+
+func TestResponseCacheInvalidation(t *testing.T) {
+	var reads atomic.Int32
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			reads.Add(1)
+			w.Write([]byte(`{"id":"1"}`))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer api.Close()
+
+	app := &application{
+		salesURL:  api.URL + "/v1",
+		responses: newResponseCache(10, time.Minute, time.Minute, time.Minute),
+	}
+	ctx := context.Background()
+
+	read := func(token, path string) {
+		t.Helper()
+		var v struct{ ID string }
+		if err := app.getJSON(ctx, token, app.salesURL+path, &v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writer, other, admin := testToken("user-1"), testToken("user-2"), testToken("user-3", roleAdmin)
+	read(writer, "/products/1")
+	read(writer, "/products/1/20")
+	read(other, "/products/1")
+	read(admin, "/products/1")
+	read(writer, "/users/1")
+	read(writer, "/products/1")
+	if n := reads.Load(); n != 5 {
+		t.Fatalf("want 5 reads; got %d", n)
+	}
+
+	if err := app.sendJSON(ctx, writer, http.MethodPut, app.salesURL+"/products/1", map[string]int{"sold": 1}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if s := app.responses.stats(); s.Invalidations != 3 || s.Entries != 2 {
+		t.Errorf("want the product reads of the writer and the admins invalidated; got %+v", s)
+	}
+
+	// the other user does not see the product of the writer
+	read(writer, "/users/1")
+	read(other, "/products/1")
+	read(writer, "/products/1")
+	read(admin, "/products/1")
+	if n := reads.Load(); n != 7 {
+		t.Errorf("want 7 reads; got %d", n)
+	}
+}
+
+func TestSalesStats(t *testing.T) {
+	app := newTestApplication(t)
+	app.salesURL = newSalesAPI(t).URL
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.login(t)
+	for range 2 {
+		if code, _, _ := ts.get(t, "/"); code != http.StatusOK {
+			t.Fatalf("want %d; got %d", http.StatusOK, code)
+		}
+	}
+
+	code, _, body := ts.get(t, "/admin/stats")
+	if code != http.StatusOK {
+		t.Fatalf("want %d; got %d", http.StatusOK, code)
+	}
+	var env struct{ Data salesStats }
+	if err := json.Unmarshal(body, &env); err != nil {
+		t.Fatal(err)
+	}
+	if c := env.Data.Cache; c.Hits != 1 || c.Misses != 1 || c.Entries != 1 {
+		t.Errorf("want one miss and one hit; got %+v", c)
+	}
+	if f := env.Data.Flights; f.Calls != 1 {
+		t.Errorf("want one sales-api call; got %+v", f)
+	}
+}
+
+func TestCoalescedReads(t *testing.T) {
+	api := newSalesAPI(t)
+	var reads atomic.Int32
+	release := make(chan struct{})
+	next := api.Config.Handler
+	api.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/products/"+testProducts[0].ID {
+			reads.Add(1)
+			<-release
+		}
+		next.ServeHTTP(w, r)
+	})
+
+	app := newTestApplication(t)
+	app.salesURL = api.URL
+	app.responses = nil
+	token, err := app.salesToken(context.Background(), testUser.Email, "gophers")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const n = 5
+	errs := make(chan error, n)
+	ctx, cancel := context.WithCancel(context.Background())
+	for i := range n {
+		// the first visitor gives up, the others still get the product
+		ctx := ctx
+		if i > 0 {
+			ctx = context.Background()
+		}
+		go func() {
+			p, err := app.fetchProduct(ctx, token, testProducts[0].ID)
+			if err == nil && p.Name != testProducts[0].Name {
+				err = fmt.Errorf("want %s; got %s", testProducts[0].Name, p.Name)
+			}
+			errs <- err
+		}()
+	}
+	for app.flights.Stats().Saved < n-1 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	if err := <-errs; !errors.Is(err, context.Canceled) {
+		t.Errorf("want %v; got %v", context.Canceled, err)
+	}
+	close(release)
+	for range n - 1 {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+
+	if r := reads.Load(); r != 1 {
+		t.Errorf("want 1 sales-api read; got %d", r)
+	}
+	if s := app.flights.Stats(); s.Calls != 1 || s.Saved != n-1 {
+		t.Errorf("want %d saved calls; got %+v", n-1, s)
+	}
+}
@@ -10,25 +10,37 @@ import (
 func (app *application) routes() http.Handler {
 
 	// 'standard' middleware used for every request
-	standardMiddleware := alice.New(app.recoverPanic, app.logRequest, secureHeaders)
+	standardMiddleware := alice.New(app.requestID, app.resolveClient, app.traceRequest, app.logRequest, app.compress, app.recoverPanic, app.secureHeaders)
 
 	// middleware specific to our dynamic application routes
-	dynamicMiddleware := alice.New(app.session.Enable, noSurf, app.authenticate)
+	dynamicMiddleware := alice.New(app.negotiate, app.session.Enable, noSurf, app.authenticate, app.localize)
 
 	mux := pat.New()
 	mux.Get("/", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.home))
 	mux.Get("/about", dynamicMiddleware.ThenFunc(app.about))
+	mux.Get("/admin/stats", dynamicMiddleware.Append(app.requireAuthentication, app.requireAdmin).ThenFunc(app.salesStats))
+	mux.Get("/admin/quality", dynamicMiddleware.Append(app.requireAuthentication, app.requireAdmin).ThenFunc(app.qualityReport))
+	mux.Get("/dashboard", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.dashboard))
+	mux.Get("/events", streaming(dynamicMiddleware.Append(app.requireAuthentication), app.productEvents))
+	mux.Get("/search/suggest", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.suggestions))
+	mux.Get("/products/export", streaming(dynamicMiddleware.Append(app.requireAuthentication), app.exportProducts))
 	mux.Get("/product/:id", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.showProduct))
 
 	mux.Get("/user/login", dynamicMiddleware.ThenFunc(app.loginUserForm))
 	mux.Post("/user/login", dynamicMiddleware.ThenFunc(app.loginUser))
 	mux.Post("/user/logout", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.logoutUser))
 	mux.Get("/user/profile", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.userProfile))
+	mux.Post("/user/locale", dynamicMiddleware.ThenFunc(app.setLocale))
+	mux.Post("/user/timezone", dynamicMiddleware.ThenFunc(app.setTimezone))
+
+	if app.reload != nil {
+		mux.Get(reloadPath, http.HandlerFunc(app.reloadEvents))
+	}
 
 	mux.Get("/ping", http.HandlerFunc(app.ping))
+	mux.Post(cspReportPath, http.HandlerFunc(app.cspReport))
 
-	fileServer := http.FileServer(http.Dir("./ui/static/"))
-	mux.Get("/static/", http.StripPrefix("/static", fileServer))
+	mux.Get("/static/", http.HandlerFunc(app.serveStatic))
 
 	// standardMiddleware ↔ servemux ↔ dynamicMiddleware ↔ app handler
 	return standardMiddleware.Then(mux)
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go/v4"
+	"github.com/tullo/search/internal/product"
+	"github.com/tullo/search/internal/user"
+)
+
+// salesClaims are the claims of a sales-api token.
+type salesClaims struct {
+	jwt.StandardClaims
+	Roles []string `json:"roles"`
+}
+
+// statusError reports an unexpected response status of the sales-api.
+type statusError struct {
+	url  string
+	code int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("sales-api %s: unexpected status %d", e.url, e.code)
+}
+
+// upstreamStatus returns the status of a sales-api call that failed with an
+// unexpected response, 0 if the call did not get a response at all.
+func upstreamStatus(err error) int {
+	var se *statusError
+	if errors.As(err, &se) {
+		return se.code
+	}
+	return 0
+}
+
+// listingQuery reads the search and sort query parameters of a listing.
+func (app *application) listingQuery(v url.Values) (product.Query, error) {
+	return product.NewQuery(v.Get("q"), v.Get("sort"), app.fuzziness)
+}
+
+// newSalesRequest builds a request to the sales-api authorized by the
+// token. The request ID found in ctx is forwarded so that the sales-api
+// logs can be correlated.
+func newSalesRequest(ctx context.Context, method, token, url string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	if id := requestIDFromContext(ctx); id != "" {
+		req.Header.Set(requestIDHeader, id)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	}
+	return req, nil
+}
+
+// getJSON decodes the response of a sales-api GET into v. Responses are
+// cached per token scope, and concurrent identical GETs of a scope share a
+// single request.
+func (app *application) getJSON(ctx context.Context, token, url string, v interface{}) error {
+	key := responseKey(tokenScope(token), url)
+	body, err := app.responses.get(ctx, key, func(ctx context.Context) ([]byte, error) {
+		return app.flights.Do(ctx, key, func(ctx context.Context) ([]byte, error) {
+			return app.fetchBody(ctx, token, url)
+		})
+	})
+	if err != nil {
+		return err
+	}
+	return decodeBody(url, body, v)
+}
+
+// fetchJSON decodes the response of a sales-api GET into v, read straight
+// from the sales-api past the response cache.
+func (app *application) fetchJSON(ctx context.Context, token, url string, v interface{}) error {
+	body, err := app.fetchBody(ctx, token, url)
+	if err != nil {
+		return err
+	}
+	return decodeBody(url, body, v)
+}
+
+func decodeBody(url string, body []byte, v interface{}) error {
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("decoding %s: %w", url, err)
+	}
+	return nil
+}
+
+// fetchBody reads the body of a successful sales-api GET.
+func (app *application) fetchBody(ctx context.Context, token, url string) ([]byte, error) {
+	// Create a context with a timeout of 1 second.
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+
+	req, err := newSalesRequest(ctx, http.MethodGet, token, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Client.Do will handle the context level timeout.
+	client := newClient()
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &statusError{url: url, code: resp.StatusCode}
+	}
+
+	return io.ReadAll(resp.Body)
+}
+
+// sendJSON sends in as the body of a sales-api write and decodes the
+// response into out, if given. The cached reads of the written resource
+// are dropped in the scopes seeing the write, see writeScopes, so every
+// write must go through here.
+func (app *application) sendJSON(ctx context.Context, token, method, url string, in, out interface{}) error {
+	b, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+
+	req, err := newSalesRequest(ctx, method, token, url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := newClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// the write may have gone through even if the response says otherwise
+	app.responses.invalidate(writeScopes(token), app.resourceURL(url))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &statusError{url: url, code: resp.StatusCode}
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decoding %s: %w", url, err)
+	}
+	return nil
+}
+
+// writeScopes returns the scopes seeing a write with token: its own and
+// that of the admins, who see the data of everyone. The other users only
+// see their own data and keep their cached reads.
+func writeScopes(token string) []string {
+	scope := tokenScope(token)
+	if scope == "role:"+roleAdmin {
+		return []string{scope}
+	}
+	return []string{scope, "role:" + roleAdmin}
+}
+
+// resourceURL returns the URL of the collection url belongs to, e.g. the
+// products for a product. Writes to an item change the listings as well.
+func (app *application) resourceURL(url string) string {
+	path, ok := strings.CutPrefix(url, app.salesURL+"/")
+	if !ok {
+		return url
+	}
+	collection, _, _ := strings.Cut(path, "/")
+	return app.salesURL + "/" + collection
+}
+
+// tokenScope returns what a sales-api token may see: admins share a scope,
+// everyone else only sees their own data.
+func tokenScope(token string) string {
+	if token == "" {
+		return "anonymous"
+	}
+	var claims salesClaims
+	parser := jwt.NewParser(jwt.WithValidMethods([]string{"RS256"}))
+	if _, _, err := parser.ParseUnverified(token, &claims); err == nil {
+		if slices.Contains(claims.Roles, roleAdmin) {
+			return "role:" + roleAdmin
+		}
+		if claims.Subject != "" {
+			return "user:" + claims.Subject
+		}
+	}
+	sum := sha256.Sum256([]byte(token))
+	return "token:" + hex.EncodeToString(sum[:8])
+}
+
+// fetchProduct reads a product from the sales-api.
+func (app *application) fetchProduct(ctx context.Context, token, id string) (product.Product, error) {
+	var p product.Product
+	err := app.getJSON(ctx, token, fmt.Sprintf("%s/products/%s", app.salesURL, url.PathEscape(id)), &p)
+	return p, err
+}
+
+// fetchProducts reads one page of the product listing from the sales-api.
+func (app *application) fetchProducts(ctx context.Context, token string, page, rows int) ([]product.Product, error) {
+	var products []product.Product
+	err := app.getJSON(ctx, token, app.productsURL(page, rows), &products)
+	return products, err
+}
+
+// productsURL returns the URL of a page of the product listing.
+func (app *application) productsURL(page, rows int) string {
+	return fmt.Sprintf("%s/products/%d/%d", app.salesURL, page, rows)
+}
+
+// fetchUser reads a user from the sales-api.
+func (app *application) fetchUser(ctx context.Context, token, id string) (user.User, error) {
+	var u user.User
+	err := app.getJSON(ctx, token, fmt.Sprintf("%s/users/%s", app.salesURL, url.PathEscape(id)), &u)
+	return u, err
+}
+
+// salesToken requests a token for the credentials of a user.
+func (app *application) salesToken(ctx context.Context, email, password string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+
+	url := fmt.Sprintf("%s/users/token/%s", app.salesURL, app.keyID)
+	req, err := newSalesRequest(ctx, http.MethodGet, "", url, nil)
+	if err != nil {
+		return "", err
+	}
+	req.SetBasicAuth(email, password)
+
+	resp, err := newClient().Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", &statusError{url: url, code: resp.StatusCode}
+	}
+
+	var tkn struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tkn); err != nil {
+		return "", fmt.Errorf("decoding %s: %w", url, err)
+	}
+	return tkn.Token, nil
+}
+
+// pageReads tells eachProduct where to read the pages of the listing.
+type pageReads int
+
+const (
+	// cachedPages go through the response cache, for the pages shown.
+	cachedPages pageReads = iota
+	// uncachedPages are read straight from the sales-api, so that a walk
+	// of the whole catalogue, such as an export, does not keep it in the
+	// response cache.
+	uncachedPages
+)
+
+// eachProduct calls fn for every product of the sales-api listing, reading
+// it page by page. Every page gets its own timeout, so walking a large
+// catalogue does not fail on the timeout of a single request.
+func (app *application) eachProduct(ctx context.Context, token string, reads pageReads, fn func(product.Product) error) error {
+	get := app.getJSON
+	if reads == uncachedPages {
+		get = app.fetchJSON
+	}
+	for page := 1; ; page++ {
+		var products []product.Product
+		if err := get(ctx, token, app.productsURL(page, maxPerPage), &products); err != nil {
+			return err
+		}
+		for _, p := range products {
+			if err := fn(p); err != nil {
+				return err
+			}
+		}
+		if len(products) < maxPerPage {
+			return nil
+		}
+	}
+}
+
+// readCatalogue reads the whole product listing for work outside of a
+// request, such as watching it for changes or indexing the names. The
+// listing depends on the token, so what is made of it only serves the
+// users of the same tokenScope.
+func (app *application) readCatalogue(ctx context.Context, token string) ([]product.Product, error) {
+	var products []product.Product
+	err := app.eachProduct(ctx, token, cachedPages, func(p product.Product) error {
+		products = append(products, p)
+		return nil
+	})
+	return products, err
+}
+
+// queryProducts returns all products passing the filter of the query, in
+// the order of the query, and the whole listing they were picked from.
+func (app *application) queryProducts(ctx context.Context, token string, q product.Query) (matched, all []product.Product, err error) {
+	err = app.eachProduct(ctx, token, cachedPages, func(p product.Product) error {
+		all = append(all, p)
+		if q.Match(&p) {
+			matched = append(matched, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	q.Sort(matched)
+	return matched, all, nil
+}
+
+// didYouMean returns the names of the products of the listing most similar
+// to the search of a query that matched none of them.
+func (app *application) didYouMean(q product.Query, listing []product.Product) []string {
+	if q.Search == "" || app.alternatives <= 0 {
+		return nil
+	}
+	// the best selling ones first among the equally similar
+	products := slices.Clone(listing)
+	slices.SortStableFunc(products, func(a, b product.Product) int { return cmp.Compare(b.Sold, a.Sold) })
+	return q.DidYouMean(products, app.alternatives)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// cspReportPath is where browsers send Content-Security-Policy violations.
+const cspReportPath = "/csp-report"
+
+const contextKeyCSPNonce = contextKey("cspNonce")
+
+// securityPolicy holds the security headers sent with every response.
+type securityPolicy struct {
+	csp               []string // policy directives, the script nonce is added per request
+	cspReportOnly     bool
+	hstsMaxAge        time.Duration
+	referrerPolicy    string
+	permissionsPolicy []string
+	openerPolicy      string
+}
+
+// contentSecurityPolicy renders the policy for a request with the given nonce.
+func (sp *securityPolicy) contentSecurityPolicy(nonce string) string {
+	directives := make([]string, 0, len(sp.csp)+2)
+	var hasScriptSrc bool
+	for _, d := range sp.csp {
+		d = strings.TrimSpace(d)
+		if d == "" {
+			continue
+		}
+		if name, _, _ := strings.Cut(d, " "); name == "script-src" {
+			d += " 'nonce-" + nonce + "'"
+			hasScriptSrc = true
+		}
+		directives = append(directives, d)
+	}
+	if !hasScriptSrc {
+		directives = append(directives, "script-src 'self' 'nonce-"+nonce+"'")
+	}
+	directives = append(directives, "report-uri "+cspReportPath)
+
+	return strings.Join(directives, "; ")
+}
+
+// secureHeaders sets the security headers and makes a fresh CSP nonce
+// available to the templates.
+func (app *application) secureHeaders(next http.Handler) http.Handler {
+	sp := app.security
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nonce := newCSPNonce()
+
+		h := w.Header()
+		if sp.cspReportOnly {
+			h.Set("Content-Security-Policy-Report-Only", sp.contentSecurityPolicy(nonce))
+		} else {
+			h.Set("Content-Security-Policy", sp.contentSecurityPolicy(nonce))
+		}
+		if sp.hstsMaxAge > 0 && remoteClient(r).Scheme == "https" {
+			h.Set("Strict-Transport-Security", fmt.Sprintf("max-age=%d; includeSubDomains", int(sp.hstsMaxAge.Seconds())))
+		}
+		if sp.referrerPolicy != "" {
+			h.Set("Referrer-Policy", sp.referrerPolicy)
+		}
+		if len(sp.permissionsPolicy) > 0 {
+			h.Set("Permissions-Policy", strings.Join(sp.permissionsPolicy, ", "))
+		}
+		if sp.openerPolicy != "" {
+			h.Set("Cross-Origin-Opener-Policy", sp.openerPolicy)
+		}
+		h.Set("X-Content-Type-Options", "nosniff")
+		h.Set("X-Frame-Options", "deny")
+		// the XSS auditor is gone from current browsers and could be abused
+		// in the old ones, the CSP is what protects against XSS now
+		h.Set("X-XSS-Protection", "0")
+
+		ctx := context.WithValue(r.Context(), contextKeyCSPNonce, nonce)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// cspNonce returns the nonce of the CSP sent with the response to r.
+func cspNonce(r *http.Request) string {
+	nonce, _ := r.Context().Value(contextKeyCSPNonce).(string)
+	return nonce
+}
+
+func newCSPNonce() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return base64.StdEncoding.EncodeToString(b[:])
+}
+
+// cspReport logs the violation reports sent by browsers.
+func (app *application) cspReport(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 64<<10))
+	if err != nil {
+		app.clientError(w, r, http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	// the legacy report-uri format wraps the report in a "csp-report" object
+	var report struct {
+		Report json.RawMessage `json:"csp-report"`
+	}
+	if err := json.Unmarshal(body, &report); err != nil || len(report.Report) == 0 {
+		// the Reporting API sends a list of reports
+		report.Report = body
+	}
+	if !json.Valid(report.Report) {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	app.logger(r).WarnContext(r.Context(), "csp violation",
+		"report", json.RawMessage(report.Report),
+		"user_agent", r.UserAgent(),
+	)
+	w.WriteHeader(http.StatusNoContent)
+}
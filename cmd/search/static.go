@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// fingerprintLen is the number of hex digits of the content hash added to
+// the names of static assets.
+const fingerprintLen = 8
+
+// immutable is the Cache-Control of fingerprinted assets: their URL changes
+// with their content, so they can be cached for good.
+const immutable = "public, max-age=31536000, immutable"
+
+// compressible lists the extensions of the static assets worth gzipping,
+// images and fonts are compressed already.
+var compressible = map[string]bool{
+	".css": true, ".js": true, ".svg": true, ".html": true, ".json": true, ".txt": true, ".ico": true,
+}
+
+// staticAsset is a static file held in memory together with its gzipped
+// variant, if that is smaller.
+type staticAsset struct {
+	name        string
+	contentType string
+	modTime     time.Time
+	hash        string // hex sha256 of the content
+	data        []byte
+	gz          []byte
+}
+
+// staticAssets are the static files, hashed and compressed once at startup.
+type staticAssets struct {
+	files map[string]*staticAsset
+}
+
+// newStaticAssets reads every file of fsys.
+func newStaticAssets(fsys fs.FS) (*staticAssets, error) {
+	s := staticAssets{files: make(map[string]*staticAsset)}
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		sum := sha256.Sum256(data)
+		a := staticAsset{
+			name:        name,
+			contentType: mime.TypeByExtension(path.Ext(name)),
+			modTime:     info.ModTime(),
+			hash:        hex.EncodeToString(sum[:]),
+			data:        data,
+		}
+		if a.contentType == "" {
+			a.contentType = http.DetectContentType(data)
+		}
+		if compressible[path.Ext(name)] {
+			var buf bytes.Buffer
+			zw, _ := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+			zw.Write(data)
+			if err := zw.Close(); err != nil {
+				return err
+			}
+			if buf.Len() < len(data) {
+				a.gz = buf.Bytes()
+			}
+		}
+		s.files[name] = &a
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// url returns the fingerprinted URL of the asset, e.g.
+// /static/css/main.0a1b2c3d.css. Unknown assets keep their plain URL.
+func (s *staticAssets) url(name string) string {
+	a, ok := s.files[name]
+	if !ok {
+		return "/static/" + name
+	}
+	ext := path.Ext(name)
+	return "/static/" + strings.TrimSuffix(name, ext) + "." + a.hash[:fingerprintLen] + ext
+}
+
+// lookup finds the asset of a path below /static/. Fingerprinted paths
+// matching the current content are reported as immutable. An outdated
+// fingerprint gets the current content, which must not be cached for good.
+func (s *staticAssets) lookup(name string) (a *staticAsset, fingerprinted bool) {
+	if a, ok := s.files[name]; ok {
+		return a, false
+	}
+
+	ext := path.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+	i := strings.LastIndexByte(stem, '.')
+	if i < 0 || len(stem)-i-1 != fingerprintLen {
+		return nil, false
+	}
+	a, ok := s.files[stem[:i]+ext]
+	if !ok {
+		return nil, false
+	}
+	return a, stem[i+1:] == a.hash[:fingerprintLen]
+}
+
+// loadStatic hashes and compresses the static assets of fsys and swaps
+// them in.
+func (app *application) loadStatic(fsys fs.FS) error {
+	s, err := newStaticAssets(fsys)
+	if err != nil {
+		return err
+	}
+	app.static.Store(s)
+	return nil
+}
+
+// staticURL is the static template function, it returns the fingerprinted
+// URL of a static asset.
+func (app *application) staticURL(name string) string {
+	s := app.static.Load()
+	if s == nil {
+		return "/static/" + name
+	}
+	return s.url(name)
+}
+
+// serveStatic serves the static assets from memory. Directories are not
+// listed, only files are found.
+func (app *application) serveStatic(w http.ResponseWriter, r *http.Request) {
+	a, fingerprinted := app.static.Load().lookup(strings.TrimPrefix(r.URL.Path, "/static/"))
+	if a == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	h := w.Header()
+	if fingerprinted {
+		h.Set("Cache-Control", immutable)
+	} else {
+		// plain URLs may change content any time, revalidate with the ETag
+		h.Set("Cache-Control", "no-cache")
+	}
+	h.Set("Content-Type", a.contentType)
+
+	content, etag := a.data, a.hash[:16]
+	if a.gz != nil {
+		h.Add("Vary", "Accept-Encoding")
+		if acceptsEncoding(r.Header.Get("Accept-Encoding"), "gzip") {
+			content, etag = a.gz, etag+"-gzip"
+			h.Set("Content-Encoding", "gzip")
+		}
+	}
+	h.Set("ETag", strconv.Quote(etag))
+
+	http.ServeContent(w, r, a.name, a.modTime, bytes.NewReader(content))
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/tullo/search/internal/cache"
+	"github.com/tullo/search/internal/product"
+	"github.com/tullo/search/internal/ratelimit"
+	"github.com/tullo/search/internal/sanitize"
+	"github.com/tullo/search/internal/suggest"
+)
+
+// maxSuggestions bounds the completions kept per prefix and asked for by
+// the n parameter.
+const maxSuggestions = 20
+
+// suggestIndex holds a trie of the product names per token scope, since
+// the sales-api lists every scope its own products. A request finding no
+// trie of its scope, or one older than maxAge, starts building a new one
+// in the background with the token of its user, requests never wait for it
+// and get no completions before the first trie is built. The tries of at
+// most scopes scopes are kept, one not used for twice maxAge is dropped.
+// Up to limit completions are returned unless fewer are asked for, limiter
+// bounds the requests of a session.
+type suggestIndex struct {
+	maxAge  time.Duration
+	limit   int
+	limiter *ratelimit.Counter
+	tries   *cache.LRU[string, *suggestTrie]
+
+	mu       sync.Mutex
+	building map[string]bool // scopes with a trie being built
+}
+
+func newSuggestIndex(scopes int, maxAge time.Duration, limit int, limiter *ratelimit.Counter) *suggestIndex {
+	return &suggestIndex{
+		maxAge:   maxAge,
+		limit:    limit,
+		limiter:  limiter,
+		tries:    cache.New[string, *suggestTrie](scopes, 2*maxAge),
+		building: make(map[string]bool),
+	}
+}
+
+// startBuilding reports whether the caller is to build the trie of scope,
+// done must be called once it is.
+func (idx *suggestIndex) startBuilding(scope string) bool {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if idx.building[scope] {
+		return false
+	}
+	idx.building[scope] = true
+	return true
+}
+
+func (idx *suggestIndex) done(scope string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	delete(idx.building, scope)
+}
+
+type suggestTrie struct {
+	*suggest.Trie
+	built time.Time
+}
+
+// suggestTrie returns the current trie of the scope of token, nil before
+// the first one is built, and starts building a new one with token if it
+// is missing or outdated.
+func (app *application) suggestTrie(ctx context.Context, token string) *suggestTrie {
+	idx := app.suggest
+	scope := tokenScope(token)
+	t, ok := idx.tries.Get(scope)
+	if (!ok || time.Since(t.built) > idx.maxAge) && idx.startBuilding(scope) {
+		go func() {
+			defer idx.done(scope)
+			if err := app.buildSuggestions(context.WithoutCancel(ctx), scope, token); err != nil {
+				app.log.Warn("building suggestions", "scope", scope, "error", err)
+			}
+		}()
+	}
+	return t
+}
+
+// buildSuggestions reads the listing of token and swaps in a new trie of
+// its scope.
+func (app *application) buildSuggestions(ctx context.Context, scope, token string) error {
+	products, err := app.readCatalogue(ctx, token)
+	if err != nil {
+		return err
+	}
+	app.updateSuggestions(scope, products)
+	return nil
+}
+
+// updateSuggestions swaps in a trie of the products listed to scope,
+// weighted by the number of items sold. The names are completed as the
+// listing shows them, without markup, so that a completion searches for
+// its product.
+func (app *application) updateSuggestions(scope string, products []product.Product) {
+	entries := make([]suggest.Entry, len(products))
+	for i, p := range products {
+		entries[i] = suggest.Entry{Name: sanitize.Inline.Text(p.Name), Weight: p.Sold}
+	}
+	app.suggest.tries.Add(scope, &suggestTrie{Trie: suggest.Build(entries, maxSuggestions), built: time.Now()})
+}
+
+// suggestSession returns the key the suggestions of the session are
+// limited by, and makes one up on the first request.
+func (app *application) suggestSession(r *http.Request) string {
+	id := app.session.GetString(r, "suggestID")
+	if id == "" {
+		var b [16]byte
+		rand.Read(b[:])
+		id = hex.EncodeToString(b[:])
+		app.session.Put(r, "suggestID", id)
+	}
+	return "session:" + id
+}
+
+// suggestion is a completion of the search box.
+type suggestion struct {
+	Name string `json:"name"`
+}
+
+// suggestions completes the product names starting with q, the best
+// selling ones first.
+func (app *application) suggestions(w http.ResponseWriter, r *http.Request) {
+	if wait, ok := app.suggest.limiter.Allow(app.suggestSession(r)); !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		writeJSONError(w, r, http.StatusTooManyRequests, "")
+		return
+	}
+
+	n := app.suggest.limit
+	if s := r.URL.Query().Get("n"); s != "" {
+		v, err := strconv.Atoi(s)
+		if err != nil || v < 1 || v > maxSuggestions {
+			writeJSONError(w, r, http.StatusBadRequest, "n must be between 1 and "+strconv.Itoa(maxSuggestions))
+			return
+		}
+		n = v
+	}
+
+	data := []suggestion{}
+	if t := app.suggestTrie(r.Context(), app.token(r)); t != nil {
+		for _, e := range t.Complete(r.URL.Query().Get("q"), n) {
+			data = append(data, suggestion{Name: e.Name})
+		}
+	}
+	writeJSON(w, r, http.StatusOK, envelope{Data: data})
+}
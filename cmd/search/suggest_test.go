@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/tullo/search/internal/product"
+	"github.com/tullo/search/internal/ratelimit"
+)
+
+func TestSuggestions(t *testing.T) {
+	marked := testProducts[0]
+	marked.ID = "3e1f6b2a-5c4d-4e8f-9a0b-1c2d3e4f5a6b"
+	marked.Name = "<em>Board</em> Games"
+	products := testProducts
+	testProducts = append(slices.Clip(testProducts), marked)
+	t.Cleanup(func() { testProducts = products })
+
+	api := newSalesAPI(t)
+	app := newTestApplication(t)
+	app.salesURL = api.URL
+	app.responses = nil
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	suggest := func(t *testing.T, path string) (int, http.Header, []string) {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Accept", "application/json")
+		code, header, body := ts.clientDo(t, req)
+		if code != http.StatusOK {
+			return code, header, nil
+		}
+		var env struct {
+			Data []suggestion `json:"data"`
+		}
+		if err := json.Unmarshal(body, &env); err != nil {
+			t.Fatalf("decoding %s: %v", body, err)
+		}
+		names := []string{}
+		for _, s := range env.Data {
+			names = append(names, s.Name)
+		}
+		return code, header, names
+	}
+
+	// only authenticated sessions are completed
+	if code, _, _ := suggest(t, "/search/suggest?q=co"); code != http.StatusUnauthorized {
+		t.Errorf("want %d; got %d", http.StatusUnauthorized, code)
+	}
+	ts.login(t)
+
+	// the first request starts reading the names and finds none yet
+	if _, _, names := suggest(t, "/search/suggest?q=co"); len(names) != 0 {
+		t.Errorf("want no suggestions before the names are read; got %q", names)
+	}
+	for {
+		if _, ok := app.suggest.tries.Get("role:ADMIN"); ok {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// the names listed to another scope are not completed
+	app.updateSuggestions("user:someone-else", []product.Product{{Name: "Corporate Secrets"}})
+
+	tests := []struct {
+		name     string
+		path     string
+		wantCode int
+		want     []string
+	}{
+		{"Prefix", "/search/suggest?q=co", http.StatusOK, []string{"Comic Books"}},
+		{"Case", "/search/suggest?q=MCD", http.StatusOK, []string{"McDonalds Toys"}},
+		{"Word", "/search/suggest?q=toy", http.StatusOK, []string{"McDonalds Toys"}},
+		{"Markup", "/search/suggest?q=board", http.StatusOK, []string{"Board Games"}},
+		{"Empty", "/search/suggest?q=", http.StatusOK, []string{}},
+		{"No match", "/search/suggest?q=xyz", http.StatusOK, []string{}},
+		{"Bad n", "/search/suggest?q=co&n=0", http.StatusBadRequest, nil},
+		{"Too many", "/search/suggest?q=co&n=21", http.StatusBadRequest, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, names := suggest(t, tt.path)
+			if code != tt.wantCode {
+				t.Fatalf("want %d; got %d", tt.wantCode, code)
+			}
+			if !slices.Equal(names, tt.want) {
+				t.Errorf("want %q; got %q", tt.want, names)
+			}
+		})
+	}
+
+	t.Run("Searches its product", func(t *testing.T) {
+		_, _, names := suggest(t, "/search/suggest?q=board")
+		if len(names) != 1 {
+			t.Fatalf("want one suggestion; got %q", names)
+		}
+		code, _, body := ts.get(t, "/?q="+url.QueryEscape(names[0]))
+		if code != http.StatusOK {
+			t.Fatalf("want %d; got %d", http.StatusOK, code)
+		}
+		if !bytes.Contains(body, []byte("/product/"+marked.ID)) {
+			t.Errorf("want the listing searched for %q to contain %s", names[0], marked.ID)
+		}
+	})
+
+	t.Run("Limited", func(t *testing.T) {
+		app.suggest.limiter = ratelimit.NewCounter(ratelimit.NewMemory(), 2, time.Minute)
+		for range 2 {
+			if code, _, _ := suggest(t, "/search/suggest?q=co"); code != http.StatusOK {
+				t.Fatalf("want %d; got %d", http.StatusOK, code)
+			}
+		}
+		code, header, _ := suggest(t, "/search/suggest?q=co")
+		if code != http.StatusTooManyRequests {
+			t.Fatalf("want %d; got %d", http.StatusTooManyRequests, code)
+		}
+		if header.Get("Retry-After") == "" {
+			t.Error("want a Retry-After header")
+		}
+	})
+}
@@ -1,35 +1,97 @@
 package main
 
 import (
+	"fmt"
 	"html/template"
-	"path/filepath"
+	"io/fs"
+	"net/url"
+	"path"
 	"time"
 
 	"github.com/tullo/search/internal/forms"
+	"github.com/tullo/search/internal/i18n"
 	"github.com/tullo/search/internal/product"
+	"github.com/tullo/search/internal/sanitize"
 	"github.com/tullo/search/internal/user"
 )
 
 type templateData struct {
+	Challenge       *i18n.Message
+	CSPNonce        string
 	CSRFToken       string
+	Creator         *user.User
+	CreatorColumn   bool
+	Creators        map[string]*user.User // product creators by ID
 	CurrentYear     int
+	Dashboard       *dashboardView
+	DidYouMean      []string // names offered for a search matching nothing
 	Flash           string
 	Form            *forms.Form
+	Pagination      *pagination
 	Path            string
+	IsAdmin         bool
 	IsAuthenticated bool
+	Timezone        string
+	Timezones       []string
+	Languages       []string
+	LiveReload      string // path of the reload event stream, if enabled
+	Locale          *i18n.Localizer
+	Money           product.MoneyFormat
 	Products        []product.Product
 	Product         *product.Product
+	Quality         *qualityView
+	Query           product.Query
 	User            *user.User
 	Version         string
 }
 
-func humanDate(t time.Time) string {
+// humanDate formats t in the language and time zone of the localizer, if
+// one is given, and in UTC otherwise.
+func humanDate(t time.Time, l ...*i18n.Localizer) string {
 	if t.IsZero() {
 		return ""
 	}
 
-	// Convert the time to UTC before formatting it.
-	return t.UTC().Format("02 Jan 2006 at 15:04")
+	var loc *i18n.Localizer
+	if len(l) > 0 {
+		loc = l[0]
+	}
+
+	return loc.FormatDate(t)
+}
+
+// relativeTime renders t relative to the current time, e.g. "3 days ago",
+// with the absolute time in the title of the time element.
+func relativeTime(t time.Time, l *i18n.Localizer) template.HTML {
+	if t.IsZero() {
+		return ""
+	}
+	return template.HTML(fmt.Sprintf(`<time datetime="%s" title="%s">%s</time>`,
+		t.UTC().Format(time.RFC3339),
+		template.HTMLEscapeString(humanDate(t, l)),
+		template.HTMLEscapeString(l.RelativeTime(t, time.Now())),
+	))
+}
+
+// translate renders a catalog key or a message in the language of the localizer.
+func translate(l *i18n.Localizer, msg interface{}, args ...interface{}) string {
+	switch m := msg.(type) {
+	case i18n.Message:
+		return l.Message(m)
+	case *i18n.Message:
+		if m == nil {
+			return ""
+		}
+		return l.Message(*m)
+	case string:
+		return l.T(m, args...)
+	}
+	return fmt.Sprint(msg)
+}
+
+// money formats an amount in cents in the configured currency and locale.
+func money(f product.MoneyFormat, m product.Money) string {
+	return f.Format(m)
 }
 
 func shortID(s string) string {
@@ -43,18 +105,87 @@ func incr(idx int) int {
 	return idx + 1
 }
 
+// listingURL links to a listing with the search and sort order of q, and
+// further query parameters given as key value pairs.
+func listingURL(path string, q product.Query, params ...string) string {
+	v := url.Values{}
+	if q.Search != "" {
+		v.Set("q", q.Search)
+	}
+	if q.Order != "" {
+		v.Set("sort", q.Order)
+	}
+	for i := 0; i+1 < len(params); i += 2 {
+		v.Set(params[i], params[i+1])
+	}
+	if len(v) == 0 {
+		return path
+	}
+	return path + "?" + v.Encode()
+}
+
+// toggleSort sorts by field, or reverses the order if already sorted by it.
+func toggleSort(q product.Query, field string) product.Query {
+	if q.Order == field {
+		q.Order = "-" + field
+	} else {
+		q.Order = field
+	}
+	return q
+}
+
+// sortState returns the aria-sort value of the column of field.
+func sortState(q product.Query, field string) string {
+	switch q.Order {
+	case field:
+		return "ascending"
+	case "-" + field:
+		return "descending"
+	}
+	return "none"
+}
+
+// searchFor searches for s instead, keeping the order of q.
+func searchFor(q product.Query, s string) product.Query {
+	return product.Query{Search: s, Order: q.Order, Fuzziness: q.Fuzziness}
+}
+
+// highlight renders the name of p with the parts matching the search of q
+// marked.
+func highlight(q product.Query, p product.Product) template.HTML {
+	return q.NameHTML(&p)
+}
+
+// sanitizeHTML renders user-provided text allowing basic inline formatting.
+func sanitizeHTML(s string) template.HTML {
+	return sanitize.Inline.HTML(s)
+}
+
 var functions = template.FuncMap{
-	"humanDate": humanDate,
-	"shortID":   shortID,
-	"incr":      incr,
+	"humanDate":    humanDate,
+	"relativeTime": relativeTime,
+	"shortID":      shortID,
+	"incr":         incr,
+	"money":        money,
+	"sanitize":     sanitizeHTML,
+	"t":            translate,
+	"plain":        sanitize.Inline.Text,
+	"listingURL":   listingURL,
+	"toggleSort":   toggleSort,
+	"sortState":    sortState,
+	"searchFor":    searchFor,
+	"highlight":    highlight,
 }
 
-func newTemplateCache(dir string) (map[string]*template.Template, error) {
+// newTemplateCache parses every page of fsys together with the layouts and
+// partials. The static function of the templates maps the name of a static
+// asset to its URL.
+func newTemplateCache(fsys fs.FS, static func(name string) string) (map[string]*template.Template, error) {
 
 	cache := map[string]*template.Template{}
 
 	// slice of filepaths with the extension '.page.tmpl'
-	pages, err := filepath.Glob(filepath.Join(dir, "*.page.tmpl"))
+	pages, err := fs.Glob(fsys, "*.page.tmpl")
 	if err != nil {
 		return nil, err
 	}
@@ -62,22 +193,22 @@ func newTemplateCache(dir string) (map[string]*template.Template, error) {
 	for _, page := range pages {
 
 		// extract the file name
-		name := filepath.Base(page)
+		name := path.Base(page)
 
 		// parse the page template file into a template set
-		ts, err := template.New(name).Funcs(functions).ParseFiles(page)
+		ts, err := template.New(name).Funcs(functions).Funcs(template.FuncMap{"static": static}).ParseFS(fsys, page)
 		if err != nil {
 			return nil, err
 		}
 
 		// add any 'layout' templates to the template set
-		ts, err = ts.ParseGlob(filepath.Join(dir, "*.layout.tmpl"))
+		ts, err = ts.ParseFS(fsys, "*.layout.tmpl")
 		if err != nil {
 			return nil, err
 		}
 
 		// add any 'partial' templates to the template set
-		ts, err = ts.ParseGlob(filepath.Join(dir, "*.partial.tmpl"))
+		ts, err = ts.ParseFS(fsys, "*.partial.tmpl")
 		if err != nil {
 			return nil, err
 		}
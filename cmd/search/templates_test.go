@@ -1,8 +1,14 @@
 package main
 
 import (
+	"bytes"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/tullo/search/internal/i18n"
+	"github.com/tullo/search/internal/product"
+	"github.com/tullo/search/internal/user"
 )
 
 func TestHumanDate(t *testing.T) {
@@ -67,3 +73,177 @@ func TestShortID(t *testing.T) {
 		})
 	}
 }
+
+func TestSanitizedProductName(t *testing.T) {
+	_, html, _ := testUI(t)
+	ts, err := newTemplateCache(html, func(name string) string { return "/static/" + name })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := product.Product{ID: "1", Name: `Tom &amp; <b>Jerry</b><script>alert("xss")</script>`}
+	td := &templateData{Product: &p, Products: []product.Product{p}, Path: "/product"}
+
+	for _, page := range []string{"home.page.tmpl", "show.page.tmpl"} {
+		t.Run(page, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := ts[page].Execute(&buf, td); err != nil {
+				t.Fatal(err)
+			}
+			body := buf.String()
+			if strings.Contains(body, "<script>alert") {
+				t.Error("want script element to be escaped")
+			}
+			if !strings.Contains(body, "Tom &amp; <b>Jerry</b>&lt;script&gt;") {
+				t.Errorf("want sanitized name in body")
+			}
+		})
+	}
+}
+
+func TestSanitizedUserName(t *testing.T) {
+	_, html, _ := testUI(t)
+	ts, err := newTemplateCache(html, func(name string) string { return "/static/" + name })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u := user.User{ID: "u1", Name: `Tom &amp; <b>Jerry</b><script>alert("xss")</script>`, Email: "tom@example.com"}
+	p := product.Product{ID: "1", Name: "Comic Books", UserID: u.ID}
+	td := &templateData{
+		Product:       &p,
+		Products:      []product.Product{p},
+		Path:          "/product",
+		User:          &u,
+		Creator:       &u,
+		Creators:      map[string]*user.User{u.ID: &u},
+		CreatorColumn: true,
+	}
+
+	for _, page := range []string{"home.page.tmpl", "show.page.tmpl", "profile.page.tmpl"} {
+		t.Run(page, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := ts[page].Execute(&buf, td); err != nil {
+				t.Fatal(err)
+			}
+			body := buf.String()
+			if strings.Contains(body, "<script>alert") {
+				t.Error("want script element to be escaped")
+			}
+			if !strings.Contains(body, "Tom &amp; <b>Jerry</b>&lt;script&gt;") {
+				t.Errorf("want sanitized user name in body")
+			}
+		})
+	}
+}
+
+func TestHumanDateTimezone(t *testing.T) {
+	locales, _, _ := testUI(t)
+	catalog, err := i18n.Load(locales, "en")
+	if err != nil {
+		t.Fatal(err)
+	}
+	berlin, err := loadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newYork, err := loadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		tm   time.Time
+		loc  *time.Location
+		want string
+	}{
+		{
+			name: "Before spring forward",
+			tm:   time.Date(2021, 3, 28, 0, 59, 0, 0, time.UTC),
+			loc:  berlin,
+			want: "28 Mar 2021 at 01:59",
+		},
+		{
+			name: "After spring forward",
+			tm:   time.Date(2021, 3, 28, 1, 0, 0, 0, time.UTC),
+			loc:  berlin,
+			want: "28 Mar 2021 at 03:00",
+		},
+		{
+			name: "First pass through the repeated hour",
+			tm:   time.Date(2021, 11, 7, 5, 30, 0, 0, time.UTC),
+			loc:  newYork,
+			want: "07 Nov 2021 at 01:30",
+		},
+		{
+			name: "Second pass through the repeated hour",
+			tm:   time.Date(2021, 11, 7, 6, 30, 0, 0, time.UTC),
+			loc:  newYork,
+			want: "07 Nov 2021 at 01:30",
+		},
+		{
+			name: "Date line",
+			tm:   time.Date(2021, 12, 31, 23, 0, 0, 0, time.UTC),
+			loc:  berlin,
+			want: "01 Jan 2022 at 00:00",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := catalog.Localizer("en").In(tt.loc)
+			if hd := humanDate(tt.tm, l); hd != tt.want {
+				t.Errorf("want %q; got %q", tt.want, hd)
+			}
+		})
+	}
+}
+
+func TestRelativeTime(t *testing.T) {
+	locales, _, _ := testUI(t)
+	catalog, err := i18n.Load(locales, "en")
+	if err != nil {
+		t.Fatal(err)
+	}
+	berlin, err := loadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	en := catalog.Localizer("en").In(berlin)
+	de := catalog.Localizer("de").In(berlin)
+
+	tests := []struct {
+		name string
+		l    *i18n.Localizer
+		tm   time.Time
+		now  time.Time
+		want string
+	}{
+		{"Now", en, time.Date(2021, 3, 1, 12, 0, 0, 0, berlin), time.Date(2021, 3, 1, 12, 0, 30, 0, berlin), "just now"},
+		{"Minutes", en, time.Date(2021, 3, 1, 12, 0, 0, 0, berlin), time.Date(2021, 3, 1, 12, 5, 0, 0, berlin), "5 minutes ago"},
+		{"Across midnight", en, time.Date(2021, 3, 1, 23, 30, 0, 0, berlin), time.Date(2021, 3, 2, 0, 30, 0, 0, berlin), "1 hour ago"},
+		{"Spring forward day has 23 hours", en, time.Date(2021, 3, 27, 12, 0, 0, 0, berlin), time.Date(2021, 3, 28, 12, 0, 0, 0, berlin), "1 day ago"},
+		{"Fall back day has 25 hours", en, time.Date(2021, 10, 30, 12, 0, 0, 0, berlin), time.Date(2021, 10, 31, 12, 0, 0, 0, berlin), "1 day ago"},
+		{"Days", en, time.Date(2021, 3, 26, 9, 0, 0, 0, berlin), time.Date(2021, 3, 29, 18, 0, 0, 0, berlin), "3 days ago"},
+		{"Weeks", en, time.Date(2021, 3, 1, 9, 0, 0, 0, berlin), time.Date(2021, 3, 16, 9, 0, 0, 0, berlin), "2 weeks ago"},
+		{"Months", en, time.Date(2021, 1, 31, 9, 0, 0, 0, berlin), time.Date(2021, 4, 30, 9, 0, 0, 0, berlin), "2 months ago"},
+		{"Years", en, time.Date(2019, 5, 1, 9, 0, 0, 0, berlin), time.Date(2021, 5, 1, 9, 0, 0, 0, berlin), "2 years ago"},
+		{"Future", en, time.Date(2021, 3, 1, 14, 0, 0, 0, berlin), time.Date(2021, 3, 1, 12, 0, 0, 0, berlin), "in 2 hours"},
+		{"German", de, time.Date(2021, 3, 26, 9, 0, 0, 0, berlin), time.Date(2021, 3, 29, 18, 0, 0, 0, berlin), "vor 3 Tagen"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.l.RelativeTime(tt.tm, tt.now); got != tt.want {
+				t.Errorf("want %q; got %q", tt.want, got)
+			}
+		})
+	}
+
+	html := relativeTime(time.Date(2021, 3, 28, 1, 0, 0, 0, time.UTC), en)
+	want := `<time datetime="2021-03-28T01:00:00Z" title="28 Mar 2021 at 03:00">`
+	if !strings.HasPrefix(string(html), want) {
+		t.Errorf("want %q to start with %q", html, want)
+	}
+}
@@ -1,9 +1,12 @@
 package main
 
 import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
 	"html"
 	"io"
-	"log"
+	"io/fs"
 	"net/http"
 	"net/http/cookiejar"
 	"net/http/httptest"
@@ -11,11 +14,20 @@ import (
 	"os"
 	"os/signal"
 	"regexp"
+	"strconv"
+	"strings"
 	"syscall"
 	"testing"
 	"time"
 
 	"github.com/golangcollege/sessions"
+	"github.com/tullo/search/internal/cache"
+	"github.com/tullo/search/internal/i18n"
+	"github.com/tullo/search/internal/logger"
+	"github.com/tullo/search/internal/product"
+	"github.com/tullo/search/internal/ratelimit"
+	"github.com/tullo/search/internal/user"
+	"github.com/tullo/search/ui"
 )
 
 // Capture the CSRF token value from the HTML page
@@ -34,10 +46,26 @@ func extractCSRFToken(t *testing.T, body []byte) string {
 	return html.UnescapeString(string(matches[1]))
 }
 
+// testUI returns the embedded message catalogs, templates and static
+// assets, the ones served in production.
+func testUI(t *testing.T) (locales, html, static fs.FS) {
+	locales, html, static, err := uiDirs(ui.Files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return locales, html, static
+}
+
 // newTestApplication creates an application struct with mock loggers
 func newTestApplication(t *testing.T) *application {
-	// Initialize template cache.
-	templateCache, err := newTemplateCache("./../../ui/html/")
+	locales, html, static := testUI(t)
+
+	log, err := logger.New(io.Discard, logger.FormatJSON, "debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	accessLog, err := newAccessLogger(accessLogJSON, nil, io.Discard)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -62,32 +90,180 @@ func newTestApplication(t *testing.T) *application {
 	// Identity Provider signing key ID.
 	keyID := "54bb2165-71e1-41a6-af3e-7da4a0e1e2c1"
 
+	// Login throttle that does not get in the way of the handler tests.
+	login := newLoginThrottle(ratelimit.NewMemory(), ratelimit.Policy{
+		Window:      time.Minute,
+		MaxFailures: 10,
+		Lockout:     time.Minute,
+		MaxLockout:  time.Hour,
+	}, 0)
+
+	// Security headers as configured by default.
+	security := &securityPolicy{
+		csp:               []string{"default-src 'self'", "script-src 'self'", "object-src 'none'"},
+		hstsMaxAge:        365 * 24 * time.Hour,
+		referrerPolicy:    "strict-origin-when-cross-origin",
+		permissionsPolicy: []string{"camera=()", "geolocation=()"},
+		openerPolicy:      "same-origin",
+	}
+
+	money, err := product.NewMoneyFormat("USD", "en-US")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	catalog, err := i18n.Load(locales, "en")
+	if err != nil {
+		t.Fatal(err)
+	}
+
 	// App struct instantiation using mocks for loggers and database models.
 	app := application{
-		debug:         true,
-		debugURL:      debugURL,
-		keyID:         keyID,
-		log:           log.New(io.Discard, "", 0),
-		templateCache: templateCache,
-		salesURL:      baseURL,
-		session:       session,
-		shutdown:      shutdown,
-		useTLS:        true,
+		accessLog:    accessLog,
+		catalog:      catalog,
+		debug:        true,
+		debugURL:     debugURL,
+		keyID:        keyID,
+		log:          log,
+		login:        login,
+		lowStock:     5,
+		events:       newEventHub(16, 8, time.Minute),
+		fuzziness:    0.25,
+		alternatives: 3,
+		money:        money,
+		proxies:      &proxyResolver{},
+		responses:    newResponseCache(100, time.Minute, time.Minute, time.Minute),
+		security:     security,
+		topN:         5,
+		users:        cache.New[string, *user.User](100, time.Minute),
+		salesURL:     baseURL,
+		session:      session,
+		shutdown:     shutdown,
+		suggest:      newSuggestIndex(10, time.Minute, 8, ratelimit.NewCounter(ratelimit.NewMemory(), 30, 10*time.Second)),
+		useTLS:       true,
+	}
+
+	if err := app.loadStatic(static); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.loadTemplates(html); err != nil {
+		t.Fatal(err)
 	}
 
 	return &app
 }
 
+// Fixtures served by the sales-api stand-in.
+var (
+	testUser = user.User{
+		ID:          "5cf37266-3473-4006-984f-9325122678b7",
+		Name:        "User Gopher",
+		Email:       "user@example.com",
+		DateCreated: time.Date(2019, 3, 24, 0, 0, 0, 0, time.UTC),
+		DateUpdated: time.Date(2019, 3, 24, 0, 0, 0, 0, time.UTC),
+	}
+	testRoles    = []string{"ADMIN", "USER"}
+	testProducts = []product.Product{
+		{
+			ID:          "72f8b983-3eb4-48db-9ed0-e45cc6bd716b",
+			Name:        "McDonalds Toys",
+			Cost:        7500,
+			Quantity:    120,
+			Sold:        3,
+			Revenue:     22500,
+			UserID:      testUser.ID,
+			DateCreated: time.Date(2019, 1, 1, 0, 0, 2, 0, time.UTC),
+			DateUpdated: time.Date(2019, 1, 1, 0, 0, 2, 0, time.UTC),
+		},
+		{
+			ID:          "a2b0639f-2cc6-44b8-b97b-15d69dbb511e",
+			Name:        "Comic Books",
+			Cost:        5000,
+			Quantity:    42,
+			Sold:        7,
+			Revenue:     35000,
+			UserID:      testUser.ID,
+			DateCreated: time.Date(2019, 1, 1, 0, 0, 1, 0, time.UTC),
+			DateUpdated: time.Date(2019, 1, 1, 0, 0, 1, 0, time.UTC),
+		},
+	}
+)
+
+// testToken returns an unsigned sales-api token of the subject with roles.
+func testToken(sub string, roles ...string) string {
+	rs, _ := json.Marshal(roles)
+	claims := base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf(`{"sub":%q,"roles":%s}`, sub, rs)))
+	return base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`)) + "." + claims + ".c2ln"
+}
+
+// newSalesAPI starts a stand-in for the sales-api serving the token, product
+// and user endpoints with the fixtures above. The URL of the returned server
+// is meant to be used as salesURL.
+func newSalesAPI(t *testing.T) *httptest.Server {
+	token := testToken(testUser.ID, testRoles...)
+
+	reply := func(w http.ResponseWriter, v interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(v)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /users/token/{kid}", func(w http.ResponseWriter, r *http.Request) {
+		email, pass, _ := r.BasicAuth()
+		if email != testUser.Email || pass != "gophers" {
+			http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+			return
+		}
+		reply(w, map[string]string{"token": token})
+	})
+	mux.HandleFunc("GET /users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if r.PathValue("id") != testUser.ID {
+			http.Error(w, `{"error":"not found"}`, http.StatusNotFound)
+			return
+		}
+		reply(w, testUser)
+	})
+	mux.HandleFunc("GET /products/{page}/{rows}", func(w http.ResponseWriter, r *http.Request) {
+		page, _ := strconv.Atoi(r.PathValue("page"))
+		rows, _ := strconv.Atoi(r.PathValue("rows"))
+		from := min(max(page-1, 0)*rows, len(testProducts))
+		reply(w, testProducts[from:min(from+rows, len(testProducts))])
+	})
+	mux.HandleFunc("GET /products/{id}", func(w http.ResponseWriter, r *http.Request) {
+		for _, p := range testProducts {
+			if p.ID == r.PathValue("id") {
+				reply(w, p)
+				return
+			}
+		}
+		http.Error(w, `{"error":"not found"}`, http.StatusNotFound)
+	})
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/users/token/") && r.Header.Get("Authorization") != "Bearer "+token {
+			http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
 type testServer struct {
 	*httptest.Server
 }
 
 // newTestServer initalizes and returns a new instance of testServer
-func newTestServer(t *testing.T, h http.Handler) *testServer {
+func newTestServer(t *testing.T, h http.Handler, configure ...func(*http.Server)) *testServer {
 
 	// spinup a https server for the duration of the test
 	ts := httptest.NewUnstartedServer(h)
 	ts.EnableHTTP2 = true
+	for _, c := range configure {
+		c(ts.Config)
+	}
 	ts.StartTLS()
 
 	jar, err := cookiejar.New(nil)
@@ -127,8 +303,16 @@ func (ts *testServer) get(t *testing.T, urlPath string) (int, http.Header, []byt
 
 // postForm method for sending POST requests to the test server
 func (ts *testServer) postForm(t *testing.T, urlPath string, form url.Values) (int, http.Header, []byte) {
+	req, err := http.NewRequest(http.MethodPost, ts.URL+urlPath, strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	// secure requests must prove their origin to pass the CSRF checks
+	req.Header.Set("Referer", ts.URL+urlPath)
+
 	// make a POST request against the test server
-	rs, err := ts.Client().PostForm(ts.URL+urlPath, form)
+	rs, err := ts.Client().Do(req)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -142,6 +326,20 @@ func (ts *testServer) postForm(t *testing.T, urlPath string, form url.Values) (i
 	return rs.StatusCode, rs.Header, body
 }
 
+// login signs in the user of the sales-api stand-in.
+func (ts *testServer) login(t *testing.T) {
+	_, _, body := ts.get(t, "/user/login")
+
+	form := url.Values{}
+	form.Add("email", testUser.Email)
+	form.Add("password", "gophers")
+	form.Add("csrf_token", extractCSRFToken(t, body))
+
+	if code, _, _ := ts.postForm(t, "/user/login", form); code != http.StatusSeeOther {
+		t.Fatalf("login: want %d; got %d", http.StatusSeeOther, code)
+	}
+}
+
 func (ts *testServer) clientDo(t *testing.T, r *http.Request) (int, http.Header, []byte) {
 	rs, err := ts.Client().Do(r)
 	if err != nil {
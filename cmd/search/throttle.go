@@ -0,0 +1,141 @@
+package main
+
+import (
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tullo/search/internal/i18n"
+	"github.com/tullo/search/internal/ratelimit"
+)
+
+// loginThrottle protects the login form against brute-force attacks by
+// counting failed logins per client IP and per email address.
+type loginThrottle struct {
+	limiter *ratelimit.Limiter
+	// challengeAfter is the number of failures after which a challenge has
+	// to be answered along with the credentials, 0 disables challenges.
+	challengeAfter int
+	challenges     *challengeStore
+}
+
+func newLoginThrottle(store ratelimit.Store, policy ratelimit.Policy, challengeAfter int) *loginThrottle {
+	return &loginThrottle{
+		limiter:        ratelimit.New(store, policy),
+		challengeAfter: challengeAfter,
+		challenges:     newChallengeStore(),
+	}
+}
+
+// loginKeys returns the rate limiting keys of a login attempt.
+func loginKeys(r *http.Request, email string) []string {
+	keys := []string{"ip:" + remoteClient(r).IP}
+	if email = strings.ToLower(strings.TrimSpace(email)); email != "" {
+		keys = append(keys, "email:"+email)
+	}
+	return keys
+}
+
+// status returns the most restrictive status of all keys.
+func (lt *loginThrottle) status(keys []string) ratelimit.Status {
+	var worst ratelimit.Status
+	for _, k := range keys {
+		worst = worse(worst, lt.limiter.Status(k))
+	}
+	return worst
+}
+
+// fail records a failed attempt for all keys.
+func (lt *loginThrottle) fail(keys []string) ratelimit.Status {
+	var worst ratelimit.Status
+	for _, k := range keys {
+		worst = worse(worst, lt.limiter.Fail(k))
+	}
+	return worst
+}
+
+// succeed forgets the failures of the email address. The failures of the
+// client IP leave the window on their own, otherwise logging into an own
+// account between the attempts would hide a spray against other addresses.
+func (lt *loginThrottle) succeed(keys []string) {
+	for _, k := range keys {
+		if strings.HasPrefix(k, "email:") {
+			lt.limiter.Succeed(k)
+		}
+	}
+}
+
+// needsChallenge reports whether the next attempt has to answer a challenge.
+func (lt *loginThrottle) needsChallenge(s ratelimit.Status) bool {
+	return lt.challengeAfter > 0 && s.Failures >= lt.challengeAfter
+}
+
+func worse(a, b ratelimit.Status) ratelimit.Status {
+	if b.RetryAfter > a.RetryAfter {
+		a.RetryAfter = b.RetryAfter
+	}
+	if b.Failures > a.Failures {
+		a.Failures = b.Failures
+	}
+	return a
+}
+
+// challengeStore keeps the expected answers of the challenges handed out.
+// The answers stay on the server and each challenge can only be used once,
+// a replayed session cookie does not help to bypass it.
+type challengeStore struct {
+	mu      sync.Mutex
+	answers map[string]challenge
+}
+
+type challenge struct {
+	answer  int
+	expires time.Time
+}
+
+func newChallengeStore() *challengeStore {
+	return &challengeStore{answers: make(map[string]challenge)}
+}
+
+// issue creates a new challenge and returns its ID and question.
+func (cs *challengeStore) issue() (string, i18n.Message) {
+	a, b := rand.IntN(10)+1, rand.IntN(10)+1
+	id := newRequestID()
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	now := time.Now()
+	for k, c := range cs.answers {
+		if now.After(c.expires) {
+			delete(cs.answers, k)
+		}
+	}
+	cs.answers[id] = challenge{answer: a + b, expires: now.Add(10 * time.Minute)}
+
+	return id, i18n.M("login.challenge", a, b)
+}
+
+// verify checks and consumes the challenge with the given ID.
+func (cs *challengeStore) verify(id, answer string) bool {
+	cs.mu.Lock()
+	c, ok := cs.answers[id]
+	delete(cs.answers, id)
+	cs.mu.Unlock()
+
+	if !ok || time.Now().After(c.expires) {
+		return false
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(answer))
+	return err == nil && n == c.answer
+}
+
+// issueChallenge hands out a new challenge and remembers it in the session.
+func (app *application) issueChallenge(r *http.Request) *i18n.Message {
+	id, question := app.login.challenges.issue()
+	app.session.Put(r, "loginChallenge", id)
+	return &question
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// locations caches the time zones loaded by name, the lookup in the time
+// zone database is too slow to run on every request.
+var locations sync.Map
+
+// loadLocation returns the time zone with the given IANA name.
+func loadLocation(name string) (*time.Location, error) {
+	if loc, ok := locations.Load(name); ok {
+		return loc.(*time.Location), nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, err
+	}
+	if name == "" || name == "Local" {
+		// the server's zone is not a user's choice
+		return time.UTC, nil
+	}
+	locations.Store(name, loc)
+	return loc, nil
+}
+
+// commonTimezones are offered on the profile page, any other IANA zone name
+// can be entered as well.
+var commonTimezones = []string{
+	"UTC",
+	"America/Los_Angeles",
+	"America/Denver",
+	"America/Chicago",
+	"America/New_York",
+	"America/Sao_Paulo",
+	"Europe/London",
+	"Europe/Copenhagen",
+	"Europe/Berlin",
+	"Europe/Zurich",
+	"Europe/Paris",
+	"Europe/Helsinki",
+	"Africa/Johannesburg",
+	"Asia/Dubai",
+	"Asia/Kolkata",
+	"Asia/Shanghai",
+	"Asia/Tokyo",
+	"Australia/Sydney",
+	"Pacific/Auckland",
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/tullo/search/internal/product"
+	"github.com/tullo/search/internal/user"
+)
+
+// userLookups limits the concurrent sales-api requests of a page resolving
+// its product creators.
+const userLookups = 4
+
+// resolveUsers returns the users with the given IDs, reading the ones not
+// cached concurrently from the sales-api. Users that do not exist or may
+// not be read are left out and remembered as such; failed lookups are
+// logged and left out, so that a page still renders without them.
+func (app *application) resolveUsers(ctx context.Context, r *http.Request, ids ...string) map[string]*user.User {
+	// the users a session may read depend on its token
+	scope := tokenScope(app.token(r))
+	users := make(map[string]*user.User, len(ids))
+
+	var missing []string
+	for _, id := range ids {
+		if _, seen := users[id]; seen || id == "" {
+			continue
+		}
+		u, ok := app.users.Get(scope + "|" + id)
+		if !ok {
+			missing = append(missing, id)
+		}
+		// nil marks the ID as seen, unknown users are dropped below
+		users[id] = u
+	}
+
+	var (
+		mu  sync.Mutex
+		wg  sync.WaitGroup
+		sem = make(chan struct{}, userLookups)
+	)
+	token := app.token(r)
+	for _, id := range missing {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+
+			u, err := app.fetchUser(ctx, token, id)
+			switch upstreamStatus(err) {
+			case 0:
+				if err != nil {
+					app.logger(r).WarnContext(ctx, "resolving user", "user", id, "error", err)
+					return
+				}
+				app.users.Add(scope+"|"+id, &u)
+				mu.Lock()
+				users[id] = &u
+				mu.Unlock()
+			case http.StatusNotFound, http.StatusForbidden:
+				app.users.Add(scope+"|"+id, nil)
+			default:
+				app.logger(r).WarnContext(ctx, "resolving user", "user", id, "error", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	for id, u := range users {
+		if u == nil {
+			delete(users, id)
+		}
+	}
+	return users
+}
+
+// resolveCreators resolves the users who created the products.
+func (app *application) resolveCreators(ctx context.Context, r *http.Request, products []product.Product) map[string]*user.User {
+	ids := make([]string, len(products))
+	for i, p := range products {
+		ids[i] = p.UserID
+	}
+	return app.resolveUsers(ctx, r, ids...)
+}
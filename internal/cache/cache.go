@@ -0,0 +1,120 @@
+// Package cache provides an in-memory cache bounded in size whose entries
+// expire after a fixed time to live.
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// LRU is a cache holding at most a fixed number of entries. When full, the
+// least recently used entry makes room for a new one. It is safe for
+// concurrent use.
+type LRU[K comparable, V any] struct {
+	mu    sync.Mutex
+	size  int
+	ttl   time.Duration
+	ll    *list.List // most recently used first
+	items map[K]*list.Element
+
+	// Now returns the current time, it can be replaced in tests.
+	Now func() time.Time
+}
+
+type entry[K comparable, V any] struct {
+	key     K
+	value   V
+	expires time.Time
+}
+
+// New returns a cache of at most size entries, each kept for ttl.
+func New[K comparable, V any](size int, ttl time.Duration) *LRU[K, V] {
+	return &LRU[K, V]{
+		size:  size,
+		ttl:   ttl,
+		ll:    list.New(),
+		items: make(map[K]*list.Element),
+		Now:   time.Now,
+	}
+}
+
+// Get returns the value of key if it is cached and has not expired.
+func (c *LRU[K, V]) Get(key K) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var zero V
+	el, ok := c.items[key]
+	if !ok {
+		return zero, false
+	}
+	e := el.Value.(*entry[K, V])
+	if !c.Now().Before(e.expires) {
+		c.removeElement(el)
+		return zero, false
+	}
+	c.ll.MoveToFront(el)
+	return e.value, true
+}
+
+// Add caches the value of key, replacing a previous value.
+func (c *LRU[K, V]) Add(key K, value V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.size <= 0 {
+		return
+	}
+
+	expires := c.Now().Add(c.ttl)
+	if el, ok := c.items[key]; ok {
+		e := el.Value.(*entry[K, V])
+		e.value, e.expires = value, expires
+		c.ll.MoveToFront(el)
+		return
+	}
+
+	c.items[key] = c.ll.PushFront(&entry[K, V]{key: key, value: value, expires: expires})
+	for c.ll.Len() > c.size {
+		c.removeElement(c.ll.Back())
+	}
+}
+
+// Remove drops key from the cache.
+func (c *LRU[K, V]) Remove(key K) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		c.removeElement(el)
+	}
+}
+
+// RemoveFunc drops the entries whose key matches and returns their number.
+func (c *LRU[K, V]) RemoveFunc(match func(key K) bool) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n := 0
+	for key, el := range c.items {
+		if match(key) {
+			c.removeElement(el)
+			n++
+		}
+	}
+	return n
+}
+
+// Len returns the number of cached entries, including expired ones not yet
+// dropped.
+func (c *LRU[K, V]) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len()
+}
+
+func (c *LRU[K, V]) removeElement(el *list.Element) {
+	c.ll.Remove(el)
+	delete(c.items, el.Value.(*entry[K, V]).key)
+}
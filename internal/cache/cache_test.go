@@ -0,0 +1,67 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLRU(t *testing.T) {
+	now := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	c := New[string, int](2, time.Minute)
+	c.Now = func() time.Time { return now }
+
+	c.Add("a", 1)
+	c.Add("b", 2)
+	if v, ok := c.Get("a"); !ok || v != 1 {
+		t.Errorf("want a=1; got %d, %v", v, ok)
+	}
+
+	// b is the least recently used entry and makes room for c
+	c.Add("c", 3)
+	if _, ok := c.Get("b"); ok {
+		t.Error("want b evicted")
+	}
+	if c.Len() != 2 {
+		t.Errorf("want 2 entries; got %d", c.Len())
+	}
+
+	// replacing a value renews its time to live
+	now = now.Add(30 * time.Second)
+	c.Add("a", 10)
+	now = now.Add(45 * time.Second)
+	if v, ok := c.Get("a"); !ok || v != 10 {
+		t.Errorf("want a=10; got %d, %v", v, ok)
+	}
+	if _, ok := c.Get("c"); ok {
+		t.Error("want c expired")
+	}
+	if c.Len() != 1 {
+		t.Errorf("want the expired entry dropped; got %d entries", c.Len())
+	}
+
+	c.Remove("a")
+	if _, ok := c.Get("a"); ok {
+		t.Error("want a removed")
+	}
+}
+
+func TestLRURemoveFunc(t *testing.T) {
+	c := New[string, int](10, time.Minute)
+	for _, k := range []string{"a/1", "a/2", "b/1"} {
+		c.Add(k, 1)
+	}
+	if n := c.RemoveFunc(func(k string) bool { return k[0] == 'a' }); n != 2 {
+		t.Errorf("want 2 entries removed; got %d", n)
+	}
+	if _, ok := c.Get("b/1"); !ok || c.Len() != 1 {
+		t.Errorf("want only b/1 left; got %d entries", c.Len())
+	}
+}
+
+func TestLRUDisabled(t *testing.T) {
+	c := New[string, int](0, time.Minute)
+	c.Add("a", 1)
+	if _, ok := c.Get("a"); ok {
+		t.Error("want nothing cached")
+	}
+}
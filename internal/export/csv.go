@@ -0,0 +1,72 @@
+package export
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/tullo/search/internal/product"
+)
+
+// dateLayout is used for dates in text formats, it sorts and parses well.
+const dateLayout = "2006-01-02 15:04:05"
+
+// CSV writes comma or tab separated values.
+type CSV struct {
+	w   *csv.Writer
+	o   Options
+	rec []string
+}
+
+// NewCSV returns a writer separating the fields with comma.
+func NewCSV(w io.Writer, comma rune, o Options) *CSV {
+	cw := csv.NewWriter(w)
+	cw.Comma = comma
+	return &CSV{w: cw, o: o}
+}
+
+// WriteHeader writes the row of column names.
+func (c *CSV) WriteHeader(names []string) error {
+	return c.w.Write(names)
+}
+
+// Write writes a row of cells.
+func (c *CSV) Write(row []Cell) error {
+	c.rec = c.rec[:0]
+	for _, cell := range row {
+		c.rec = append(c.rec, c.format(cell))
+	}
+	return c.w.Write(c.rec)
+}
+
+func (c *CSV) format(cell Cell) string {
+	switch cell.kind {
+	case kindInt:
+		return strconv.FormatInt(cell.n, 10)
+	case kindMoney:
+		return c.o.Money.Format(product.Money(cell.n))
+	case kindTime:
+		if cell.t.IsZero() {
+			return ""
+		}
+		return cell.t.In(c.o.location()).Format(dateLayout)
+	}
+	return escapeFormula(cell.text)
+}
+
+// Close flushes the remaining rows.
+func (c *CSV) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// escapeFormula keeps spreadsheets from evaluating text that starts like a
+// formula, a user provided product name must not run as a formula on the
+// machine of whoever opens the export.
+func escapeFormula(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
@@ -0,0 +1,108 @@
+// Package export writes tables as CSV, TSV or XLSX documents row by row, so
+// that large tables can be streamed without holding them in memory.
+package export
+
+import (
+	"io"
+	"time"
+
+	"github.com/tullo/search/internal/product"
+)
+
+// Writer writes a table row by row.
+type Writer interface {
+	// WriteHeader writes the row of column names.
+	WriteHeader(names []string) error
+	// Write writes a row of cells. Rows are buffered and passed on to the
+	// underlying writer in chunks.
+	Write(row []Cell) error
+	// Close finishes the document, it does not close the underlying writer.
+	Close() error
+}
+
+// Options control how the cell values are written.
+type Options struct {
+	Money    product.MoneyFormat
+	Location *time.Location // time zone of dates, UTC if nil
+	Sheet    string         // name of the worksheet in spreadsheet formats
+}
+
+func (o Options) location() *time.Location {
+	if o.Location == nil {
+		return time.UTC
+	}
+	return o.Location
+}
+
+// Format is a document format the table can be exported as.
+type Format struct {
+	Name        string
+	ContentType string
+	Extension   string
+	New         func(w io.Writer, o Options) Writer
+}
+
+var formats = map[string]Format{
+	"csv": {
+		Name:        "csv",
+		ContentType: "text/csv; charset=utf-8",
+		Extension:   ".csv",
+		New:         func(w io.Writer, o Options) Writer { return NewCSV(w, ',', o) },
+	},
+	"tsv": {
+		Name:        "tsv",
+		ContentType: "text/tab-separated-values; charset=utf-8",
+		Extension:   ".tsv",
+		New:         func(w io.Writer, o Options) Writer { return NewCSV(w, '\t', o) },
+	},
+	"xlsx": {
+		Name:        "xlsx",
+		ContentType: "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+		Extension:   ".xlsx",
+		New:         func(w io.Writer, o Options) Writer { return NewXLSX(w, o) },
+	},
+}
+
+// Lookup returns the format with the given name.
+func Lookup(name string) (Format, bool) {
+	f, ok := formats[name]
+	return f, ok
+}
+
+type kind int
+
+const (
+	kindText kind = iota
+	kindInt
+	kindMoney
+	kindTime
+)
+
+// Cell is a value of a row. Each format writes text, numbers, amounts of
+// money and times in its native representation.
+type Cell struct {
+	kind kind
+	text string
+	n    int64
+	t    time.Time
+}
+
+// Text returns a text cell.
+func Text(s string) Cell {
+	return Cell{kind: kindText, text: s}
+}
+
+// Int returns a number cell.
+func Int(n int) Cell {
+	return Cell{kind: kindInt, n: int64(n)}
+}
+
+// Money returns a cell holding an amount in the currency of the options.
+func Money(m product.Money) Cell {
+	return Cell{kind: kindMoney, n: int64(m)}
+}
+
+// Time returns a date cell, the zero time is written as an empty cell.
+func Time(t time.Time) Cell {
+	return Cell{kind: kindTime, t: t}
+}
@@ -0,0 +1,142 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tullo/search/internal/product"
+)
+
+func testOptions(t *testing.T) Options {
+	money, err := product.NewMoneyFormat("EUR", "de-DE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Options{Money: money, Location: berlin, Sheet: "Products"}
+}
+
+var testRow = []Cell{
+	Text("=HYPERLINK(\"http://example.com\")"),
+	Int(42),
+	Money(150075),
+	Time(time.Date(2021, 3, 28, 1, 30, 0, 0, time.UTC)),
+	Time(time.Time{}),
+}
+
+func TestCSV(t *testing.T) {
+	tests := []struct {
+		name  string
+		comma rune
+		want  string
+	}{
+		{"CSV", ',', "Name,N,Cost,Date,Empty\n" + `"'=HYPERLINK(""http://example.com"")",42,"1.500,75` + "\u00a0" + `€",2021-03-28 03:30:00,` + "\n"},
+		{"TSV", '\t', "Name\tN\tCost\tDate\tEmpty\n" + `"'=HYPERLINK(""http://example.com"")"` + "\t42\t1.500,75\u00a0€\t2021-03-28 03:30:00\t\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w := NewCSV(&buf, tt.comma, testOptions(t))
+			if err := w.WriteHeader([]string{"Name", "N", "Cost", "Date", "Empty"}); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Write(testRow); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if buf.String() != tt.want {
+				t.Errorf("want %q; got %q", tt.want, buf.String())
+			}
+		})
+	}
+}
+
+func TestXLSX(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewXLSX(&buf, testOptions(t))
+	if err := w.WriteHeader([]string{"Name", "N", "Cost", "Date", "Empty"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Write(testRow); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parts := make(map[string]string)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts[f.Name] = string(b)
+
+		// every part has to be well formed
+		d := xml.NewDecoder(bytes.NewReader(b))
+		for {
+			if _, err := d.Token(); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatalf("%s: %v", f.Name, err)
+			}
+		}
+	}
+
+	for _, name := range []string{"[Content_Types].xml", "_rels/.rels", "xl/workbook.xml", "xl/_rels/workbook.xml.rels", "xl/styles.xml", "xl/worksheets/sheet1.xml"} {
+		if _, ok := parts[name]; !ok {
+			t.Errorf("want part %s", name)
+		}
+	}
+
+	sheet := parts["xl/worksheets/sheet1.xml"]
+	for _, want := range []string{
+		`<c r="A1" t="inlineStr" s="1"><is><t xml:space="preserve">Name</t></is></c>`,
+		`<c r="A2" t="inlineStr"><is><t xml:space="preserve">=HYPERLINK(&#34;http://example.com&#34;)</t></is></c>`,
+		`<c r="B2"><v>42</v></c>`,
+		`<c r="C2" s="2"><v>1500.75</v></c>`,
+		`<c r="D2" s="3"><v>44283.145833333336</v></c>`,
+	} {
+		if !strings.Contains(sheet, want) {
+			t.Errorf("want sheet to contain %s", want)
+		}
+	}
+	if strings.Contains(sheet, `r="E2"`) {
+		t.Error("want zero time to be left out")
+	}
+	if !strings.Contains(parts["xl/styles.xml"], `formatCode="#,##0.00&#34;`+"\u00a0"+`€&#34;"`) {
+		t.Errorf("want money format in styles; got %s", parts["xl/styles.xml"])
+	}
+	if !strings.Contains(parts["xl/workbook.xml"], `<sheet name="Products"`) {
+		t.Error("want sheet name")
+	}
+}
+
+func TestColumnName(t *testing.T) {
+	for i, want := range map[int]string{0: "A", 25: "Z", 26: "AA", 51: "AZ", 52: "BA", 701: "ZZ", 702: "AAA"} {
+		if got := columnName(i); got != want {
+			t.Errorf("column %d: want %q; got %q", i, want, got)
+		}
+	}
+}
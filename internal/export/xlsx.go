@@ -0,0 +1,243 @@
+package export
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tullo/search/internal/product"
+)
+
+// Styles of the cells, indexes into cellXfs of the style sheet.
+const (
+	styleDefault = iota
+	styleHeader
+	styleMoney
+	styleDate
+)
+
+// excelEpoch is day zero of the serial dates of spreadsheets.
+var excelEpoch = time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+
+const (
+	nsMain = "http://schemas.openxmlformats.org/spreadsheetml/2006/main"
+	nsRels = "http://schemas.openxmlformats.org/officeDocument/2006/relationships"
+	nsPkg  = "http://schemas.openxmlformats.org/package/2006/relationships"
+
+	xmlHeader = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n"
+)
+
+// XLSX writes an Office Open XML workbook with a single worksheet. The
+// fixed parts of the package are written up front, the rows of the sheet
+// are streamed into the last zip entry.
+type XLSX struct {
+	zw  *zip.Writer
+	w   *bufio.Writer
+	o   Options
+	row int
+	err error
+}
+
+// NewXLSX returns a writer of a workbook holding a single sheet.
+func NewXLSX(w io.Writer, o Options) *XLSX {
+	x := XLSX{zw: zip.NewWriter(w), o: o}
+	if x.o.Sheet == "" {
+		x.o.Sheet = "Sheet1"
+	}
+
+	parts := []struct{ name, content string }{
+		{"[Content_Types].xml", contentTypes},
+		{"_rels/.rels", `<Relationships xmlns="` + nsPkg + `">` +
+			`<Relationship Id="rId1" Type="` + nsRels + `/officeDocument" Target="xl/workbook.xml"/>` +
+			`</Relationships>`},
+		{"xl/workbook.xml", `<workbook xmlns="` + nsMain + `" xmlns:r="` + nsRels + `"><sheets>` +
+			`<sheet name="` + escape(sheetName(x.o.Sheet)) + `" sheetId="1" r:id="rId1"/>` +
+			`</sheets></workbook>`},
+		{"xl/_rels/workbook.xml.rels", `<Relationships xmlns="` + nsPkg + `">` +
+			`<Relationship Id="rId1" Type="` + nsRels + `/worksheet" Target="worksheets/sheet1.xml"/>` +
+			`<Relationship Id="rId2" Type="` + nsRels + `/styles" Target="styles.xml"/>` +
+			`</Relationships>`},
+		{"xl/styles.xml", styles(moneyFormatCode(o.Money))},
+	}
+	for _, p := range parts {
+		f, err := x.zw.Create(p.name)
+		if err != nil {
+			x.err = err
+			return &x
+		}
+		if _, err := io.WriteString(f, xmlHeader+p.content); err != nil {
+			x.err = err
+			return &x
+		}
+	}
+
+	f, err := x.zw.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		x.err = err
+		return &x
+	}
+	x.w = bufio.NewWriter(f)
+	x.w.WriteString(xmlHeader + `<worksheet xmlns="` + nsMain + `"><sheetData>`)
+
+	return &x
+}
+
+// WriteHeader writes the row of column names in bold.
+func (x *XLSX) WriteHeader(names []string) error {
+	row := make([]Cell, len(names))
+	for i, n := range names {
+		row[i] = Text(n)
+	}
+	return x.write(row, styleHeader)
+}
+
+// Write writes a row of cells.
+func (x *XLSX) Write(row []Cell) error {
+	return x.write(row, styleDefault)
+}
+
+func (x *XLSX) write(row []Cell, style int) error {
+	if x.err != nil {
+		return x.err
+	}
+	x.row++
+
+	w := x.w
+	fmt.Fprintf(w, `<row r="%d">`, x.row)
+	for i, c := range row {
+		ref := columnName(i) + strconv.Itoa(x.row)
+		switch c.kind {
+		case kindInt:
+			fmt.Fprintf(w, `<c r="%s"><v>%d</v></c>`, ref, c.n)
+		case kindMoney:
+			fmt.Fprintf(w, `<c r="%s" s="%d"><v>%s</v></c>`, ref, styleMoney, x.o.Money.Decimal(product.Money(c.n)))
+		case kindTime:
+			if c.t.IsZero() {
+				continue
+			}
+			fmt.Fprintf(w, `<c r="%s" s="%d"><v>%s</v></c>`, ref, styleDate, serialDate(c.t.In(x.o.location())))
+		default:
+			fmt.Fprintf(w, `<c r="%s" t="inlineStr"`, ref)
+			if style != styleDefault {
+				fmt.Fprintf(w, ` s="%d"`, style)
+			}
+			fmt.Fprintf(w, `><is><t xml:space="preserve">%s</t></is></c>`, escape(c.text))
+		}
+	}
+	_, x.err = w.WriteString(`</row>`)
+
+	return x.err
+}
+
+// Close finishes the sheet and writes the central directory of the zip.
+func (x *XLSX) Close() error {
+	if x.err != nil {
+		return x.err
+	}
+	x.w.WriteString(`</sheetData></worksheet>`)
+	if x.err = x.w.Flush(); x.err != nil {
+		return x.err
+	}
+	x.err = x.zw.Close()
+	return x.err
+}
+
+// serialDate converts the wall clock time of t into a spreadsheet serial
+// date, the number of days since the epoch with the time as fraction.
+func serialDate(t time.Time) string {
+	wall := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.UTC)
+	days := wall.Sub(excelEpoch).Seconds() / (24 * 60 * 60)
+	return strconv.FormatFloat(days, 'f', -1, 64)
+}
+
+// columnName returns the letters of the zero based column index, e.g. "AA" for 26.
+func columnName(i int) string {
+	name := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = string(rune('A'+(i-1)%26)) + name
+	}
+	return name
+}
+
+// moneyFormatCode builds a number format showing the currency symbol on the
+// same side as the money format does. Spreadsheets localise the grouping
+// and decimal separators themselves.
+func moneyFormatCode(f product.MoneyFormat) string {
+	number := "#,##0"
+	if d := f.Currency().Digits; d > 0 {
+		number += "." + strings.Repeat("0", d)
+	}
+
+	// take the symbol and its spacing from a formatted zero
+	zero := f.Format(0)
+	first := strings.IndexAny(zero, "0123456789")
+	last := strings.LastIndexAny(zero, "0123456789")
+	if first < 0 {
+		return number
+	}
+	code := number
+	if prefix := zero[:first]; prefix != "" {
+		code = `"` + prefix + `"` + code
+	}
+	if suffix := zero[last+1:]; suffix != "" {
+		code += `"` + suffix + `"`
+	}
+	return code
+}
+
+// sheetName drops the characters not allowed in sheet names and shortens
+// the name to the maximum of 31 characters.
+func sheetName(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`[]:*?/\`, r) {
+			return -1
+		}
+		return r
+	}, s)
+	if r := []rune(s); len(r) > 31 {
+		s = string(r[:31])
+	}
+	return s
+}
+
+// escape escapes text for use in XML content and attribute values,
+// characters not allowed in XML are replaced.
+func escape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+const contentTypes = `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+	`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+	`<Default Extension="xml" ContentType="application/xml"/>` +
+	`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+	`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+	`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>` +
+	`</Types>`
+
+// styles returns the style sheet, the order of cellXfs matches the style constants.
+func styles(moneyCode string) string {
+	return `<styleSheet xmlns="` + nsMain + `">` +
+		`<numFmts count="2">` +
+		`<numFmt numFmtId="164" formatCode="` + escape(moneyCode) + `"/>` +
+		`<numFmt numFmtId="165" formatCode="yyyy-mm-dd hh:mm"/>` +
+		`</numFmts>` +
+		`<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts>` +
+		`<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>` +
+		`<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>` +
+		`<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>` +
+		`<cellXfs count="4">` +
+		`<xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/>` +
+		`<xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/>` +
+		`<xf numFmtId="164" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>` +
+		`<xf numFmtId="165" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>` +
+		`</cellXfs>` +
+		`<cellStyles count="1"><cellStyle name="Normal" xfId="0" builtinId="0"/></cellStyles>` +
+		`</styleSheet>`
+}
@@ -0,0 +1,94 @@
+// Package flight coalesces concurrent identical calls into a single call
+// whose result is shared by all callers.
+package flight
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// Group runs at most one call per key at a time. Callers asking for a key
+// while its call is running wait for that call instead of starting another.
+type Group[V any] struct {
+	mu       sync.Mutex
+	inflight map[string]*call[V]
+
+	calls atomic.Int64 // calls made
+	saved atomic.Int64 // calls avoided by waiting for a running one
+}
+
+type call[V any] struct {
+	done    chan struct{}
+	value   V
+	err     error
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// Stats counts the calls made and the calls saved by coalescing.
+type Stats struct {
+	Calls int64 `json:"calls"`
+	Saved int64 `json:"saved"`
+}
+
+// Do calls fn for key, or waits for the running call of key. The value is
+// shared between the callers and must not be modified.
+//
+// The call does not run in the context of any single caller: a caller
+// whose context is done stops waiting without affecting the others. Only
+// when every caller has given up is the context of the call cancelled.
+func (g *Group[V]) Do(ctx context.Context, key string, fn func(ctx context.Context) (V, error)) (V, error) {
+	g.mu.Lock()
+	if g.inflight == nil {
+		g.inflight = make(map[string]*call[V])
+	}
+	c, ok := g.inflight[key]
+	if ok {
+		c.waiters++
+		g.saved.Add(1)
+	} else {
+		callCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		c = &call[V]{done: make(chan struct{}), waiters: 1, cancel: cancel}
+		g.inflight[key] = c
+		g.calls.Add(1)
+		go g.run(callCtx, key, c, fn)
+	}
+	g.mu.Unlock()
+
+	select {
+	case <-c.done:
+		return c.value, c.err
+	case <-ctx.Done():
+		g.mu.Lock()
+		c.waiters--
+		if c.waiters == 0 {
+			// nobody wants the result anymore, a new caller starts over
+			c.cancel()
+			if g.inflight[key] == c {
+				delete(g.inflight, key)
+			}
+		}
+		g.mu.Unlock()
+		var zero V
+		return zero, ctx.Err()
+	}
+}
+
+func (g *Group[V]) run(ctx context.Context, key string, c *call[V], fn func(ctx context.Context) (V, error)) {
+	defer c.cancel()
+
+	c.value, c.err = fn(ctx)
+
+	g.mu.Lock()
+	if g.inflight[key] == c {
+		delete(g.inflight, key)
+	}
+	g.mu.Unlock()
+	close(c.done)
+}
+
+// Stats returns the number of calls made and saved so far.
+func (g *Group[V]) Stats() Stats {
+	return Stats{Calls: g.calls.Load(), Saved: g.saved.Load()}
+}
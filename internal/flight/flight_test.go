@@ -0,0 +1,123 @@
+package flight
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDo(t *testing.T) {
+	var g Group[string]
+	var calls atomic.Int32
+	release := make(chan struct{})
+	fn := func(context.Context) (string, error) {
+		calls.Add(1)
+		<-release
+		return "v", nil
+	}
+
+	const n = 10
+	var wg sync.WaitGroup
+	results := make(chan string, n)
+	for range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err := g.Do(context.Background(), "k", fn)
+			if err != nil {
+				t.Error(err)
+			}
+			results <- v
+		}()
+	}
+	// wait until all callers joined the flight
+	for g.Stats().Saved < n-1 {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+	close(results)
+
+	for v := range results {
+		if v != "v" {
+			t.Errorf("want v; got %q", v)
+		}
+	}
+	if c := calls.Load(); c != 1 {
+		t.Errorf("want 1 call; got %d", c)
+	}
+	if s := g.Stats(); s != (Stats{Calls: 1, Saved: n - 1}) {
+		t.Errorf("unexpected stats %+v", s)
+	}
+
+	// a finished flight is not reused
+	release = make(chan struct{})
+	close(release)
+	if _, err := g.Do(context.Background(), "k", fn); err != nil || calls.Load() != 2 {
+		t.Errorf("want a new call; got %d calls, %v", calls.Load(), err)
+	}
+}
+
+func TestDoCancel(t *testing.T) {
+	var g Group[string]
+	started := make(chan struct{})
+	release := make(chan struct{})
+	cancelled := make(chan struct{})
+	fn := func(ctx context.Context) (string, error) {
+		close(started)
+		select {
+		case <-release:
+			return "v", nil
+		case <-ctx.Done():
+			close(cancelled)
+			return "", ctx.Err()
+		}
+	}
+
+	// the first caller gives up, the second still gets the result
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error)
+	go func() {
+		_, err := g.Do(ctx, "k", fn)
+		first <- err
+	}()
+	<-started
+	second := make(chan string)
+	go func() {
+		v, _ := g.Do(context.Background(), "k", fn)
+		second <- v
+	}()
+	for g.Stats().Saved < 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	cancel()
+	if err := <-first; !errors.Is(err, context.Canceled) {
+		t.Errorf("want %v; got %v", context.Canceled, err)
+	}
+	close(release)
+	if v := <-second; v != "v" {
+		t.Errorf("want v; got %q", v)
+	}
+
+	// the call is cancelled once every caller gave up
+	var g2 Group[string]
+	started = make(chan struct{})
+	release = make(chan struct{})
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() {
+		_, err := g2.Do(ctx, "k", fn)
+		first <- err
+	}()
+	<-started
+	cancel()
+	<-first
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Error("want the call cancelled")
+	}
+}
@@ -1,17 +1,20 @@
 package forms
 
-type errors map[string][]string
+import "github.com/tullo/search/internal/i18n"
+
+// errors holds translatable messages, they are rendered in the user's language.
+type errors map[string][]i18n.Message
 
 // Add error messages for a given field to the map.
-func (e errors) Add(field, message string) {
-	e[field] = append(e[field], message)
+func (e errors) Add(field, key string, args ...interface{}) {
+	e[field] = append(e[field], i18n.M(key, args...))
 }
 
 // Get retrieves the first error message for a given field.
-func (e errors) Get(field string) string {
+func (e errors) Get(field string) *i18n.Message {
 	es := e[field]
 	if len(es) == 0 {
-		return ""
+		return nil
 	}
-	return es[0]
+	return &es[0]
 }
@@ -1,11 +1,12 @@
 package forms
 
 import (
-	"fmt"
 	"net/url"
 	"regexp"
 	"strings"
 	"unicode/utf8"
+
+	"github.com/tullo/search/internal/i18n"
 )
 
 // EmailRX is a regular expression for sanity check the email address format.
@@ -26,7 +27,7 @@ type Form struct {
 func New(data url.Values) *Form {
 	return &Form{
 		data,
-		errors(map[string][]string{}),
+		errors(map[string][]i18n.Message{}),
 	}
 }
 
@@ -36,7 +37,7 @@ func (f *Form) Required(fields ...string) {
 	for _, field := range fields {
 		value := f.Get(field)
 		if strings.TrimSpace(value) == "" {
-			f.Errors.Add(field, "This field cannot be blank")
+			f.Errors.Add(field, "form.error.blank")
 		}
 	}
 }
@@ -49,7 +50,7 @@ func (f *Form) MaxLength(field string, d int) {
 		return
 	}
 	if utf8.RuneCountInString(value) > d {
-		f.Errors.Add(field, fmt.Sprintf("This field is too long (maximum is %d characters)", d))
+		f.Errors.Add(field, "form.error.too_long", d)
 	}
 }
 
@@ -65,7 +66,7 @@ func (f *Form) PermittedValues(field string, opts ...string) {
 			return
 		}
 	}
-	f.Errors.Add(field, "This field is invalid")
+	f.Errors.Add(field, "form.error.invalid")
 }
 
 // MinLength checks that a specific field in the form contains a minimum number of characters.
@@ -75,7 +76,7 @@ func (f *Form) MinLength(field string, d int) {
 		return
 	}
 	if utf8.RuneCountInString(value) < d {
-		f.Errors.Add(field, fmt.Sprintf("This field is too short (minimum is %d characters)", d))
+		f.Errors.Add(field, "form.error.too_short", d)
 	}
 }
 
@@ -86,7 +87,7 @@ func (f *Form) MatchesPattern(field string, pattern *regexp.Regexp) {
 		return
 	}
 	if !pattern.MatchString(value) {
-		f.Errors.Add(field, "This field is invalid")
+		f.Errors.Add(field, "form.error.invalid")
 	}
 }
 
@@ -0,0 +1,128 @@
+package fuzzy
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// bases maps the precomposed Latin letters to the letters they are built
+// on. Letters decomposed into a base and combining marks lose their marks
+// without it.
+var bases = map[rune]string{}
+
+func init() {
+	for base, letters := range map[string]string{
+		"a":  "àáâãäåāăąǎǻȁȃȧạảấầẩẫậắằẳẵặ",
+		"c":  "çćĉċč",
+		"d":  "ďđ",
+		"e":  "èéêëēĕėęěȅȇȩẹẻẽếềểễệ",
+		"g":  "ĝğġģǧǵ",
+		"h":  "ĥħ",
+		"i":  "ìíîïĩīĭįıǐȉȋịỉ",
+		"j":  "ĵ",
+		"k":  "ķǩ",
+		"l":  "ĺļľŀł",
+		"n":  "ñńņňǹ",
+		"o":  "òóôõöøōŏőǒǿȍȏȯọỏốồổỗộớờởỡợơ",
+		"r":  "ŕŗřȑȓ",
+		"s":  "śŝşšș",
+		"t":  "ţťŧț",
+		"u":  "ùúûüũūŭůűųǔǖǘǚǜȕȗụủứừửữựư",
+		"w":  "ŵẁẃẅ",
+		"y":  "ýÿŷỳỵỷỹ",
+		"z":  "źżž",
+		"ae": "æǽ",
+		"oe": "œ",
+		"ss": "ß",
+		"th": "þ",
+	} {
+		for _, r := range letters {
+			bases[r] = base
+		}
+	}
+}
+
+// Fold returns s case folded and with the accents stripped, the form names
+// and searches are compared in.
+func Fold(s string) string {
+	var b strings.Builder
+	b.Grow(len(s))
+	for _, r := range s {
+		foldRune(r, func(r rune) { b.WriteRune(r) })
+	}
+	return b.String()
+}
+
+// foldRune hands the folded form of r to emit, nothing for a combining mark.
+func foldRune(r rune, emit func(rune)) {
+	if r < utf8.RuneSelf {
+		emit(unicode.ToLower(r))
+		return
+	}
+	if unicode.Is(unicode.Mn, r) {
+		return
+	}
+	// the upper case first folds ſ to s and ς to σ
+	r = unicode.ToLower(unicode.ToUpper(r))
+	if base, ok := bases[r]; ok {
+		for _, b := range base {
+			emit(b)
+		}
+		return
+	}
+	emit(r)
+}
+
+// folded is a string folded rune by rune. The folded rune i stems from the
+// bytes start[i] to end[i] of the original string, combining marks count
+// to the rune they modify.
+type folded struct {
+	runes      []rune
+	start, end []int
+}
+
+func fold(s string) folded {
+	var f folded
+	for i, r := range s {
+		end := i + utf8.RuneLen(r)
+		if unicode.Is(unicode.Mn, r) {
+			if n := len(f.end); n > 0 && f.end[n-1] == i {
+				// widen the runes of the letter the mark belongs to
+				for j := n - 1; j >= 0 && f.end[j] == i; j-- {
+					f.end[j] = end
+				}
+			}
+			continue
+		}
+		foldRune(r, func(r rune) {
+			f.runes = append(f.runes, r)
+			f.start = append(f.start, i)
+			f.end = append(f.end, end)
+		})
+	}
+	return f
+}
+
+// words returns the ranges of the runes that form words.
+func (f folded) words() [][2]int {
+	var words [][2]int
+	from := -1
+	for i, r := range f.runes {
+		switch {
+		case isWordRune(r) && from < 0:
+			from = i
+		case !isWordRune(r) && from >= 0:
+			words = append(words, [2]int{from, i})
+			from = -1
+		}
+	}
+	if from >= 0 {
+		words = append(words, [2]int{from, len(f.runes)})
+	}
+	return words
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
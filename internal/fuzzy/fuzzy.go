@@ -0,0 +1,198 @@
+// Package fuzzy matches searches against names forgiving typos. Names and
+// searches are compared case folded and without accents, a search term
+// matches a name it is part of or a word of the name it is a few edits
+// away from.
+package fuzzy
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+)
+
+// minSimilarity is how similar a name has to be to a search to be offered
+// in its place, 1 being the same.
+const minSimilarity = 0.5
+
+// Span is a matched range of a name in bytes.
+type Span struct {
+	Start, End int
+}
+
+// Matcher matches the terms of a search. A term may be threshold edits
+// per letter away from a word, 0.25 allows one edit in a four letter
+// term, two in an eight letter one. A threshold of 0 only matches terms
+// that are part of a name.
+type Matcher struct {
+	terms     [][]rune
+	threshold float64
+}
+
+// NewMatcher returns a matcher of the white space separated terms of
+// search.
+func NewMatcher(search string, threshold float64) *Matcher {
+	m := Matcher{threshold: threshold}
+	for _, t := range strings.Fields(Fold(search)) {
+		m.terms = append(m.terms, []rune(t))
+	}
+	return &m
+}
+
+// Match reports whether every term of the search matches name, and where.
+// The spans are ordered and do not overlap.
+func (m *Matcher) Match(name string) ([]Span, bool) {
+	if len(m.terms) == 0 {
+		return nil, true
+	}
+	f := fold(name)
+	var words [][2]int
+	var spans []Span
+	for _, t := range m.terms {
+		found := false
+		for i := 0; i+len(t) <= len(f.runes); {
+			j := index(f.runes[i:], t)
+			if j < 0 {
+				break
+			}
+			i += j
+			spans = append(spans, Span{f.start[i], f.end[i+len(t)-1]})
+			found = true
+			i += len(t)
+		}
+		if found {
+			continue
+		}
+
+		limit := int(m.threshold * float64(len(t)))
+		if limit == 0 {
+			return nil, false
+		}
+		if words == nil {
+			words = f.words()
+		}
+		for _, w := range words {
+			if distance(t, f.runes[w[0]:w[1]], limit) <= limit {
+				spans = append(spans, Span{f.start[w[0]], f.end[w[1]-1]})
+				found = true
+			}
+		}
+		if !found {
+			return nil, false
+		}
+	}
+	return merge(spans), true
+}
+
+// Closest returns up to n of the names most similar to the search, to be
+// offered when it matches none. Names alike when folded are offered once,
+// equally similar names keep their order.
+func (m *Matcher) Closest(names []string, n int) []string {
+	type scored struct {
+		name  string
+		score float64
+	}
+	var candidates []scored
+	seen := make(map[string]bool)
+	for _, name := range names {
+		key := Fold(name)
+		if seen[key] || len(m.terms) == 0 {
+			continue
+		}
+		seen[key] = true
+
+		f := fold(name)
+		var sum float64
+		for _, t := range m.terms {
+			sum += bestSimilarity(t, f)
+		}
+		if score := sum / float64(len(m.terms)); score >= minSimilarity {
+			candidates = append(candidates, scored{name, score})
+		}
+	}
+	slices.SortStableFunc(candidates, func(a, b scored) int {
+		return cmp.Compare(b.score, a.score)
+	})
+
+	closest := []string{}
+	for _, c := range candidates[:min(n, len(candidates))] {
+		closest = append(closest, c.name)
+	}
+	return closest
+}
+
+// bestSimilarity returns how similar term is to the most similar word of
+// f, 1 if it is part of one.
+func bestSimilarity(term []rune, f folded) float64 {
+	var best float64
+	for _, w := range f.words() {
+		word := f.runes[w[0]:w[1]]
+		if index(word, term) >= 0 {
+			return 1
+		}
+		longest := max(len(term), len(word))
+		s := 1 - float64(distance(term, word, longest))/float64(longest)
+		best = max(best, s)
+	}
+	return best
+}
+
+// distance returns the number of insertions, deletions, substitutions and
+// transpositions of adjacent runes turning a into b, or limit+1 if it
+// takes more than limit.
+func distance(a, b []rune, limit int) int {
+	if d := len(a) - len(b); d > limit || -d > limit {
+		return limit + 1
+	}
+	// rows i-2, i-1 and i of the optimal string alignment
+	prev2 := make([]int, len(b)+1)
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		low := cur[0]
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			d := min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				d = min(d, prev2[j-2]+1)
+			}
+			cur[j] = d
+			low = min(low, d)
+		}
+		if low > limit {
+			return limit + 1
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return min(prev[len(b)], limit+1)
+}
+
+// index returns the position of the first sub in s, or -1.
+func index(s, sub []rune) int {
+	for i := 0; i+len(sub) <= len(s); i++ {
+		if slices.Equal(s[i:i+len(sub)], sub) {
+			return i
+		}
+	}
+	return -1
+}
+
+// merge joins overlapping and adjacent spans.
+func merge(spans []Span) []Span {
+	slices.SortFunc(spans, func(a, b Span) int { return cmp.Compare(a.Start, b.Start) })
+	var merged []Span
+	for _, s := range spans {
+		if n := len(merged); n > 0 && s.Start <= merged[n-1].End {
+			merged[n-1].End = max(merged[n-1].End, s.End)
+			continue
+		}
+		merged = append(merged, s)
+	}
+	return merged
+}
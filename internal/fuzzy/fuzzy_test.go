@@ -0,0 +1,114 @@
+package fuzzy
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestFold(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"McDonalds Toys", "mcdonalds toys"},
+		{"Crème Brûlée", "creme brulee"},
+		{"Cre\u0300me", "creme"},
+		{"STRASSE Straße", "strasse strasse"},
+		{"ΣΟΦΟΣ σοφος", "σοφοσ σοφοσ"},
+		{"Ærøskøbing", "aeroskobing"},
+		{"İzmir", "izmir"},
+	}
+
+	for _, tt := range tests {
+		if got := Fold(tt.in); got != tt.want {
+			t.Errorf("Fold(%q): want %q; got %q", tt.in, tt.want, got)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		name      string
+		search    string
+		threshold float64
+		text      string
+		want      []string // matched parts of text, nil for no match
+	}{
+		{"Empty", " ", 0, "Comic Books", []string{}},
+		{"Part", "TOY", 0, "McDonalds Toys", []string{"Toy"}},
+		{"Every occurrence", "o", 0, "Comic Books", []string{"o", "oo"}},
+		{"Terms", "books comic", 0, "Comic Books", []string{"Comic", "Books"}},
+		{"Missing term", "comic toys", 0.25, "Comic Books", nil},
+		{"Exact only", "toyz", 0, "McDonalds Toys", nil},
+		{"Typos", "McDonals Toyz", 0.25, "McDonalds Toys", []string{"McDonalds", "Toys"}},
+		{"Transposition", "cmoic", 0.25, "Comic Books", []string{"Comic"}},
+		{"Too many typos", "tpyz", 0.25, "McDonalds Toys", nil},
+		{"Short term", "toz", 0.25, "McDonalds Toys", nil},
+		{"Accents", "creme", 0, "Crème Brûlée", []string{"Crème"}},
+		{"Combining marks", "creme", 0, "Cre\u0300me", []string{"Cre\u0300me"}},
+		{"Accented search", "brulée", 0, "Creme Brulee", []string{"Brulee"}},
+		{"Expanded letter", "strasse", 0, "Straße", []string{"Straße"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spans, ok := NewMatcher(tt.search, tt.threshold).Match(tt.text)
+			if ok != (tt.want != nil) {
+				t.Fatalf("want match %t; got %t", tt.want != nil, ok)
+			}
+			got := []string{}
+			for _, s := range spans {
+				got = append(got, tt.text[s.Start:s.End])
+			}
+			if ok && !slices.Equal(got, tt.want) {
+				t.Errorf("want %q; got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestClosest(t *testing.T) {
+	names := []string{"Comic Books", "McDonalds Toys", "Board Games", "comic books"}
+
+	tests := []struct {
+		search string
+		n      int
+		want   []string
+	}{
+		{"McDnoalds Tyos", 3, []string{"McDonalds Toys"}},
+		{"comc bok", 3, []string{"Comic Books"}},
+		{"bo", 3, []string{"Comic Books", "Board Games"}},
+		{"bo", 1, []string{"Comic Books"}},
+		{"xylophone", 3, []string{}},
+		{"", 3, []string{}},
+	}
+
+	for _, tt := range tests {
+		got := NewMatcher(tt.search, 0.25).Closest(names, tt.n)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%q: want %q; got %q", tt.search, tt.want, got)
+		}
+	}
+}
+
+func TestDistance(t *testing.T) {
+	tests := []struct {
+		a, b  string
+		limit int
+		want  int
+	}{
+		{"toys", "toys", 2, 0},
+		{"toyz", "toys", 2, 1},
+		{"tyos", "toys", 2, 1},
+		{"mcdonals", "mcdonalds", 2, 1},
+		{"kitten", "sitting", 5, 3},
+		{"kitten", "sitting", 2, 3},
+		{"a", "abcdef", 2, 3},
+		{"", "abc", 5, 3},
+	}
+
+	for _, tt := range tests {
+		if got := distance([]rune(tt.a), []rune(tt.b), tt.limit); got != tt.want {
+			t.Errorf("distance(%q, %q, %d): want %d; got %d", tt.a, tt.b, tt.limit, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,284 @@
+// Package i18n provides message catalogs loaded from files, negotiation of
+// the user's language and locale aware formatting of messages and dates.
+package i18n
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Message is a translatable message: a catalog key and its arguments.
+type Message struct {
+	Key  string
+	Args []interface{}
+}
+
+// M constructs a Message.
+func M(key string, args ...interface{}) Message {
+	return Message{Key: key, Args: args}
+}
+
+// String returns the key, messages are translated by a Localizer.
+func (m Message) String() string {
+	return m.Key
+}
+
+// Catalog holds the messages of all supported languages.
+type Catalog struct {
+	fallback string
+	messages map[string]map[string]string // language -> key -> message
+}
+
+// Load reads one JSON file per language from fsys, e.g. "en.json", holding
+// an object of keys and messages. Messages are fmt format strings.
+// The fallback language must be among them; it is used for missing keys.
+func Load(fsys fs.FS, fallback string) (*Catalog, error) {
+	files, err := fs.Glob(fsys, "*.json")
+	if err != nil {
+		return nil, err
+	}
+
+	c := Catalog{
+		fallback: fallback,
+		messages: make(map[string]map[string]string),
+	}
+	for _, f := range files {
+		b, err := fs.ReadFile(fsys, f)
+		if err != nil {
+			return nil, err
+		}
+		var msgs map[string]string
+		if err := json.Unmarshal(b, &msgs); err != nil {
+			return nil, fmt.Errorf("parsing catalog %s: %w", f, err)
+		}
+		c.messages[strings.ToLower(strings.TrimSuffix(path.Base(f), ".json"))] = msgs
+	}
+
+	if _, ok := c.messages[fallback]; !ok {
+		return nil, fmt.Errorf("no catalog for fallback language %q", fallback)
+	}
+	return &c, nil
+}
+
+// Languages returns the supported languages in alphabetical order.
+func (c *Catalog) Languages() []string {
+	langs := make([]string, 0, len(c.messages))
+	for l := range c.messages {
+		langs = append(langs, l)
+	}
+	sort.Strings(langs)
+	return langs
+}
+
+// Supports reports whether there is a catalog for the language.
+func (c *Catalog) Supports(lang string) bool {
+	_, ok := c.messages[strings.ToLower(lang)]
+	return ok
+}
+
+// Negotiate picks the language for a request: a supported override, such as
+// the choice stored in the user's session, wins over the preferences sent
+// in the Accept-Language header.
+func (c *Catalog) Negotiate(override, acceptLanguage string) string {
+	if c.Supports(override) {
+		return strings.ToLower(override)
+	}
+
+	type pref struct {
+		tag string
+		q   float64
+	}
+	var prefs []pref
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if tag == "" {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = f
+		}
+		if q > 0 {
+			prefs = append(prefs, pref{strings.ToLower(tag), q})
+		}
+	}
+	sort.SliceStable(prefs, func(i, j int) bool { return prefs[i].q > prefs[j].q })
+
+	for _, p := range prefs {
+		if c.Supports(p.tag) {
+			return p.tag
+		}
+		// fall back from a regional variant to the base language
+		if base, _, ok := strings.Cut(p.tag, "-"); ok && c.Supports(base) {
+			return base
+		}
+	}
+	return c.fallback
+}
+
+// Localizer returns the localizer of a language, or of the fallback
+// language if the language is not supported.
+func (c *Catalog) Localizer(lang string) *Localizer {
+	lang = strings.ToLower(lang)
+	if !c.Supports(lang) {
+		lang = c.fallback
+	}
+	return &Localizer{
+		lang:     lang,
+		messages: c.messages[lang],
+		fallback: c.messages[c.fallback],
+	}
+}
+
+// Localizer translates messages into one language and formats dates in
+// the time zone of the user.
+type Localizer struct {
+	lang     string
+	messages map[string]string
+	fallback map[string]string
+	location *time.Location
+}
+
+// In returns a copy of the localizer formatting dates in loc.
+func (l *Localizer) In(loc *time.Location) *Localizer {
+	c := Localizer{location: loc}
+	if l != nil {
+		c.lang, c.messages, c.fallback = l.lang, l.messages, l.fallback
+	}
+	return &c
+}
+
+// Location returns the time zone dates are formatted in, UTC by default.
+func (l *Localizer) Location() *time.Location {
+	if l == nil || l.location == nil {
+		return time.UTC
+	}
+	return l.location
+}
+
+// Lang returns the language of the localizer.
+func (l *Localizer) Lang() string {
+	if l == nil {
+		return ""
+	}
+	return l.lang
+}
+
+// T translates a key and formats the message with the arguments. A key that
+// is not in any catalog is returned as is.
+func (l *Localizer) T(key string, args ...interface{}) string {
+	msg, ok := "", false
+	if l != nil {
+		if msg, ok = l.messages[key]; !ok {
+			msg, ok = l.fallback[key]
+		}
+	}
+	if !ok {
+		msg = key
+	}
+	if len(args) == 0 {
+		return msg
+	}
+	return fmt.Sprintf(msg, args...)
+}
+
+// Message translates a Message.
+func (l *Localizer) Message(m Message) string {
+	return l.T(m.Key, m.Args...)
+}
+
+// defaultDateLayout is used without a localizer or a "date.layout" message.
+const defaultDateLayout = "02 Jan 2006 at 15:04"
+
+// FormatDate formats t in the time zone of the localizer with the layout of
+// the "date.layout" message, a time package layout, replacing the English
+// month abbreviation with the "date.month.1" to "date.month.12" messages.
+func (l *Localizer) FormatDate(t time.Time) string {
+	t = t.In(l.Location())
+	layout := l.T("date.layout")
+	if l == nil || layout == "date.layout" {
+		layout = defaultDateLayout
+	}
+
+	// mark the month so that translated names are not read as layout elements
+	const marker = "\x00"
+	s := t.Format(strings.Replace(layout, "Jan", marker, 1))
+	month := t.Month().String()[:3]
+	if key := fmt.Sprintf("date.month.%d", t.Month()); l != nil {
+		if m := l.T(key); m != key {
+			month = m
+		}
+	}
+	return strings.Replace(s, marker, month, 1)
+}
+
+// Plural translates the ".one" or ".other" variant of key depending on n,
+// which is passed to the message as its first argument.
+func (l *Localizer) Plural(key string, n int, args ...interface{}) string {
+	form := ".other"
+	if n == 1 {
+		form = ".one"
+	}
+	return l.T(key+form, append([]interface{}{n}, args...)...)
+}
+
+// RelativeTime describes t relative to now, e.g. "3 days ago" or "in 2
+// hours". Days, months and years are counted on the calendar of the
+// localizer's time zone, so that a day across a daylight saving time
+// switch is still one day even though it has 23 or 25 hours.
+func (l *Localizer) RelativeTime(t, now time.Time) string {
+	key := "reltime.past."
+	d := now.Sub(t)
+	if d < 0 {
+		key, d = "reltime.future.", -d
+	}
+
+	loc := l.Location()
+	from, to := t.In(loc), now.In(loc)
+	if to.Before(from) {
+		from, to = to, from
+	}
+	days := calendarDays(from, to)
+
+	switch {
+	case d < 45*time.Second:
+		return l.T("reltime.now")
+	case d < 45*time.Minute:
+		return l.Plural(key+"minute", int((d+time.Minute/2)/time.Minute))
+	case days == 0 || d < 12*time.Hour:
+		return l.Plural(key+"hour", int((d+time.Hour/2)/time.Hour))
+	case days < 7:
+		return l.Plural(key+"day", days)
+	case days < 30:
+		return l.Plural(key+"week", days/7)
+	}
+
+	months := (to.Year()-from.Year())*12 + int(to.Month()) - int(from.Month())
+	if to.Day() < from.Day() {
+		months--
+	}
+	if months < 12 {
+		if months < 1 {
+			months = 1
+		}
+		return l.Plural(key+"month", months)
+	}
+	return l.Plural(key+"year", months/12)
+}
+
+// calendarDays counts the midnights between two times of the same location.
+func calendarDays(from, to time.Time) int {
+	a := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.UTC)
+	b := time.Date(to.Year(), to.Month(), to.Day(), 0, 0, 0, 0, time.UTC)
+	return int(b.Sub(a).Hours() / 24)
+}
@@ -0,0 +1,71 @@
+package i18n
+
+import (
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func testCatalog(t *testing.T) *Catalog {
+	fsys := fstest.MapFS{
+		"en.json": {Data: []byte(`{"hello": "Hello %s", "only.en": "English only", "date.layout": "02 Jan 2006 at 15:04"}`)},
+		"de.json": {Data: []byte(`{"hello": "Hallo %s", "date.layout": "02. Jan 2006 um 15:04", "date.month.3": "März"}`)},
+	}
+	c, err := Load(fsys, "en")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestNegotiate(t *testing.T) {
+	c := testCatalog(t)
+
+	tests := []struct {
+		name, override, accept, want string
+	}{
+		{"Default", "", "", "en"},
+		{"Exact", "", "de", "de"},
+		{"Region", "", "de-CH,fr;q=0.8", "de"},
+		{"Quality", "", "fr;q=0.9, en;q=0.5, de;q=0.7", "de"},
+		{"Rejected", "", "de;q=0", "en"},
+		{"Unsupported", "", "fr, ja", "en"},
+		{"Override", "de", "en", "de"},
+		{"Bad override", "xx", "de", "de"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := c.Negotiate(tt.override, tt.accept); got != tt.want {
+				t.Errorf("want %q; got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestLocalizer(t *testing.T) {
+	c := testCatalog(t)
+	de := c.Localizer("de")
+
+	if got := de.T("hello", "Welt"); got != "Hallo Welt" {
+		t.Errorf("want %q; got %q", "Hallo Welt", got)
+	}
+	if got := de.T("only.en"); got != "English only" {
+		t.Errorf("want fallback message; got %q", got)
+	}
+	if got := de.Message(M("missing.key")); got != "missing.key" {
+		t.Errorf("want key for missing message; got %q", got)
+	}
+
+	tm := time.Date(2021, 3, 7, 9, 5, 0, 0, time.UTC)
+	if got := de.FormatDate(tm); got != "07. März 2021 um 09:05" {
+		t.Errorf("want german date; got %q", got)
+	}
+	if got := c.Localizer("en").FormatDate(tm); got != "07 Mar 2021 at 09:05" {
+		t.Errorf("want english date; got %q", got)
+	}
+	var none *Localizer
+	if got := none.FormatDate(tm); got != "07 Mar 2021 at 09:05" {
+		t.Errorf("want default date; got %q", got)
+	}
+}
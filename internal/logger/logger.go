@@ -0,0 +1,92 @@
+// Package logger provides the structured, leveled logger used throughout the
+// application and the helpers to carry it through a request context.
+package logger
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Supported output formats.
+const (
+	FormatJSON   = "json"
+	FormatLogfmt = "logfmt"
+)
+
+// New constructs a logger writing records in the given format ("json" or
+// "logfmt") at or above the given level ("debug", "info", "warn", "error").
+// Every record logged with a context carrying an OpenTelemetry span is
+// annotated with the trace and span IDs.
+func New(w io.Writer, format, level string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("parsing log level %q: %w", level, err)
+	}
+
+	opts := slog.HandlerOptions{
+		AddSource: true,
+		Level:     lvl,
+	}
+
+	var h slog.Handler
+	switch strings.ToLower(format) {
+	case FormatJSON:
+		h = slog.NewJSONHandler(w, &opts)
+	case FormatLogfmt:
+		h = slog.NewTextHandler(w, &opts)
+	default:
+		return nil, fmt.Errorf("unsupported log format %q", format)
+	}
+
+	return slog.New(traceHandler{h}), nil
+}
+
+// traceHandler decorates records with the IDs of the span found in the
+// context passed to the logging call.
+type traceHandler struct {
+	slog.Handler
+}
+
+// Handle adds the trace_id and span_id attributes when ctx holds a valid span.
+func (h traceHandler) Handle(ctx context.Context, r slog.Record) error {
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		r.AddAttrs(
+			slog.String("trace_id", sc.TraceID().String()),
+			slog.String("span_id", sc.SpanID().String()),
+		)
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+// WithAttrs keeps the trace decoration on derived handlers.
+func (h traceHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return traceHandler{h.Handler.WithAttrs(attrs)}
+}
+
+// WithGroup keeps the trace decoration on derived handlers.
+func (h traceHandler) WithGroup(name string) slog.Handler {
+	return traceHandler{h.Handler.WithGroup(name)}
+}
+
+// the key must be unexported type to avoid collisions
+type ctxKey int
+
+const loggerKey ctxKey = 1
+
+// NewContext returns a copy of ctx carrying the logger.
+func NewContext(ctx context.Context, log *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey, log)
+}
+
+// FromContext returns the logger stored in ctx, or fallback if there is none.
+func FromContext(ctx context.Context, fallback *slog.Logger) *slog.Logger {
+	if log, ok := ctx.Value(loggerKey).(*slog.Logger); ok {
+		return log
+	}
+	return fallback
+}
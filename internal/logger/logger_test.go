@@ -0,0 +1,65 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTraceCorrelation(t *testing.T) {
+	var buf bytes.Buffer
+	log, err := New(&buf, FormatJSON, "info")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sc := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID: trace.TraceID{0x01, 0x02},
+		SpanID:  trace.SpanID{0x03},
+	})
+	ctx := trace.ContextWithSpanContext(context.Background(), sc)
+
+	log.With("request_id", "abc").InfoContext(ctx, "hello")
+
+	var rec map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &rec); err != nil {
+		t.Fatal(err)
+	}
+	if rec["trace_id"] != sc.TraceID().String() {
+		t.Errorf("want trace_id %q; got %v", sc.TraceID(), rec["trace_id"])
+	}
+	if rec["span_id"] != sc.SpanID().String() {
+		t.Errorf("want span_id %q; got %v", sc.SpanID(), rec["span_id"])
+	}
+	if rec["request_id"] != "abc" {
+		t.Errorf("want request_id %q; got %v", "abc", rec["request_id"])
+	}
+}
+
+func TestLevels(t *testing.T) {
+	var buf bytes.Buffer
+	log, err := New(&buf, FormatLogfmt, "warn")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	log.Info("dropped")
+	if buf.Len() != 0 {
+		t.Errorf("want info record to be dropped; got %q", buf.String())
+	}
+
+	log.Warn("kept")
+	if !bytes.Contains(buf.Bytes(), []byte("msg=kept")) {
+		t.Errorf("want logfmt record; got %q", buf.String())
+	}
+
+	if _, err := New(&buf, "xml", "info"); err == nil {
+		t.Error("want error for unsupported format")
+	}
+	if _, err := New(&buf, FormatJSON, "loud"); err == nil {
+		t.Error("want error for unsupported level")
+	}
+}
@@ -0,0 +1,57 @@
+package product
+
+// ChangeOp is the operation a Change describes.
+type ChangeOp string
+
+// The operations of a Change.
+const (
+	Created ChangeOp = "created"
+	Updated ChangeOp = "updated"
+	Deleted ChangeOp = "deleted"
+)
+
+// Change is a difference between two snapshots of the product set. The
+// product of a deletion is the last one seen.
+type Change struct {
+	Op      ChangeOp `json:"op"`
+	Product Product  `json:"product"`
+}
+
+// Diff returns the changes turning the products old into the products new:
+// the products new in the order of new, the updated ones in the order of new
+// and the deleted ones in the order of old.
+func Diff(old, new []Product) []Change {
+	before := make(map[string]*Product, len(old))
+	for i := range old {
+		before[old[i].ID] = &old[i]
+	}
+
+	var changes []Change
+	seen := make(map[string]bool, len(new))
+	for _, p := range new {
+		seen[p.ID] = true
+		switch o, ok := before[p.ID]; {
+		case !ok:
+			changes = append(changes, Change{Op: Created, Product: p})
+		case !same(o, &p):
+			changes = append(changes, Change{Op: Updated, Product: p})
+		}
+	}
+	for _, p := range old {
+		if !seen[p.ID] {
+			changes = append(changes, Change{Op: Deleted, Product: p})
+		}
+	}
+	return changes
+}
+
+// same reports whether nothing shown of a product changed.
+func same(a, b *Product) bool {
+	return a.Name == b.Name &&
+		a.Cost == b.Cost &&
+		a.Quantity == b.Quantity &&
+		a.Sold == b.Sold &&
+		a.Revenue == b.Revenue &&
+		a.UserID == b.UserID &&
+		a.DateUpdated.Equal(b.DateUpdated)
+}
@@ -0,0 +1,55 @@
+package product
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDiff(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2021, 3, d, 0, 0, 0, 0, time.UTC) }
+	old := []Product{
+		{ID: "1", Name: "McDonalds Toys", Sold: 3, DateUpdated: day(1)},
+		{ID: "2", Name: "Comic Books", Sold: 7, DateUpdated: day(1)},
+		{ID: "3", Name: "Board Games", Sold: 1, DateUpdated: day(1)},
+	}
+
+	tests := []struct {
+		name string
+		new  []Product
+		want []Change
+	}{
+		{"Unchanged", old, nil},
+		{"Same time in another zone", []Product{
+			old[0], old[1], {ID: "3", Name: "Board Games", Sold: 1, DateUpdated: day(1).In(time.FixedZone("CET", 3600))},
+		}, nil},
+		{"Created", append(old[:3:3], Product{ID: "4", Name: "Puzzles"}), []Change{
+			{Op: Created, Product: Product{ID: "4", Name: "Puzzles"}},
+		}},
+		{"Sold", []Product{old[0], {ID: "2", Name: "Comic Books", Sold: 8, DateUpdated: day(2)}, old[2]}, []Change{
+			{Op: Updated, Product: Product{ID: "2", Name: "Comic Books", Sold: 8, DateUpdated: day(2)}},
+		}},
+		{"Deleted", []Product{old[1]}, []Change{
+			{Op: Deleted, Product: old[0]},
+			{Op: Deleted, Product: old[2]},
+		}},
+		{"All deleted", nil, []Change{
+			{Op: Deleted, Product: old[0]},
+			{Op: Deleted, Product: old[1]},
+			{Op: Deleted, Product: old[2]},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Diff(old, tt.new)
+			if len(got) != len(tt.want) {
+				t.Fatalf("want %d changes; got %v", len(tt.want), got)
+			}
+			for i := range got {
+				if got[i].Op != tt.want[i].Op || !same(&got[i].Product, &tt.want[i].Product) || got[i].Product.ID != tt.want[i].Product.ID {
+					t.Errorf("change %d: want %v; got %v", i, tt.want[i], got[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,266 @@
+package product
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"unicode"
+)
+
+// Money is an amount in the minor unit of a currency, e.g. cents.
+type Money int64
+
+// ErrOverflow is returned when an amount does not fit into Money.
+var ErrOverflow = errors.New("amount out of range")
+
+// Sum adds up amounts without losing precision.
+func Sum(amounts ...Money) (Money, error) {
+	var total Money
+	for _, m := range amounts {
+		if (m > 0 && total > math.MaxInt64-m) || (m < 0 && total < math.MinInt64-m) {
+			return 0, ErrOverflow
+		}
+		total += m
+	}
+	return total, nil
+}
+
+// Mul multiplies an amount by a quantity without losing precision.
+func (m Money) Mul(n int) (Money, error) {
+	if m == 0 || n == 0 {
+		return 0, nil
+	}
+	r := m * Money(n)
+	if r/Money(n) != m || (m == -1 && Money(n) == math.MinInt64) || (n == -1 && m == math.MinInt64) {
+		return 0, ErrOverflow
+	}
+	return r, nil
+}
+
+// Currency describes how amounts of a currency are written.
+type Currency struct {
+	Code   string // ISO 4217 code
+	Symbol string
+	Digits int // number of minor unit digits
+}
+
+var currencies = map[string]Currency{
+	"USD": {"USD", "$", 2},
+	"EUR": {"EUR", "€", 2},
+	"GBP": {"GBP", "£", 2},
+	"CHF": {"CHF", "CHF", 2},
+	"DKK": {"DKK", "kr.", 2},
+	"SEK": {"SEK", "kr", 2},
+	"NOK": {"NOK", "kr", 2},
+	"JPY": {"JPY", "¥", 0},
+}
+
+// numberFormat describes how numbers and amounts are written in a locale.
+type numberFormat struct {
+	decimal     string
+	group       string
+	symbolFirst bool // symbol before the amount
+	symbolSpace bool // space between the symbol and the amount
+}
+
+var locales = map[string]numberFormat{
+	"en-US": {".", ",", true, false},
+	"en-GB": {".", ",", true, false},
+	"de-DE": {",", ".", false, true},
+	"de-AT": {",", " ", true, true},
+	"de-CH": {".", "’", true, true},
+	"fr-FR": {",", " ", false, true},
+	"da-DK": {",", ".", false, true},
+	"ja-JP": {".", ",", true, false},
+}
+
+// baseLocales maps a bare language to the locale used for it.
+var baseLocales = map[string]string{
+	"en": "en-US",
+	"de": "de-DE",
+	"fr": "fr-FR",
+	"da": "da-DK",
+	"ja": "ja-JP",
+}
+
+// MoneyFormat formats and parses amounts of one currency in one locale.
+type MoneyFormat struct {
+	cur    Currency
+	num    numberFormat
+	locale string
+}
+
+// NewMoneyFormat constructs the format for a currency code and locale tag
+// such as "en-US". A bare language such as "de" picks its default region.
+func NewMoneyFormat(currency, locale string) (MoneyFormat, error) {
+	cur, ok := currencies[strings.ToUpper(currency)]
+	if !ok {
+		return MoneyFormat{}, fmt.Errorf("unsupported currency %q", currency)
+	}
+	locale = strings.ReplaceAll(locale, "_", "-")
+	num, ok := locales[locale]
+	if !ok {
+		num, ok = locales[baseLocales[strings.ToLower(locale)]]
+	}
+	if !ok {
+		return MoneyFormat{}, fmt.Errorf("unsupported locale %q", locale)
+	}
+	return MoneyFormat{cur: cur, num: num, locale: locale}, nil
+}
+
+// Currency returns the currency of the format.
+func (f MoneyFormat) Currency() Currency {
+	return f.cur
+}
+
+// Locale returns the locale tag the format was constructed with.
+func (f MoneyFormat) Locale() string {
+	return f.locale
+}
+
+// Format writes an amount with currency symbol, grouping and decimals.
+func (f MoneyFormat) Format(m Money) string {
+	neg := m < 0
+	// work on the unsigned value so that the minimum amount formats too
+	u := uint64(m)
+	if neg {
+		u = -u
+	}
+
+	unit := uint64(1)
+	for i := 0; i < f.cur.Digits; i++ {
+		unit *= 10
+	}
+	whole, frac := u/unit, u%unit
+
+	digits := fmt.Sprint(whole)
+	var b strings.Builder
+	for i, d := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			b.WriteString(f.num.group)
+		}
+		b.WriteRune(d)
+	}
+	if f.cur.Digits > 0 {
+		fmt.Fprintf(&b, "%s%0*d", f.num.decimal, f.cur.Digits, frac)
+	}
+	number := b.String()
+
+	sep := ""
+	if f.num.symbolSpace {
+		sep = " "
+	}
+	var s string
+	if f.num.symbolFirst {
+		s = f.cur.Symbol + sep + number
+	} else {
+		s = number + sep + f.cur.Symbol
+	}
+	if neg {
+		return "-" + s
+	}
+	return s
+}
+
+// Decimal writes an amount as a plain decimal number, e.g. "1500.00", as
+// expected by spreadsheets and other machine readers.
+func (f MoneyFormat) Decimal(m Money) string {
+	neg := m < 0
+	u := uint64(m)
+	if neg {
+		u = -u
+	}
+	unit := uint64(1)
+	for i := 0; i < f.cur.Digits; i++ {
+		unit *= 10
+	}
+	s := fmt.Sprint(u / unit)
+	if f.cur.Digits > 0 {
+		s = fmt.Sprintf("%s.%0*d", s, f.cur.Digits, u%unit)
+	}
+	if neg {
+		return "-" + s
+	}
+	return s
+}
+
+// Parse reads an amount as entered by a user, e.g. "15,00 €", "$1,500.00"
+// or "1500". The currency symbol or code is optional. When the separators
+// are ambiguous the conventions of the locale decide.
+func (f MoneyFormat) Parse(s string) (Money, error) {
+	in := s
+	s = strings.TrimSpace(s)
+	s = strings.ReplaceAll(s, f.cur.Code, "")
+	s = strings.ReplaceAll(s, f.cur.Symbol, "")
+	s = strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) || r == ' ' || r == '\'' || r == '’' {
+			return -1
+		}
+		return r
+	}, s)
+
+	neg := false
+	switch {
+	case strings.HasPrefix(s, "-"):
+		neg, s = true, s[1:]
+	case strings.HasPrefix(s, "(") && strings.HasSuffix(s, ")"):
+		neg, s = true, s[1:len(s)-1]
+	}
+	if s == "" {
+		return 0, fmt.Errorf("invalid amount %q", in)
+	}
+
+	whole, frac := s, ""
+	if i := f.decimalIndex(s); i >= 0 {
+		whole, frac = s[:i], s[i+1:]
+	}
+	whole = strings.NewReplacer(".", "", ",", "").Replace(whole)
+	if len(frac) > f.cur.Digits {
+		return 0, fmt.Errorf("invalid amount %q: too many decimals", in)
+	}
+	frac += strings.Repeat("0", f.cur.Digits-len(frac))
+
+	var m Money
+	for _, r := range whole + frac {
+		if r < '0' || r > '9' {
+			return 0, fmt.Errorf("invalid amount %q", in)
+		}
+		if m > (math.MaxInt64-Money(r-'0'))/10 {
+			return 0, ErrOverflow
+		}
+		m = m*10 + Money(r-'0')
+	}
+	if neg {
+		m = -m
+	}
+	return m, nil
+}
+
+// decimalIndex finds the decimal separator in a number without spaces, or
+// returns -1 if the number has no fractional part.
+func (f MoneyFormat) decimalIndex(s string) int {
+	last := strings.LastIndexAny(s, ".,")
+	if last < 0 {
+		return -1
+	}
+	sep := s[last]
+
+	// with both separators present the last one is the decimal separator
+	if strings.ContainsAny(s[:last], map[byte]string{'.': ",", ',': "."}[sep]) {
+		return last
+	}
+	// a separator used more than once is a grouping separator
+	if strings.Count(s, string(sep)) > 1 {
+		return -1
+	}
+	// only a group of three digits is ambiguous, the locale decides, a
+	// currency without minor units only groups
+	if len(s)-last-1 != 3 {
+		return last
+	}
+	if f.cur.Digits > 0 && string(sep) == f.num.decimal {
+		return last
+	}
+	return -1
+}
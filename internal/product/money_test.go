@@ -0,0 +1,116 @@
+package product
+
+import (
+	"math"
+	"testing"
+)
+
+func TestMoneyFormat(t *testing.T) {
+	tests := []struct {
+		currency, locale string
+		amount           Money
+		want             string
+	}{
+		{"USD", "en-US", 1500, "$15.00"},
+		{"USD", "en-US", 123456789, "$1,234,567.89"},
+		{"USD", "en", -5, "-$0.05"},
+		{"EUR", "de-DE", 150000, "1.500,00 €"},
+		{"EUR", "fr-FR", 150000, "1 500,00 €"},
+		{"CHF", "de-CH", 150000, "CHF 1’500.00"},
+		{"JPY", "ja-JP", 1500, "¥1,500"},
+		{"USD", "en-US", math.MinInt64, "-$92,233,720,368,547,758.08"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.currency+"/"+tt.locale, func(t *testing.T) {
+			f, err := NewMoneyFormat(tt.currency, tt.locale)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := f.Format(tt.amount); got != tt.want {
+				t.Errorf("want %q; got %q", tt.want, got)
+			}
+		})
+	}
+
+	if _, err := NewMoneyFormat("XXX", "en-US"); err == nil {
+		t.Error("want error for unsupported currency")
+	}
+	if _, err := NewMoneyFormat("USD", "tlh"); err == nil {
+		t.Error("want error for unsupported locale")
+	}
+}
+
+func TestMoneyParse(t *testing.T) {
+	tests := []struct {
+		currency, locale string
+		in               string
+		want             Money
+		wantErr          bool
+	}{
+		{"EUR", "de-DE", "15,00 €", 1500, false},
+		{"EUR", "de-DE", "1.500", 150000, false},
+		{"EUR", "de-DE", "1.500,5", 150050, false},
+		{"EUR", "de-DE", "EUR 2.000.000", 200000000, false},
+		{"USD", "en-US", "$1,500.00", 150000, false},
+		{"USD", "en-US", "1,500", 150000, false},
+		{"USD", "en-US", "15,00", 1500, false},
+		{"USD", "en-US", "15", 1500, false},
+		{"USD", "en-US", "-$0.05", -5, false},
+		{"USD", "en-US", "($3.10)", -310, false},
+		{"CHF", "de-CH", "CHF 1'500.25", 150025, false},
+		{"JPY", "ja-JP", "¥1,500", 1500, false},
+		{"JPY", "ja-JP", "1.500", 1500, false},
+		{"JPY", "ja-JP", "1500", 1500, false},
+		{"JPY", "ja-JP", "15.5", 0, true},
+		{"JPY", "ja-JP", "15,50", 0, true},
+		{"JPY", "ja-JP", "¥1,500.5", 0, true},
+		{"USD", "en-US", "1.005", 0, true},
+		{"USD", "en-US", "12a", 0, true},
+		{"USD", "en-US", "", 0, true},
+		{"USD", "en-US", "99999999999999999999", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.locale+"/"+tt.in, func(t *testing.T) {
+			f, err := NewMoneyFormat(tt.currency, tt.locale)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := f.Parse(tt.in)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("want error; got %d", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("want %d; got %d", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestMoneyArithmetic(t *testing.T) {
+	total, err := Sum(1500, 250, -50)
+	if err != nil || total != 1700 {
+		t.Errorf("want 1700; got %d (%v)", total, err)
+	}
+	if _, err := Sum(math.MaxInt64, 1); err != ErrOverflow {
+		t.Errorf("want overflow; got %v", err)
+	}
+	if _, err := Sum(math.MinInt64, -1); err != ErrOverflow {
+		t.Errorf("want overflow; got %v", err)
+	}
+
+	m, err := Money(1999).Mul(3)
+	if err != nil || m != 5997 {
+		t.Errorf("want 5997; got %d (%v)", m, err)
+	}
+	if _, err := Money(math.MaxInt64 / 2).Mul(3); err != ErrOverflow {
+		t.Errorf("want overflow; got %v", err)
+	}
+}
@@ -0,0 +1,125 @@
+package product
+
+import (
+	"cmp"
+	"errors"
+	"html/template"
+	"slices"
+	"strings"
+
+	"github.com/tullo/search/internal/fuzzy"
+	"github.com/tullo/search/internal/sanitize"
+)
+
+// ErrUnknownOrder is returned for a sort order on a field that cannot be sorted.
+var ErrUnknownOrder = errors.New("unknown sort order")
+
+// orders maps the fields a listing can be sorted by to their comparison.
+var orders = map[string]func(a, b *Product) int{
+	"name":     func(a, b *Product) int { return cmp.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name)) },
+	"cost":     func(a, b *Product) int { return cmp.Compare(a.Cost, b.Cost) },
+	"quantity": func(a, b *Product) int { return cmp.Compare(a.Quantity, b.Quantity) },
+	"sold":     func(a, b *Product) int { return cmp.Compare(a.Sold, b.Sold) },
+	"revenue":  func(a, b *Product) int { return cmp.Compare(a.Revenue, b.Revenue) },
+	"created":  func(a, b *Product) int { return a.DateCreated.Compare(b.DateCreated) },
+	"updated":  func(a, b *Product) int { return a.DateUpdated.Compare(b.DateUpdated) },
+}
+
+// Query filters and orders a product listing. The zero value keeps every
+// product in the order of the sales-api.
+type Query struct {
+	Search    string  // terms of the name, see fuzzy.Matcher
+	Order     string  // field to sort by, prefixed with "-" for descending order
+	Fuzziness float64 // edits per letter a term may be off a word of the name
+
+	// m matches the search of a query made by NewQuery, other queries
+	// parse it for every call. A query for another search is made anew.
+	m *fuzzy.Matcher
+}
+
+// NewQuery returns a validated query whose search is parsed once for all
+// products it is matched against.
+func NewQuery(search, order string, fuzziness float64) (Query, error) {
+	q := Query{Search: search, Order: order, Fuzziness: fuzziness}
+	if err := q.Validate(); err != nil {
+		return Query{}, err
+	}
+	q.m = q.matcher()
+	return q, nil
+}
+
+// Validate checks that the order names a sortable field.
+func (q Query) Validate() error {
+	if q.Order == "" {
+		return nil
+	}
+	if _, ok := orders[strings.TrimPrefix(q.Order, "-")]; !ok {
+		return ErrUnknownOrder
+	}
+	return nil
+}
+
+// IsZero reports whether the query neither filters nor orders.
+func (q Query) IsZero() bool {
+	return q.Search == "" && q.Order == ""
+}
+
+// Match reports whether the product passes the filter. The search is
+// matched against the name as the listing shows it.
+func (q Query) Match(p *Product) bool {
+	if q.Search == "" {
+		return true
+	}
+	_, ok := q.matcher().Match(sanitize.Inline.Text(p.Name))
+	return ok
+}
+
+// NameHTML renders the name of the product like Product.NameHTML, with the
+// parts matching the search marked.
+func (q Query) NameHTML(p *Product) template.HTML {
+	if q.Search == "" {
+		return p.NameHTML()
+	}
+	spans, ok := q.matcher().Match(sanitize.Inline.Text(p.Name))
+	if !ok {
+		return p.NameHTML()
+	}
+	marks := make([][2]int, len(spans))
+	for i, s := range spans {
+		marks[i] = [2]int{s.Start, s.End}
+	}
+	return sanitize.Inline.Highlight(p.Name, marks)
+}
+
+// DidYouMean returns up to n names of the products most similar to the
+// search, to be offered when it matches none of them.
+func (q Query) DidYouMean(ps []Product, n int) []string {
+	names := make([]string, len(ps))
+	for i := range ps {
+		names[i] = sanitize.Inline.Text(ps[i].Name)
+	}
+	return q.matcher().Closest(names, n)
+}
+
+func (q Query) matcher() *fuzzy.Matcher {
+	if q.m != nil {
+		return q.m
+	}
+	return fuzzy.NewMatcher(q.Search, q.Fuzziness)
+}
+
+// Sort orders the products in place. Products that compare equal keep
+// their relative order.
+func (q Query) Sort(ps []Product) {
+	compare, ok := orders[strings.TrimPrefix(q.Order, "-")]
+	if !ok {
+		return
+	}
+	desc := strings.HasPrefix(q.Order, "-")
+	slices.SortStableFunc(ps, func(a, b Product) int {
+		if desc {
+			return compare(&b, &a)
+		}
+		return compare(&a, &b)
+	})
+}
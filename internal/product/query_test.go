@@ -0,0 +1,95 @@
+package product
+
+import (
+	"html/template"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestQuery(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2021, 3, d, 0, 0, 0, 0, time.UTC) }
+	products := []Product{
+		{ID: "1", Name: "McDonalds Toys", Cost: 75, Sold: 3, DateCreated: day(2)},
+		{ID: "2", Name: "comic Books", Cost: 50, Sold: 7, DateCreated: day(1)},
+		{ID: "3", Name: "Board Games", Cost: 75, Sold: 1, DateCreated: day(3)},
+	}
+
+	tests := []struct {
+		name  string
+		query Query
+		want  string
+	}{
+		{"Zero", Query{}, "123"},
+		{"Search", Query{Search: " TOY"}, "1"},
+		{"Name ignores case", Query{Order: "name"}, "321"},
+		{"Descending", Query{Order: "-sold"}, "213"},
+		{"Stable", Query{Order: "cost"}, "213"},
+		{"Stable descending", Query{Order: "-cost"}, "132"},
+		{"Created", Query{Order: "created"}, "213"},
+		{"Search and order", Query{Search: "o", Order: "-created"}, "312"},
+		{"Typo", Query{Search: "McDonals Toyz", Fuzziness: 0.25}, "1"},
+		{"Typo without fuzziness", Query{Search: "toyz"}, ""},
+		{"Accents", Query{Search: "bóard"}, "3"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parsed, err := NewQuery(tt.query.Search, tt.query.Order, tt.query.Fuzziness)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, q := range []Query{tt.query, parsed} {
+				var got []Product
+				for _, p := range products {
+					if q.Match(&p) {
+						got = append(got, p)
+					}
+				}
+				q.Sort(got)
+
+				ids := ""
+				for _, p := range got {
+					ids += p.ID
+				}
+				if ids != tt.want {
+					t.Errorf("want %s; got %s", tt.want, ids)
+				}
+			}
+		})
+	}
+
+	if _, err := NewQuery("", "-user_id", 0); err != ErrUnknownOrder {
+		t.Errorf("want %v; got %v", ErrUnknownOrder, err)
+	}
+}
+
+func TestQueryNameHTML(t *testing.T) {
+	p := Product{Name: "Comic <b>Books</b> &amp; Toys"}
+
+	tests := []struct {
+		name  string
+		query Query
+		want  template.HTML
+	}{
+		{"No search", Query{}, "Comic <b>Books</b> &amp; Toys"},
+		{"Search", Query{Search: "book toy"}, "Comic <b><mark>Book</mark>s</b> &amp; <mark>Toy</mark>s"},
+		{"Typo", Query{Search: "bokos", Fuzziness: 0.25}, "Comic <b><mark>Books</mark></b> &amp; Toys"},
+		{"Markup", Query{Search: "<b>"}, "Comic <b>Books</b> &amp; Toys"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.query.NameHTML(&p); got != tt.want {
+				t.Errorf("want %q; got %q", tt.want, got)
+			}
+		})
+	}
+
+	ps := []Product{{Name: "McDonalds Toys"}, {Name: "Comic <i>Books</i>"}}
+	q := Query{Search: "komik", Fuzziness: 0.25}
+	if got := q.DidYouMean(ps, 3); !slices.Equal(got, []string{"Comic Books"}) {
+		t.Errorf("want [Comic Books]; got %q", got)
+	}
+}
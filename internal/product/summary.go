@@ -0,0 +1,82 @@
+package product
+
+import (
+	"cmp"
+	"slices"
+	"sort"
+)
+
+// Stock returns the number of items left.
+func (p *Product) Stock() int {
+	return p.Quantity - p.Sold
+}
+
+// Summary aggregates a product set one product at a time, so that the set
+// does not have to be held in memory.
+type Summary struct {
+	Products int   // number of products
+	Revenue  Money // total revenue
+	Sold     int   // total units sold
+	Stock    int   // total units left
+
+	LowStock  int // threshold of the low stock list
+	TopSize   int // length of the top lists
+	ByRevenue []Product
+	BySold    []Product
+	Newest    []Product
+	Low       []Product // products with stock at or below LowStock, lowest first
+	SoldOut   []Product // products without stock, in the order added
+}
+
+// NewSummary returns an empty summary keeping the top n products and
+// listing the products with at most lowStock units left.
+func NewSummary(lowStock, n int) *Summary {
+	return &Summary{LowStock: lowStock, TopSize: n}
+}
+
+// Add adds a product to the summary. It fails if the total revenue does
+// not fit into Money.
+func (s *Summary) Add(p Product) error {
+	revenue, err := Sum(s.Revenue, p.Revenue)
+	if err != nil {
+		return err
+	}
+	s.Revenue = revenue
+	s.Products++
+	s.Sold += p.Sold
+
+	stock := p.Stock()
+	switch {
+	case stock <= 0:
+		s.SoldOut = append(s.SoldOut, p)
+	case stock <= s.LowStock:
+		i := sort.Search(len(s.Low), func(i int) bool { return s.Low[i].Stock() > stock })
+		s.Low = slices.Insert(s.Low, i, p)
+		s.Stock += stock
+	default:
+		s.Stock += stock
+	}
+
+	s.ByRevenue = top(s.ByRevenue, p, s.TopSize, func(a, b *Product) int { return cmp.Compare(a.Revenue, b.Revenue) })
+	s.BySold = top(s.BySold, p, s.TopSize, func(a, b *Product) int { return cmp.Compare(a.Sold, b.Sold) })
+	s.Newest = top(s.Newest, p, s.TopSize, func(a, b *Product) int { return a.DateCreated.Compare(b.DateCreated) })
+
+	return nil
+}
+
+// top inserts p into the list of the n greatest products, greatest first.
+// Products comparing equal keep the order they were added in.
+func top(list []Product, p Product, n int, compare func(a, b *Product) int) []Product {
+	i := len(list)
+	for i > 0 && compare(&p, &list[i-1]) > 0 {
+		i--
+	}
+	if i >= n {
+		return list
+	}
+	list = slices.Insert(list, i, p)
+	if len(list) > n {
+		list = list[:n]
+	}
+	return list
+}
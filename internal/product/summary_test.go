@@ -0,0 +1,57 @@
+package product
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSummary(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2021, 3, d, 0, 0, 0, 0, time.UTC) }
+	products := []Product{
+		{ID: "a", Quantity: 100, Sold: 10, Revenue: 1000, DateCreated: day(1)},
+		{ID: "b", Quantity: 10, Sold: 8, Revenue: 4000, DateCreated: day(5)},
+		{ID: "c", Quantity: 5, Sold: 5, Revenue: 500, DateCreated: day(3)},
+		{ID: "d", Quantity: 20, Sold: 19, Revenue: 1900, DateCreated: day(2)},
+		{ID: "e", Quantity: 3, Sold: 4, Revenue: 400, DateCreated: day(4)},
+		{ID: "f", Quantity: 50, Sold: 48, Revenue: 4000, DateCreated: day(6)},
+	}
+
+	s := NewSummary(2, 3)
+	for _, p := range products {
+		if err := s.Add(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if s.Products != 6 || s.Revenue != 11800 || s.Sold != 94 || s.Stock != 95 {
+		t.Errorf("unexpected totals %d, %d, %d, %d", s.Products, s.Revenue, s.Sold, s.Stock)
+	}
+
+	ids := func(ps []Product) string {
+		s := ""
+		for _, p := range ps {
+			s += p.ID
+		}
+		return s
+	}
+	tests := []struct {
+		name string
+		list []Product
+		want string
+	}{
+		{"By revenue", s.ByRevenue, "bfd"},
+		{"By units sold", s.BySold, "fda"},
+		{"Newest", s.Newest, "fbe"},
+		{"Low stock", s.Low, "dbf"},
+		{"Sold out", s.SoldOut, "ce"},
+	}
+	for _, tt := range tests {
+		if got := ids(tt.list); got != tt.want {
+			t.Errorf("%s: want %s; got %s", tt.name, tt.want, got)
+		}
+	}
+
+	if err := s.Add(Product{Revenue: 1<<63 - 1}); err != ErrOverflow {
+		t.Errorf("want %v; got %v", ErrOverflow, err)
+	}
+}
@@ -0,0 +1,207 @@
+// Package quality checks product records for inconsistent data. The checks
+// are a list of rules, callers pick the rules they want and can add their own.
+package quality
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/tullo/search/internal/i18n"
+	"github.com/tullo/search/internal/product"
+)
+
+// Rule is a check of a single product. Check returns a message describing
+// the problem if the product breaks the rule, nil otherwise. An error means
+// the rule could not be checked.
+type Rule struct {
+	Name  string
+	Check func(ctx context.Context, p *product.Product) (*i18n.Message, error)
+}
+
+// Violation is a product breaking a rule.
+type Violation struct {
+	Rule    string
+	Product product.Product
+	Message i18n.Message
+}
+
+// Text describes the violation in the language of the localizer. Amounts
+// among the message arguments are written in the money format.
+func (v Violation) Text(l *i18n.Localizer, mf product.MoneyFormat) string {
+	args := make([]interface{}, len(v.Message.Args))
+	for i, a := range v.Message.Args {
+		if m, ok := a.(product.Money); ok {
+			a = mf.Format(m)
+		}
+		args[i] = a
+	}
+	return l.T(v.Message.Key, args...)
+}
+
+// Unchecked is a rule that could not be checked for a product.
+type Unchecked struct {
+	Rule    string
+	Product product.Product
+	Err     error
+}
+
+// Checker runs rules against products and collects the violations and the
+// rules that could not be checked.
+type Checker struct {
+	rules      []Rule
+	Checked    int
+	Violations []Violation
+	Unchecked  []Unchecked
+}
+
+// NewChecker returns a checker running the rules in the given order.
+func NewChecker(rules ...Rule) *Checker {
+	return &Checker{rules: rules}
+}
+
+// Check runs all rules against the product. A rule failing with an error
+// is recorded as unchecked and the other rules still run, Check only fails
+// if ctx is done.
+func (c *Checker) Check(ctx context.Context, p product.Product) error {
+	c.Checked++
+	for _, r := range c.rules {
+		msg, err := r.Check(ctx, &p)
+		if err != nil {
+			if ctx.Err() != nil {
+				return fmt.Errorf("rule %s, product %s: %w", r.Name, p.ID, err)
+			}
+			c.Unchecked = append(c.Unchecked, Unchecked{Rule: r.Name, Product: p, Err: err})
+			continue
+		}
+		if msg != nil {
+			c.Violations = append(c.Violations, Violation{Rule: r.Name, Product: p, Message: *msg})
+		}
+	}
+	return nil
+}
+
+// Counts returns the number of violations per rule, including the rules
+// without violations.
+func (c *Checker) Counts() map[string]int {
+	counts := make(map[string]int, len(c.rules))
+	for _, r := range c.rules {
+		counts[r.Name] = 0
+	}
+	for _, v := range c.Violations {
+		counts[v.Rule]++
+	}
+	return counts
+}
+
+// UncheckedCounts returns the number of products per rule the rule could
+// not be checked for, including the rules checked for all products.
+func (c *Checker) UncheckedCounts() map[string]int {
+	counts := make(map[string]int, len(c.rules))
+	for _, r := range c.rules {
+		counts[r.Name] = 0
+	}
+	for _, u := range c.Unchecked {
+		counts[u.Rule]++
+	}
+	return counts
+}
+
+// Rules returns the names of the rules in the order they run.
+func (c *Checker) Rules() []string {
+	names := make([]string, len(c.rules))
+	for i, r := range c.rules {
+		names[i] = r.Name
+	}
+	return names
+}
+
+// SoldWithinQuantity finds products that sold more items than were available.
+var SoldWithinQuantity = Rule{
+	Name: "sold-within-quantity",
+	Check: func(_ context.Context, p *product.Product) (*i18n.Message, error) {
+		if p.Sold > p.Quantity {
+			m := i18n.M("quality.sold_within_quantity", p.Sold, p.Quantity)
+			return &m, nil
+		}
+		return nil, nil
+	},
+}
+
+// RevenueMatchesSales finds products whose revenue is not the cost of the
+// items sold.
+var RevenueMatchesSales = Rule{
+	Name: "revenue-matches-sales",
+	Check: func(_ context.Context, p *product.Product) (*i18n.Message, error) {
+		want, err := p.Cost.Mul(p.Sold)
+		if err != nil {
+			m := i18n.M("quality.revenue_overflow")
+			return &m, nil
+		}
+		if p.Revenue != want {
+			m := i18n.M("quality.revenue_matches_sales", p.Revenue, want)
+			return &m, nil
+		}
+		return nil, nil
+	},
+}
+
+// UpdatedAfterCreated finds products modified before they were created.
+var UpdatedAfterCreated = Rule{
+	Name: "updated-after-created",
+	Check: func(_ context.Context, p *product.Product) (*i18n.Message, error) {
+		if p.DateUpdated.Before(p.DateCreated) {
+			m := i18n.M("quality.updated_after_created")
+			return &m, nil
+		}
+		return nil, nil
+	},
+}
+
+// UserExists returns a rule finding products whose creator can not be
+// resolved. The lookup reports whether a user exists, its answers are
+// remembered for the lifetime of the rule.
+func UserExists(lookup func(ctx context.Context, id string) (bool, error)) Rule {
+	var (
+		mu    sync.Mutex
+		known = make(map[string]bool)
+	)
+	return Rule{
+		Name: "user-exists",
+		Check: func(ctx context.Context, p *product.Product) (*i18n.Message, error) {
+			if p.UserID == "" {
+				m := i18n.M("quality.user_missing")
+				return &m, nil
+			}
+
+			mu.Lock()
+			exists, ok := known[p.UserID]
+			mu.Unlock()
+			if !ok {
+				var err error
+				if exists, err = lookup(ctx, p.UserID); err != nil {
+					return nil, err
+				}
+				mu.Lock()
+				known[p.UserID] = exists
+				mu.Unlock()
+			}
+
+			if !exists {
+				m := i18n.M("quality.user_exists", p.UserID)
+				return &m, nil
+			}
+			return nil, nil
+		},
+	}
+}
+
+// DefaultRules returns the rules checked by the report.
+func DefaultRules(userExists func(ctx context.Context, id string) (bool, error)) []Rule {
+	return []Rule{
+		SoldWithinQuantity,
+		RevenueMatchesSales,
+		UpdatedAfterCreated,
+		UserExists(userExists),
+	}
+}
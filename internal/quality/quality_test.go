@@ -0,0 +1,169 @@
+package quality
+
+import (
+	"context"
+	"errors"
+	"math"
+	"slices"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/tullo/search/internal/i18n"
+	"github.com/tullo/search/internal/product"
+)
+
+func TestRules(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2021, 3, d, 0, 0, 0, 0, time.UTC) }
+	good := product.Product{ID: "good", Cost: 500, Quantity: 10, Sold: 4, Revenue: 2000, UserID: "u1", DateCreated: day(1), DateUpdated: day(2)}
+
+	tests := []struct {
+		name   string
+		rule   Rule
+		modify func(p *product.Product)
+		want   string // message key, empty if the rule passes
+	}{
+		{"sold within quantity", SoldWithinQuantity, func(p *product.Product) {}, ""},
+		{"sold everything", SoldWithinQuantity, func(p *product.Product) { p.Sold, p.Revenue = 10, 5000 }, ""},
+		{"sold too many", SoldWithinQuantity, func(p *product.Product) { p.Sold = 11 }, "quality.sold_within_quantity"},
+		{"revenue matches", RevenueMatchesSales, func(p *product.Product) {}, ""},
+		{"revenue off", RevenueMatchesSales, func(p *product.Product) { p.Revenue = 1999 }, "quality.revenue_matches_sales"},
+		{"revenue overflow", RevenueMatchesSales, func(p *product.Product) { p.Cost = math.MaxInt64 }, "quality.revenue_overflow"},
+		{"updated later", UpdatedAfterCreated, func(p *product.Product) {}, ""},
+		{"updated at creation", UpdatedAfterCreated, func(p *product.Product) { p.DateUpdated = p.DateCreated }, ""},
+		{"updated before created", UpdatedAfterCreated, func(p *product.Product) { p.DateUpdated = day(0) }, "quality.updated_after_created"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := good
+			tt.modify(&p)
+			msg, err := tt.rule.Check(context.Background(), &p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			switch {
+			case tt.want == "" && msg != nil:
+				t.Errorf("want no violation; got %s", msg.Key)
+			case tt.want != "" && (msg == nil || msg.Key != tt.want):
+				t.Errorf("want %s; got %v", tt.want, msg)
+			}
+		})
+	}
+}
+
+func TestUserExists(t *testing.T) {
+	lookups := 0
+	errLookup := errors.New("lookup failed")
+	rule := UserExists(func(_ context.Context, id string) (bool, error) {
+		lookups++
+		if id == "broken" {
+			return false, errLookup
+		}
+		return id == "u1", nil
+	})
+
+	ctx := context.Background()
+	for _, tt := range []struct {
+		userID string
+		want   string
+	}{
+		{"u1", ""},
+		{"u2", "quality.user_exists"},
+		{"u1", ""},
+		{"u2", "quality.user_exists"},
+		{"", "quality.user_missing"},
+	} {
+		msg, err := rule.Check(ctx, &product.Product{UserID: tt.userID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := ""
+		if msg != nil {
+			got = msg.Key
+		}
+		if got != tt.want {
+			t.Errorf("user %q: want %q; got %q", tt.userID, tt.want, got)
+		}
+	}
+	if lookups != 2 {
+		t.Errorf("want 2 lookups; got %d", lookups)
+	}
+
+	if _, err := rule.Check(ctx, &product.Product{UserID: "broken"}); !errors.Is(err, errLookup) {
+		t.Errorf("want %v; got %v", errLookup, err)
+	}
+}
+
+func TestChecker(t *testing.T) {
+	catalog, err := i18n.Load(fstest.MapFS{
+		"en.json": {Data: []byte(`{"quality.revenue_matches_sales": "revenue is %s, cost times sold is %s"}`)},
+	}, "en")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mf, err := product.NewMoneyFormat("USD", "en-US")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := NewChecker(SoldWithinQuantity, RevenueMatchesSales)
+	products := []product.Product{
+		{ID: "a", Cost: 100, Quantity: 5, Sold: 2, Revenue: 200},
+		{ID: "b", Cost: 100, Quantity: 5, Sold: 2, Revenue: 150},
+		{ID: "c", Cost: 100, Quantity: 1, Sold: 2, Revenue: 300},
+	}
+	for _, p := range products {
+		if err := c.Check(context.Background(), p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if c.Checked != 3 {
+		t.Errorf("want 3 products checked; got %d", c.Checked)
+	}
+	var got []string
+	for _, v := range c.Violations {
+		got = append(got, v.Rule+":"+v.Product.ID)
+	}
+	if want := []string{"revenue-matches-sales:b", "sold-within-quantity:c", "revenue-matches-sales:c"}; !slices.Equal(got, want) {
+		t.Errorf("want violations %v; got %v", want, got)
+	}
+	counts := c.Counts()
+	if counts["sold-within-quantity"] != 1 || counts["revenue-matches-sales"] != 2 {
+		t.Errorf("unexpected counts %v", counts)
+	}
+
+	want := "revenue is $1.50, cost times sold is $2.00"
+	if got := c.Violations[0].Text(catalog.Localizer("en"), mf); got != want {
+		t.Errorf("want %q; got %q", want, got)
+	}
+
+	failing := Rule{Name: "failing", Check: func(context.Context, *product.Product) (*i18n.Message, error) {
+		return nil, errors.New("unavailable")
+	}}
+	c = NewChecker(failing, SoldWithinQuantity)
+	for _, p := range products {
+		if err := c.Check(context.Background(), p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(c.Unchecked) != 3 || c.Unchecked[1].Rule != "failing" || c.Unchecked[1].Product.ID != "b" {
+		t.Errorf("want every product unchecked by the failing rule; got %v", c.Unchecked)
+	}
+	if len(c.Violations) != 1 {
+		t.Errorf("want the other rules checked; got %v", c.Violations)
+	}
+	if counts := c.UncheckedCounts(); counts["failing"] != 3 || counts["sold-within-quantity"] != 0 {
+		t.Errorf("unexpected unchecked counts %v", counts)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	canceled := Rule{Name: "canceled", Check: func(ctx context.Context, _ *product.Product) (*i18n.Message, error) {
+		return nil, ctx.Err()
+	}}
+	if err := NewChecker(canceled).Check(ctx, products[0]); !errors.Is(err, context.Canceled) {
+		t.Errorf("want %v; got %v", context.Canceled, err)
+	}
+}
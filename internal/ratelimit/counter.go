@@ -0,0 +1,50 @@
+package ratelimit
+
+import (
+	"time"
+)
+
+// Counter allows a key up to limit requests within a sliding window, a
+// limit of 0 or less allows every request. It keeps its records in a Store
+// of its own or under keys the Limiter sharing the store does not use.
+type Counter struct {
+	store  Store
+	limit  int
+	window time.Duration
+	now    func() time.Time
+}
+
+// NewCounter constructs a Counter.
+func NewCounter(store Store, limit int, window time.Duration) *Counter {
+	return &Counter{
+		store:  store,
+		limit:  limit,
+		window: window,
+		now:    time.Now,
+	}
+}
+
+// Allow counts a request of key. A key that made limit requests within the
+// window is refused and told how long to wait, refused requests do not
+// count.
+func (c *Counter) Allow(key string) (time.Duration, bool) {
+	if c.limit <= 0 {
+		return 0, true
+	}
+	now := c.now()
+	cutoff := now.Add(-c.window)
+	var wait time.Duration
+	c.store.Update(key, c.window, func(r *Record) {
+		i := 0
+		for i < len(r.Requests) && !r.Requests[i].After(cutoff) {
+			i++
+		}
+		r.Requests = r.Requests[i:]
+		if len(r.Requests) >= c.limit {
+			wait = r.Requests[0].Sub(cutoff)
+			return
+		}
+		r.Requests = append(r.Requests, now)
+	})
+	return wait, wait == 0
+}
@@ -0,0 +1,60 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCounter(t *testing.T) {
+	now := time.Date(2020, 12, 17, 10, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+
+	store := NewMemory()
+	store.now = clock
+	c := NewCounter(store, 3, time.Minute)
+	c.now = clock
+
+	for range 2 {
+		if _, ok := c.Allow("k"); !ok {
+			t.Fatal("want the request allowed")
+		}
+	}
+	now = now.Add(20 * time.Second)
+	if _, ok := c.Allow("k"); !ok {
+		t.Fatal("want the third request allowed")
+	}
+
+	// the fourth within the window waits for the first to leave it
+	now = now.Add(10 * time.Second)
+	wait, ok := c.Allow("k")
+	if ok || wait != 30*time.Second {
+		t.Fatalf("want a wait of 30s; got %v, %t", wait, ok)
+	}
+
+	// refused requests do not count, the first two leave the window
+	now = now.Add(30 * time.Second)
+	for range 2 {
+		if _, ok := c.Allow("k"); !ok {
+			t.Fatal("want the request allowed once the first left the window")
+		}
+	}
+	if wait, ok := c.Allow("k"); ok || wait != 20*time.Second {
+		t.Fatalf("want a wait of 20s; got %v, %t", wait, ok)
+	}
+
+	// other keys are not affected
+	if _, ok := c.Allow("other"); !ok {
+		t.Fatal("want the request of another key allowed")
+	}
+}
+
+func TestCounterDisabled(t *testing.T) {
+	for _, limit := range []int{0, -1} {
+		c := NewCounter(NewMemory(), limit, time.Minute)
+		for range 3 {
+			if _, ok := c.Allow("k"); !ok {
+				t.Fatalf("limit %d: want every request allowed", limit)
+			}
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Memory is an in-process Store.
+type Memory struct {
+	mu      sync.Mutex
+	entries map[string]*entry
+	sweep   time.Time
+	now     func() time.Time
+}
+
+type entry struct {
+	rec     Record
+	expires time.Time
+}
+
+// NewMemory constructs an empty in-process Store.
+func NewMemory() *Memory {
+	return &Memory{
+		entries: make(map[string]*entry),
+		now:     time.Now,
+	}
+}
+
+// Update implements Store.
+func (m *Memory) Update(key string, ttl time.Duration, fn func(r *Record)) Record {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	m.expire(now)
+
+	e, ok := m.entries[key]
+	if !ok || now.After(e.expires) {
+		e = &entry{}
+		m.entries[key] = e
+	}
+	fn(&e.rec)
+	e.expires = now.Add(ttl)
+
+	// hand out a copy, the slices are modified under the lock only
+	rec := e.rec
+	rec.Failures = append([]time.Time(nil), e.rec.Failures...)
+	rec.Requests = append([]time.Time(nil), e.rec.Requests...)
+	return rec
+}
+
+// Delete implements Store.
+func (m *Memory) Delete(key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.entries, key)
+}
+
+// expire drops expired entries at most once a minute, so that keys of
+// one-off visitors do not accumulate.
+func (m *Memory) expire(now time.Time) {
+	if now.Before(m.sweep) {
+		return
+	}
+	m.sweep = now.Add(time.Minute)
+
+	for k, e := range m.entries {
+		if now.After(e.expires) {
+			delete(m.entries, k)
+		}
+	}
+}
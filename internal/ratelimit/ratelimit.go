@@ -0,0 +1,120 @@
+// Package ratelimit counts failed attempts per key within a sliding window
+// and locks keys out with an exponentially growing lockout. A Counter limits
+// all requests per key within a sliding window instead.
+package ratelimit
+
+import (
+	"time"
+)
+
+// Record is the state kept per key.
+type Record struct {
+	Failures    []time.Time // failures within the window, oldest first
+	Lockouts    int         // lockouts so far, drives the exponential backoff
+	LockedUntil time.Time   // zero if the key is not locked out
+	Requests    []time.Time // requests counted by a Counter, oldest first
+}
+
+// Store keeps the records. Update must apply fn atomically, so that a shared
+// store can replace the in-process one when the app runs with replicas.
+type Store interface {
+	// Update applies fn to the record of key, creating an empty one if needed,
+	// and keeps the result for at least ttl.
+	Update(key string, ttl time.Duration, fn func(r *Record)) Record
+	// Delete forgets the record of key.
+	Delete(key string)
+}
+
+// Policy configures a Limiter.
+type Policy struct {
+	Window      time.Duration // failures older than this are forgotten
+	MaxFailures int           // failures within the window that lock a key out
+	Lockout     time.Duration // first lockout, doubled for every following one
+	MaxLockout  time.Duration // upper bound of the lockout duration
+}
+
+// Status describes a key after an attempt.
+type Status struct {
+	Failures   int           // failures within the window
+	RetryAfter time.Duration // remaining lockout, zero if not locked out
+}
+
+// Locked reports whether the key is locked out.
+func (s Status) Locked() bool {
+	return s.RetryAfter > 0
+}
+
+// Limiter applies a Policy to the records of a Store.
+type Limiter struct {
+	store  Store
+	policy Policy
+	now    func() time.Time
+}
+
+// New constructs a Limiter.
+func New(store Store, policy Policy) *Limiter {
+	return &Limiter{
+		store:  store,
+		policy: policy,
+		now:    time.Now,
+	}
+}
+
+// ttl is how long a record stays relevant: a key remembers its lockouts for
+// a while after the last one, otherwise waiting would reset the backoff.
+func (l *Limiter) ttl() time.Duration {
+	return l.policy.Window + 2*l.policy.MaxLockout
+}
+
+// Status returns the current state of key without recording anything.
+func (l *Limiter) Status(key string) Status {
+	now := l.now()
+	rec := l.store.Update(key, l.ttl(), func(r *Record) {
+		r.Failures = l.prune(r.Failures, now)
+	})
+	return l.status(rec, now)
+}
+
+// Fail records a failed attempt for key. The key is locked out once it has
+// reached the maximum number of failures within the window.
+func (l *Limiter) Fail(key string) Status {
+	now := l.now()
+	rec := l.store.Update(key, l.ttl(), func(r *Record) {
+		r.Failures = append(l.prune(r.Failures, now), now)
+		if len(r.Failures) < l.policy.MaxFailures || now.Before(r.LockedUntil) {
+			return
+		}
+
+		d := l.policy.Lockout << r.Lockouts
+		if d <= 0 || d > l.policy.MaxLockout {
+			d = l.policy.MaxLockout
+		}
+		r.Lockouts++
+		r.LockedUntil = now.Add(d)
+		r.Failures = nil
+	})
+	return l.status(rec, now)
+}
+
+// Succeed forgets all failures of key.
+func (l *Limiter) Succeed(key string) {
+	l.store.Delete(key)
+}
+
+func (l *Limiter) status(rec Record, now time.Time) Status {
+	s := Status{Failures: len(rec.Failures)}
+	if now.Before(rec.LockedUntil) {
+		s.RetryAfter = rec.LockedUntil.Sub(now)
+	}
+	return s
+}
+
+// prune drops the failures that have left the window.
+func (l *Limiter) prune(failures []time.Time, now time.Time) []time.Time {
+	cutoff := now.Add(-l.policy.Window)
+	i := 0
+	for i < len(failures) && !failures[i].After(cutoff) {
+		i++
+	}
+	return failures[i:]
+}
@@ -0,0 +1,61 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	now := time.Date(2020, 12, 17, 10, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+
+	store := NewMemory()
+	store.now = clock
+	l := New(store, Policy{
+		Window:      time.Minute,
+		MaxFailures: 3,
+		Lockout:     time.Minute,
+		MaxLockout:  3 * time.Minute,
+	})
+	l.now = clock
+
+	// failures leave the sliding window again
+	l.Fail("k")
+	l.Fail("k")
+	now = now.Add(61 * time.Second)
+	if s := l.Fail("k"); s.Locked() || s.Failures != 1 {
+		t.Fatalf("want 1 failure and no lockout; got %+v", s)
+	}
+
+	// the third failure within the window locks the key out
+	l.Fail("k")
+	s := l.Fail("k")
+	if s.RetryAfter != time.Minute {
+		t.Fatalf("want lockout of 1m; got %+v", s)
+	}
+	if s := l.Status("k"); !s.Locked() {
+		t.Fatalf("want key to stay locked; got %+v", s)
+	}
+
+	// lockouts grow exponentially up to the maximum
+	for _, want := range []time.Duration{2 * time.Minute, 3 * time.Minute, 3 * time.Minute} {
+		now = now.Add(s.RetryAfter)
+		l.Fail("k")
+		l.Fail("k")
+		s = l.Fail("k")
+		if s.RetryAfter != want {
+			t.Fatalf("want lockout of %v; got %+v", want, s)
+		}
+	}
+
+	// success forgets everything
+	l.Succeed("k")
+	if s := l.Status("k"); s.Locked() || s.Failures != 0 {
+		t.Fatalf("want clean state; got %+v", s)
+	}
+
+	// other keys are not affected
+	if s := l.Fail("other"); s.Failures != 1 {
+		t.Fatalf("want 1 failure; got %+v", s)
+	}
+}
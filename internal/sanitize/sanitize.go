@@ -0,0 +1,132 @@
+// Package sanitize renders untrusted text as HTML using an allowlist of
+// inline formatting elements.
+package sanitize
+
+import (
+	"html"
+	"html/template"
+	"regexp"
+	"strings"
+)
+
+// Policy is an allowlist of elements that survive sanitizing. Attributes are
+// never allowed, so an allowed element can not carry scripts or styles.
+type Policy struct {
+	allowed map[string]bool
+}
+
+// NewPolicy constructs a policy allowing the given element names.
+func NewPolicy(elements ...string) *Policy {
+	p := Policy{allowed: make(map[string]bool, len(elements))}
+	for _, e := range elements {
+		p.allowed[strings.ToLower(e)] = true
+	}
+	return &p
+}
+
+// Inline allows basic inline formatting only.
+var Inline = NewPolicy("b", "strong", "i", "em", "u", "small", "sub", "sup", "code")
+
+// tagRX matches anything that looks like a tag, elementRX a bare element
+// without attributes.
+var (
+	tagRX     = regexp.MustCompile(`<[^<>]*>`)
+	elementRX = regexp.MustCompile(`^<(/?)([a-zA-Z][a-zA-Z0-9]*)\s*/?>$`)
+)
+
+// HTML decodes entities in s and escapes the result, except for the elements
+// allowed by the policy. Unclosed allowed elements are closed, stray closing
+// tags are dropped.
+func (p *Policy) HTML(s string) template.HTML {
+	var b strings.Builder
+	p.walk(s, func(t string) {
+		b.WriteString(html.EscapeString(t))
+	}, func(tag string) {
+		b.WriteString(tag)
+	})
+	return template.HTML(b.String())
+}
+
+// Text returns the text the HTML of s shows, entities decoded, for places
+// that can only show plain text such as the page title, attribute values or
+// exports. Elements the policy does not allow stay literal text.
+func (p *Policy) Text(s string) string {
+	var b strings.Builder
+	p.walk(s, func(t string) { b.WriteString(t) }, func(string) {})
+	return b.String()
+}
+
+// Highlight renders s like HTML and marks the parts of its text in the
+// byte ranges of marks, which index the string returned by Text. The
+// ranges have to be ordered and must not overlap. A mark ends at every
+// element, so that the elements nest.
+func (p *Policy) Highlight(s string, marks [][2]int) template.HTML {
+	var b strings.Builder
+	at := 0
+	p.walk(s, func(t string) {
+		from, to := at, at+len(t)
+		for _, m := range marks {
+			if m[1] <= from || m[0] >= to {
+				continue
+			}
+			start, end := max(m[0], from), min(m[1], to)
+			b.WriteString(html.EscapeString(t[from-at : start-at]))
+			b.WriteString("<mark>" + html.EscapeString(t[start-at:end-at]) + "</mark>")
+			from = end
+		}
+		b.WriteString(html.EscapeString(t[from-at:]))
+		at = to
+	}, func(tag string) {
+		b.WriteString(tag)
+	})
+	return template.HTML(b.String())
+}
+
+// walk hands the decoded text of s and the markup of its allowed elements
+// to text and tag in order. Unclosed allowed elements are closed, stray
+// closing tags are dropped.
+func (p *Policy) walk(s string, text, tag func(string)) {
+	var open []string
+
+	emit := func(t string) {
+		if t != "" {
+			text(html.UnescapeString(t))
+		}
+	}
+
+	last := 0
+	for _, loc := range tagRX.FindAllStringIndex(s, -1) {
+		emit(s[last:loc[0]])
+		last = loc[1]
+
+		m := elementRX.FindStringSubmatch(s[loc[0]:loc[1]])
+		if m == nil || !p.allowed[strings.ToLower(m[2])] {
+			emit(s[loc[0]:loc[1]])
+			continue
+		}
+
+		name := strings.ToLower(m[2])
+		if m[1] == "" {
+			open = append(open, name)
+			tag("<" + name + ">")
+			continue
+		}
+
+		// close the element and anything left open inside of it
+		for i := len(open) - 1; i >= 0; i-- {
+			if open[i] != name {
+				continue
+			}
+			for j := len(open) - 1; j >= i; j-- {
+				tag("</" + open[j] + ">")
+			}
+			open = open[:i]
+			break
+		}
+	}
+	emit(s[last:])
+
+	for i := len(open) - 1; i >= 0; i-- {
+		tag("</" + open[i] + ">")
+	}
+}
@@ -0,0 +1,82 @@
+package sanitize
+
+import (
+	"html/template"
+	"testing"
+)
+
+func TestInlineHTML(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want template.HTML
+	}{
+		{"Plain", "McDonalds Toys", "McDonalds Toys"},
+		{"Entities", "Tom &amp; Jerry &quot;Deluxe&quot;", "Tom &amp; Jerry &#34;Deluxe&#34;"},
+		{"Raw ampersand", "Salt & Pepper", "Salt &amp; Pepper"},
+		{"Allowed", "Comic <b>Sans</b>", "Comic <b>Sans</b>"},
+		{"Case", "<EM>big</EM>", "<em>big</em>"},
+		{"Script", "<script>alert(1)</script>Toy", "&lt;script&gt;alert(1)&lt;/script&gt;Toy"},
+		{"Attributes", `<b onclick="x()">Toy</b>`, "&lt;b onclick=&#34;x()&#34;&gt;Toy"},
+		{"Image", `<img src=x onerror=alert(1)>`, "&lt;img src=x onerror=alert(1)&gt;"},
+		{"Encoded markup stays text", "&lt;script&gt;", "&lt;script&gt;"},
+		{"Unclosed", "<i>open <b>bold", "<i>open <b>bold</b></i>"},
+		{"Stray close", "done</b>", "done"},
+		{"Misnested", "<b><i>x</b>y</i>", "<b><i>x</i></b>y"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Inline.HTML(tt.in); got != tt.want {
+				t.Errorf("want %q; got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestInlineText(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"Tom &amp; Jerry", "Tom & Jerry"},
+		{"Comic <b>Sans</b>", "Comic Sans"},
+		{"Comic <B>Sans", "Comic Sans"},
+		{"a <img src=x> b", "a <img src=x> b"},
+		{"<script>alert(1)</script>", "<script>alert(1)</script>"},
+	}
+
+	for _, tt := range tests {
+		if got := Inline.Text(tt.in); got != tt.want {
+			t.Errorf("want %q; got %q", tt.want, got)
+		}
+	}
+}
+
+func TestInlineHighlight(t *testing.T) {
+	tests := []struct {
+		name  string
+		in    string
+		marks [][2]int
+		text  string
+		want  template.HTML
+	}{
+		{"None", "McDonalds Toys", nil, "McDonalds Toys", "McDonalds Toys"},
+		{"Plain", "McDonalds Toys", [][2]int{{0, 2}, {10, 14}}, "McDonalds Toys", "<mark>Mc</mark>Donalds <mark>Toys</mark>"},
+		{"Entities", "Tom &amp; Jerry", [][2]int{{4, 5}}, "Tom & Jerry", "Tom <mark>&amp;</mark> Jerry"},
+		{"Across elements", "Comic <b>Sans</b> Serif", [][2]int{{3, 8}}, "Comic Sans Serif", "Com<mark>ic </mark><b><mark>Sa</mark>ns</b> Serif"},
+		{"Markup stays text", "<script>Toy", [][2]int{{8, 11}}, "<script>Toy", "&lt;script&gt;<mark>Toy</mark>"},
+		{"Marked markup", "<img src=x>", [][2]int{{0, 4}}, "<img src=x>", "<mark>&lt;img</mark> src=x&gt;"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Inline.Text(tt.in); got != tt.text {
+				t.Errorf("text: want %q; got %q", tt.text, got)
+			}
+			if got := Inline.Highlight(tt.in, tt.marks); got != tt.want {
+				t.Errorf("want %q; got %q", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+// Package suggest completes the beginnings of names from a prefix trie.
+// Every node keeps its best completions, so that a lookup costs no more
+// than walking the prefix.
+package suggest
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/tullo/search/internal/fuzzy"
+)
+
+// Entry is a name that can be suggested. Entries with a higher Weight are
+// suggested first.
+type Entry struct {
+	Name   string
+	Weight int
+}
+
+// Trie suggests the names of its entries whose name, or any word of it,
+// starts with a prefix. A Trie is immutable once built and safe for
+// concurrent use.
+type Trie struct {
+	root *node
+	size int
+	top  int
+}
+
+type node struct {
+	children map[rune]*node
+	best     []*Entry // best completions, heaviest first
+}
+
+// Build returns a trie over entries keeping up to top completions per
+// prefix. Entries with the same name, ignoring case and accents, are
+// merged and their weights added up.
+func Build(entries []Entry, top int) *Trie {
+	merged := make(map[string]*Entry, len(entries))
+	var unique []*Entry
+	for _, e := range entries {
+		key := Normalize(e.Name)
+		if key == "" {
+			continue
+		}
+		if m, ok := merged[key]; ok {
+			m.Weight += e.Weight
+			continue
+		}
+		m := e
+		merged[key] = &m
+		unique = append(unique, &m)
+	}
+
+	// inserted heaviest first, a node is full with its first top entries
+	slices.SortStableFunc(unique, func(a, b *Entry) int {
+		if c := cmp.Compare(b.Weight, a.Weight); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Name, b.Name)
+	})
+
+	t := Trie{root: &node{}, size: len(unique), top: top}
+	for _, e := range unique {
+		key := []rune(Normalize(e.Name))
+		for i := range key {
+			// a name is found by the start of each of its words
+			if i == 0 || (!isWordRune(key[i-1]) && isWordRune(key[i])) {
+				t.insert(key[i:], e)
+			}
+		}
+	}
+	return &t
+}
+
+func (t *Trie) insert(key []rune, e *Entry) {
+	n := t.root
+	for _, r := range key {
+		child, ok := n.children[r]
+		if !ok {
+			if n.children == nil {
+				n.children = make(map[rune]*node)
+			}
+			child = &node{}
+			n.children[r] = child
+		}
+		n = child
+		if len(n.best) < t.top && !slices.Contains(n.best, e) {
+			n.best = append(n.best, e)
+		}
+	}
+}
+
+// Complete returns up to n names starting with prefix, heaviest first.
+func (t *Trie) Complete(prefix string, n int) []Entry {
+	key := Normalize(prefix)
+	if t == nil || key == "" || n <= 0 {
+		return nil
+	}
+	node := t.root
+	for _, r := range key {
+		if node = node.children[r]; node == nil {
+			return nil
+		}
+	}
+	best := node.best[:min(n, len(node.best))]
+	entries := make([]Entry, len(best))
+	for i, e := range best {
+		entries[i] = *e
+	}
+	return entries
+}
+
+// Len returns the number of distinct names.
+func (t *Trie) Len() int {
+	if t == nil {
+		return 0
+	}
+	return t.size
+}
+
+// Normalize folds the case of s, strips its accents and collapses its
+// white space, names and prefixes are compared in this form.
+func Normalize(s string) string {
+	return strings.Join(strings.Fields(fuzzy.Fold(s)), " ")
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
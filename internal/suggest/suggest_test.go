@@ -0,0 +1,87 @@
+package suggest
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestComplete(t *testing.T) {
+	trie := Build([]Entry{
+		{Name: "McDonalds Toys", Weight: 3},
+		{Name: "Comic Books", Weight: 7},
+		{Name: "Board Games", Weight: 1},
+		{Name: "Crème Brûlée", Weight: 2},
+		{Name: "comic  books", Weight: 2},
+		{Name: "Comics Collection", Weight: 8},
+		{Name: "   ", Weight: 100},
+	}, 3)
+
+	tests := []struct {
+		prefix string
+		n      int
+		want   []string
+	}{
+		{"co", 5, []string{"Comic Books", "Comics Collection"}},
+		{"Com", 1, []string{"Comic Books"}},
+		{"comic b", 5, []string{"Comic Books"}},
+		{"toy", 5, []string{"McDonalds Toys"}},
+		{"brule", 5, []string{"Crème Brûlée"}},
+		{"CRÈME", 5, []string{"Crème Brûlée"}},
+		{"b", 5, []string{"Comic Books", "Crème Brûlée", "Board Games"}},
+		{"", 5, nil},
+		{"x", 5, nil},
+		{"co", 0, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%q/%d", tt.prefix, tt.n), func(t *testing.T) {
+			var got []string
+			for _, e := range trie.Complete(tt.prefix, tt.n) {
+				got = append(got, e.Name)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("want %q; got %q", tt.want, got)
+			}
+		})
+	}
+
+	if n := trie.Len(); n != 5 {
+		t.Errorf("want 5 distinct names; got %d", n)
+	}
+	if e := trie.Complete("comic b", 1); len(e) != 1 || e[0].Weight != 9 {
+		t.Errorf("want the weights of the same name added up; got %v", e)
+	}
+}
+
+func TestCompleteLarge(t *testing.T) {
+	entries := make([]Entry, 10000)
+	for i := range entries {
+		entries[i] = Entry{Name: fmt.Sprintf("Product %d Deluxe Edition", i), Weight: i % 1000}
+	}
+	trie := Build(entries, 10)
+
+	start := time.Now()
+	const lookups = 1000
+	for i := range lookups {
+		if got := trie.Complete(fmt.Sprintf("product %d", i%9+1), 10); len(got) != 10 {
+			t.Fatalf("want 10 completions; got %d", len(got))
+		}
+	}
+	if d := time.Since(start) / lookups; d > 5*time.Millisecond {
+		t.Errorf("want a lookup in under 5ms; took %v", d)
+	}
+}
+
+func BenchmarkComplete(b *testing.B) {
+	entries := make([]Entry, 50000)
+	for i := range entries {
+		entries[i] = Entry{Name: fmt.Sprintf("Product %d Deluxe Edition", i), Weight: i % 1000}
+	}
+	trie := Build(entries, 10)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		trie.Complete("product 12", 10)
+	}
+}
@@ -0,0 +1,86 @@
+// Package watch detects changes of files by polling, which works on every
+// file system and needs no platform specific notification API.
+package watch
+
+import (
+	"context"
+	"io/fs"
+	"sort"
+	"time"
+)
+
+// stamp identifies a version of a file.
+type stamp struct {
+	size int64
+	mod  time.Time
+}
+
+// Poll calls onChange with the paths of the files added, removed or
+// modified below the dirs of fsys, checking every interval until ctx is
+// done. The dirs must exist when Poll starts, a check failing later on is
+// retried with the next one.
+func Poll(ctx context.Context, fsys fs.FS, interval time.Duration, dirs []string, onChange func(paths []string)) error {
+	last, err := snapshot(fsys, dirs)
+	if err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		next, err := snapshot(fsys, dirs)
+		if err != nil {
+			// e.g. an editor replacing a file while we walk
+			continue
+		}
+		if changed := diff(last, next); len(changed) > 0 {
+			onChange(changed)
+		}
+		last = next
+	}
+}
+
+// snapshot stamps every regular file below the dirs.
+func snapshot(fsys fs.FS, dirs []string) (map[string]stamp, error) {
+	files := make(map[string]stamp)
+	for _, dir := range dirs {
+		err := fs.WalkDir(fsys, dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || !d.Type().IsRegular() {
+				return err
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			files[path] = stamp{size: info.Size(), mod: info.ModTime()}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// diff returns the sorted paths that differ between the snapshots.
+func diff(old, new map[string]stamp) []string {
+	var changed []string
+	for path, s := range new {
+		if o, ok := old[path]; !ok || o.size != s.size || !o.mod.Equal(s.mod) {
+			changed = append(changed, path)
+		}
+	}
+	for path := range old {
+		if _, ok := new[path]; !ok {
+			changed = append(changed, path)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
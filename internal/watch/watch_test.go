@@ -0,0 +1,88 @@
+package watch
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestPoll(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("html/a.tmpl", "a")
+	write("html/b.tmpl", "b")
+	write("other/c.txt", "c")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	changes := make(chan []string)
+	done := make(chan error)
+	go func() {
+		done <- Poll(ctx, os.DirFS(dir), 5*time.Millisecond, []string{"html"}, func(paths []string) {
+			changes <- paths
+		})
+	}()
+	next := func() []string {
+		t.Helper()
+		select {
+		case paths := <-changes:
+			return paths
+		case <-time.After(time.Second):
+			t.Fatal("no change detected")
+			return nil
+		}
+	}
+
+	// let the first snapshot be taken before anything changes
+	time.Sleep(20 * time.Millisecond)
+
+	write("html/a.tmpl", "a changed")
+	if got := next(); !slices.Equal(got, []string{"html/a.tmpl"}) {
+		t.Errorf("want the modified file; got %v", got)
+	}
+
+	write("html/sub/new.tmpl", "new")
+	if err := os.Remove(filepath.Join(dir, "html/b.tmpl")); err != nil {
+		t.Fatal(err)
+	}
+	got := next()
+	// the two changes may be seen in one check or in two
+	if len(got) == 1 {
+		got = append(got, next()...)
+		slices.Sort(got)
+	}
+	if !slices.Equal(got, []string{"html/b.tmpl", "html/sub/new.tmpl"}) {
+		t.Errorf("want the added and removed files; got %v", got)
+	}
+
+	// files outside the watched dirs are ignored
+	write("other/c.txt", "c changed")
+	select {
+	case paths := <-changes:
+		t.Errorf("want no change; got %v", paths)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPollMissingDir(t *testing.T) {
+	err := Poll(context.Background(), os.DirFS(t.TempDir()), time.Millisecond, []string{"html"}, func([]string) {})
+	if err == nil {
+		t.Error("want an error for a missing dir")
+	}
+}
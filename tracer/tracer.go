@@ -2,7 +2,7 @@ package tracer
 
 import (
 	"context"
-	"log"
+	"log/slog"
 
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/exporters/zipkin"
@@ -12,8 +12,9 @@ import (
 )
 
 // Init creates a new trace provider instance and registers it as global trace provider.
-func Init(serviceName string, reporterURI string, probability float64, log *log.Logger) (func(context.Context) error, error) {
-	exporter, err := zipkin.New(reporterURI, zipkin.WithLogger(log))
+func Init(serviceName string, reporterURI string, probability float64, log *slog.Logger) (func(context.Context) error, error) {
+	// The zipkin exporter only reports failures through a standard logger.
+	exporter, err := zipkin.New(reporterURI, zipkin.WithLogger(slog.NewLogLogger(log.Handler(), slog.LevelError)))
 	if err != nil {
 		return nil, err
 	}
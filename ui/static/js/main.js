@@ -5,4 +5,351 @@ for (var i = 0; i < navLinks.length; i++) {
 		link.classList.add("live");
 		break;
 	}
-}
\ No newline at end of file
+}
+
+// Report the time zone of the browser on the first visit, so that dates
+// are rendered in local time from the next page on.
+(function () {
+	var root = document.documentElement;
+	var token = document.querySelector("input[name='csrf_token']");
+	if (root.dataset.timezone || !token || !window.Intl || !window.fetch) {
+		return;
+	}
+	var tz = Intl.DateTimeFormat().resolvedOptions().timeZone;
+	if (!tz) {
+		return;
+	}
+	var body = new URLSearchParams();
+	body.append("tz", tz);
+	body.append("auto", "1");
+	body.append("csrf_token", token.value);
+	fetch("/user/timezone", {method: "POST", body: body, credentials: "same-origin"});
+})();
+
+// Reload the page when the templates or static assets change, the server
+// only announces the event stream in debug mode.
+(function () {
+	var path = document.documentElement.dataset.livereload;
+	if (!path || !window.EventSource) {
+		return;
+	}
+	var events = new EventSource(path);
+	events.addEventListener("reload", function () {
+		events.close();
+		window.location.reload();
+	});
+})();
+
+// Sort, page and search the product listing in place. The server renders
+// just the listing for requests with the HX-Request header, without
+// JavaScript the links and the search form load whole pages.
+(function () {
+	var listing = document.getElementById("listing");
+	var form = document.querySelector("form.search");
+	if (!listing || !form || !window.fetch || !window.URL || !window.AbortController) {
+		return;
+	}
+	var search = form.querySelector("input[name='q']");
+	var pending, timer;
+
+	// the search form keeps the sort order of the listing shown
+	function syncSort(url) {
+		var sort = url.searchParams.get("sort");
+		var input = form.querySelector("input[name='sort']");
+		if (sort && !input) {
+			input = document.createElement("input");
+			input.type = "hidden";
+			input.name = "sort";
+			form.insertBefore(input, form.lastElementChild);
+		}
+		if (input && sort) {
+			input.value = sort;
+		} else if (input) {
+			input.parentNode.removeChild(input);
+		}
+	}
+
+	// load replaces the listing with the one of url. history is "push",
+	// "replace" or "none" for navigating back and forth.
+	function load(url, history) {
+		if (pending) {
+			pending.abort();
+		}
+		pending = new AbortController();
+		listing.setAttribute("aria-busy", "true");
+		fetch(url.href, {
+			headers: {"HX-Request": "true", "HX-Target": "listing"},
+			credentials: "same-origin",
+			signal: pending.signal
+		}).then(function (res) {
+			if (!res.ok) {
+				throw new Error(res.status);
+			}
+			return res.text();
+		}).then(function (html) {
+			listing.innerHTML = html;
+			listing.removeAttribute("aria-busy");
+			syncSort(url);
+			if (history === "push") {
+				window.history.pushState(null, "", url.href);
+			} else if (history === "replace") {
+				window.history.replaceState(null, "", url.href);
+			}
+		}).catch(function (err) {
+			if (err.name !== "AbortError") {
+				// the whole page shows what went wrong
+				window.location.href = url.href;
+			}
+		});
+	}
+
+	function searchURL() {
+		var url = new URL(form.action);
+		url.search = new URLSearchParams(new FormData(form)).toString();
+		if (!url.searchParams.get("q")) {
+			url.searchParams.delete("q");
+		}
+		return url;
+	}
+
+	listing.addEventListener("click", function (e) {
+		var a = e.target.closest("a");
+		if (!a || a.hasAttribute("download") || e.button !== 0 || e.metaKey || e.ctrlKey || e.shiftKey || e.altKey) {
+			return;
+		}
+		var url = new URL(a.href);
+		if (url.origin !== window.location.origin || url.pathname !== new URL(form.action).pathname) {
+			return;
+		}
+		e.preventDefault();
+		// the links offered for a search finding nothing search anew
+		search.value = url.searchParams.get("q") || "";
+		load(url, "push");
+		listing.focus();
+	});
+
+	search.addEventListener("input", function () {
+		clearTimeout(timer);
+		timer = setTimeout(function () {
+			load(searchURL(), "replace");
+		}, 250);
+	});
+
+	form.addEventListener("submit", function (e) {
+		e.preventDefault();
+		clearTimeout(timer);
+		load(searchURL(), "push");
+	});
+
+	window.addEventListener("popstate", function () {
+		var url = new URL(window.location.href);
+		search.value = url.searchParams.get("q") || "";
+		load(url, "none");
+	});
+})();
+
+// Patch the rows of the listing with the changes the server streams. New
+// and deleted products, and rows of a slow stream, are fetched anew since
+// the sort order and paging decide where they go.
+(function () {
+	var listing = document.getElementById("listing");
+	if (!listing || !listing.dataset.events || !window.EventSource || !window.fetch) {
+		return;
+	}
+	var timer;
+
+	function reloadRows() {
+		clearTimeout(timer);
+		timer = setTimeout(function () {
+			fetch(window.location.href, {
+				headers: {"HX-Request": "true", "HX-Target": "rows"},
+				credentials: "same-origin"
+			}).then(function (res) {
+				return res.ok ? res.text() : null;
+			}).then(function (html) {
+				var rows = document.getElementById("rows");
+				if (html !== null && rows) {
+					rows.innerHTML = html;
+				}
+			});
+		}, 100);
+	}
+
+	function patch(row, change) {
+		var fields = ["cost", "quantity", "sold", "revenue"];
+		for (var i = 0; i < fields.length; i++) {
+			var cell = row.querySelector("[data-field='" + fields[i] + "']");
+			if (cell && cell.textContent !== String(change[fields[i]])) {
+				cell.textContent = change[fields[i]];
+			}
+		}
+		var name = row.querySelector("[data-field='name'] a");
+		var shown = document.createElement("template");
+		// sanitized by the server like the name in the page
+		shown.innerHTML = change.name;
+		if (name && name.textContent !== shown.content.textContent) {
+			// the search may no longer match, and marks the name anew
+			reloadRows();
+		}
+		row.classList.remove("changed");
+		void row.offsetWidth;
+		row.classList.add("changed");
+	}
+
+	var events = new EventSource(listing.dataset.events);
+	events.addEventListener("products", function (e) {
+		var changes = JSON.parse(e.data);
+		for (var i = 0; i < changes.length; i++) {
+			var c = changes[i];
+			var row = listing.querySelector("tr[data-id='" + c.id + "']");
+			if (c.op === "updated" && row) {
+				patch(row, c);
+			} else if (c.op !== "updated") {
+				reloadRows();
+			}
+		}
+	});
+	events.addEventListener("reset", reloadRows);
+})();
+
+// Complete the search box with the names of the best selling products. The
+// input becomes a combobox: the arrow keys move through the suggestions,
+// Enter searches for the one chosen and Escape closes the list.
+(function () {
+	var input = document.querySelector("input[data-suggest]");
+	var list = document.getElementById("suggestions");
+	if (!input || !list || !window.fetch || !window.URL || !window.AbortController) {
+		return;
+	}
+	var pending, timer, active = -1;
+
+	input.setAttribute("role", "combobox");
+	input.setAttribute("aria-autocomplete", "list");
+	input.setAttribute("aria-expanded", "false");
+	input.setAttribute("aria-controls", input.getAttribute("aria-controls") + " suggestions");
+	input.setAttribute("autocomplete", "off");
+
+	function close() {
+		list.hidden = true;
+		list.innerHTML = "";
+		active = -1;
+		input.setAttribute("aria-expanded", "false");
+		input.removeAttribute("aria-activedescendant");
+	}
+
+	function show(names) {
+		close();
+		if (names.length === 0) {
+			return;
+		}
+		for (var i = 0; i < names.length; i++) {
+			var li = document.createElement("li");
+			li.id = "suggestion-" + i;
+			li.setAttribute("role", "option");
+			li.setAttribute("aria-selected", "false");
+			li.textContent = names[i];
+			list.appendChild(li);
+		}
+		list.hidden = false;
+		input.setAttribute("aria-expanded", "true");
+	}
+
+	function move(to) {
+		var options = list.children;
+		if (active >= 0) {
+			options[active].setAttribute("aria-selected", "false");
+		}
+		active = (to + options.length) % options.length;
+		options[active].setAttribute("aria-selected", "true");
+		input.setAttribute("aria-activedescendant", options[active].id);
+	}
+
+	function choose(li) {
+		input.value = li.textContent;
+		close();
+		if (input.form.requestSubmit) {
+			input.form.requestSubmit();
+		} else {
+			input.form.submit();
+		}
+	}
+
+	function suggest() {
+		if (pending) {
+			pending.abort();
+		}
+		var q = input.value.trim();
+		if (!q) {
+			close();
+			return;
+		}
+		pending = new AbortController();
+		var url = new URL(input.dataset.suggest, window.location.href);
+		url.searchParams.set("q", q);
+		fetch(url.href, {
+			headers: {"Accept": "application/json"},
+			credentials: "same-origin",
+			signal: pending.signal
+		}).then(function (res) {
+			// too many requests only skip a suggestion
+			return res.ok ? res.json() : null;
+		}).then(function (body) {
+			if (body && document.activeElement === input) {
+				show(body.data.map(function (s) { return s.name; }));
+			}
+		}).catch(function () {});
+	}
+
+	input.addEventListener("input", function () {
+		clearTimeout(timer);
+		timer = setTimeout(suggest, 150);
+	});
+
+	input.addEventListener("keydown", function (e) {
+		if (list.hidden) {
+			if (e.key === "ArrowDown" && input.value.trim()) {
+				e.preventDefault();
+				suggest();
+			}
+			return;
+		}
+		switch (e.key) {
+		case "ArrowDown":
+			e.preventDefault();
+			move(active + 1);
+			break;
+		case "ArrowUp":
+			e.preventDefault();
+			move(active - 1);
+			break;
+		case "Enter":
+			if (active >= 0) {
+				e.preventDefault();
+				choose(list.children[active]);
+			}
+			break;
+		case "Escape":
+			e.preventDefault();
+			close();
+			break;
+		}
+	});
+
+	// mousedown comes before the blur closing the list
+	list.addEventListener("mousedown", function (e) {
+		var li = e.target.closest("li");
+		if (li) {
+			e.preventDefault();
+			choose(li);
+		}
+	});
+
+	input.addEventListener("blur", close);
+	input.form.addEventListener("submit", function () {
+		clearTimeout(timer);
+		if (pending) {
+			pending.abort();
+		}
+		close();
+	});
+})();
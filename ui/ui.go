@@ -0,0 +1,10 @@
+// Package ui holds the templates, message catalogs and static assets of the
+// web application, embedded into the binary.
+package ui
+
+import "embed"
+
+// Files holds the html, locales and static directories.
+//
+//go:embed html locales static
+var Files embed.FS